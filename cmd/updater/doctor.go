@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/krezh/charts/internal/common"
+	"github.com/krezh/charts/internal/git"
+	"github.com/krezh/charts/internal/packager"
+	ghup "github.com/krezh/charts/internal/updater/github"
+)
+
+// doctorCheck is one preflight check, run returns what it found on success
+type doctorCheck struct {
+	name string
+	run  func(ctx context.Context) (string, error)
+}
+
+// DoctorMode runs preflight checks of everything update and publish mode depend on and prints
+// a pass/fail report, nothing is changed, fails when at least one check fails
+func DoctorMode(mainCtx context.Context, config *common.Config) error {
+	checks := []doctorCheck{
+		{name: "git repository", run: func(context.Context) (string, error) {
+			gitRepo, err := git.NewClient(".")
+			if err != nil {
+				return "", err
+			}
+			url, err := gitRepo.OriginURL()
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s is %s", git.RemoteOrigin, url), nil
+		}},
+		{name: "github token", run: func(ctx context.Context) (string, error) {
+			if err := ghup.CheckToken(ctx, &config.PullRequest); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("can read %s/%s", config.PullRequest.Owner, config.PullRequest.Repo), nil
+		}},
+		writableCheck("srcDir", config.Helm.SrcDir),
+		writableCheck("targetDir", config.Helm.TargetDir),
+		registryCheck("remote", config.Helm.Remote, &config.Helm.RemoteAuth),
+	}
+	if config.Helm.CrdRemote != "" {
+		checks = append(checks, registryCheck("crdRemote", config.Helm.CrdRemote, &config.Helm.RemoteAuth))
+	}
+	for _, chartName := range slices.Sorted(maps.Keys(config.Helm.ChartRemotes)) {
+		checks = append(checks, registryCheck("chartRemotes."+chartName, config.Helm.ChartRemotes[chartName], &config.Helm.RemoteAuth))
+	}
+
+	failed := 0
+	for _, check := range checks {
+		if mainCtx.Err() != nil {
+			return runCancelled(mainCtx)
+		}
+		ctx, cancel := context.WithTimeout(mainCtx, 30*time.Second)
+		result, err := check.run(ctx)
+		cancel()
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL %s: %v\n", check.name, err)
+			continue
+		}
+		fmt.Printf("PASS %s: %s\n", check.name, result)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d doctor checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+func writableCheck(name, dir string) doctorCheck {
+	return doctorCheck{name: name, run: func(context.Context) (string, error) {
+		if dir == "" {
+			return "", fmt.Errorf("helm.%s is not configured", name)
+		}
+		if err := common.CheckWritable(dir); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s is writable", dir), nil
+	}}
+}
+
+func registryCheck(name, remote string, auth *common.RemoteAuth) doctorCheck {
+	return doctorCheck{name: name, run: func(ctx context.Context) (string, error) {
+		if err := packager.CheckRegistry(ctx, remote, auth); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s is reachable", remote), nil
+	}}
+}
@@ -2,17 +2,25 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand/v2"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/krezh/charts/internal/common"
 	"github.com/krezh/charts/internal/git"
 	"github.com/krezh/charts/internal/packager"
 	ghup "github.com/krezh/charts/internal/updater/github"
+	"github.com/sirupsen/logrus"
 )
 
 func main() {
@@ -22,11 +30,34 @@ func main() {
 		return
 	}
 	common.Setup(config.Log.Level)
+	common.SetUserAgent(config.UserAgent)
+	if err := common.SetupProxy(&config.Proxy); err != nil {
+		common.Log.Fatalf("Invalid proxy configuration: %v", err)
+	}
+
+	// SIGINT/SIGTERM and the deadline cancel the run, in-flight work observes ctx and stops
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if config.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Deadline)
+		defer cancel()
+	}
 
-	if config.ModeOfOperation == common.ModeUpdate {
-		err = UpdateMode(config)
-	} else {
-		err = PublishMode(config)
+	switch config.ModeOfOperation {
+	case common.ModeUpdate:
+		err = UpdateMode(ctx, config)
+	case common.ModeCheck:
+		err = CheckMode(ctx, config)
+	case common.ModeDoctor:
+		err = DoctorMode(ctx, config)
+	case common.ModeDelete:
+		err = DeleteMode(ctx, config)
+	default:
+		err = PublishMode(ctx, config)
+	}
+	if err == nil && ctx.Err() != nil {
+		err = runCancelled(ctx)
 	}
 	if err != nil {
 		common.Log.Fatalf("Mode %s failed: %v", config.ModeOfOperation, err)
@@ -34,59 +65,231 @@ func main() {
 	}
 }
 
-func UpdateMode(config *common.Config) error {
-	mainCtx := context.Background()
+// waitLaunchDelay waits delay plus a random share of jitter before the next release starts,
+// it returns false when the run is cancelled meanwhile
+func waitLaunchDelay(ctx context.Context, delay, jitter time.Duration) bool {
+	if jitter > 0 {
+		delay += rand.N(jitter)
+	}
+	if delay <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// runCancelled tells whether the run hit its deadline or was interrupted by a signal
+func runCancelled(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("run exceeded its deadline: %w", ctx.Err())
+	}
+	return fmt.Errorf("run interrupted: %w", ctx.Err())
+}
+
+func UpdateMode(mainCtx context.Context, config *common.Config) error {
 	var wg sync.WaitGroup
 	createdCharts := make(chan *packager.HelmizedManifests, len(config.Releases))
 
-	gitRepo, err := git.NewClient(".")
+	switch config.Helm.OutputFormat {
+	case "", common.OutputFormatHelm, common.OutputFormatManifests, common.OutputFormatKustomize:
+	default:
+		return fmt.Errorf("unknown outputFormat '%s'", config.Helm.OutputFormat)
+	}
+	if config.Helm.RawOutput() && (config.Output != "" || len(config.Umbrellas) > 0) {
+		return fmt.Errorf("outputFormat %s supports neither --output nor umbrellas, both need Helm charts", config.Helm.OutputFormat)
+	}
+
+	if config.RequestDelay < 0 || config.RequestJitter < 0 {
+		return fmt.Errorf("requestDelay and requestJitter must not be negative")
+	}
+	versionSuffix, err := resolveVersionSuffix(config.Helm.VersionSuffix, time.Now())
 	if err != nil {
 		return err
 	}
+	config.Helm.VersionSuffix = versionSuffix
 
-	for _, release := range config.Releases {
+	// charts are built in a scratch dir and only complete charts are installed, an interrupted run never
+	// leaves a half-written chart behind, charts destined for output, diff or a dry-run are never installed,
+	// gated charts are installed into SrcDir only when materially changed, charts to commit are installed
+	// only once their branch is checked out, so no branch sees the charts of another
+	install := config.Output == "" && !config.Diff && !config.DryRun
+	skipVersionOnly := config.PullRequest.SkipVersionOnlyChanges && install
+	helmSettings := config.Helm
+	tmpDir, err := os.MkdirTemp("", "charts-output-")
+	if err != nil {
+		return fmt.Errorf("failed to create output build directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	helmSettings.OutputDir = tmpDir
+	helmSettings.TargetDir = filepath.Join(tmpDir, "target")
+
+	// charts with an update branch left by an earlier, partially completed run are skipped before any work
+	commits := config.Output == "" && !config.Diff && !config.Offline
+	releases := config.Releases
+	var gitRepo *git.Client
+	if commits {
+		var err error
+		gitRepo, err = git.NewClient(".")
+		if err != nil {
+			return err
+		}
+		gitRepo.OnUnstagedChanges = config.PullRequest.OnUnstagedChanges
+		// generated charts, published artifacts and cached assets may live inside the worktree
+		gitRepo.KeepPaths = []string{config.Helm.BuildDir(), config.Helm.TargetDir, config.Helm.CacheDir}
+		if config.Changelog != "" {
+			gitRepo.KeepPaths = append(gitRepo.KeepPaths, config.Changelog)
+		}
+		releases, err = releasesWithoutUpdateBranch(mainCtx, gitRepo, config)
+		if err != nil {
+			return err
+		}
+	}
+
+	// changelog entries of the charts installed by this run
+	var changelogMu sync.Mutex
+	changelog := make(map[*packager.HelmizedManifests]packager.ChangelogEntry)
+	runDate := time.Now()
+
+	for i, release := range releases {
+		// staggered starts smooth the burst of GitHub API calls, the timeout only starts with the release
+		if i > 0 && !waitLaunchDelay(mainCtx, config.RequestDelay, config.RequestJitter) {
+			break
+		}
 		ctx, cancel := context.WithTimeout(mainCtx, 30*time.Second)
 		defer cancel()
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			modifiedManifests, err := packager.ProcessManifests(ctx, &release, &config.Helm)
+			releaseLog := common.Log.WithFields(logrus.Fields{"release": release.Repo, "chart": release.ChartName})
+			umbrella := config.UmbrellaFor(release.ChartName)
+			baselineSettings := config.Helm
+			if umbrella != nil {
+				baselineSettings.SrcDir = packager.SubchartSrcDir(config.Helm.SrcDir, umbrella.ChartName)
+			}
+			result, err := packager.ProcessManifests(ctx, releaseLog, &release, config.GlobalModifications, &baselineSettings, config.PullRequest.AuthToken)
 			if err != nil {
-				common.Log.Errorf("Error generating Chart for release %s: %v", release.Repo, err)
+				releaseLog.Errorf("Error generating Chart for release %s: %v", release.Repo, err)
+				createdCharts <- nil
+				return
+			}
+			switch result.Status {
+			case packager.StatusUpToDate, packager.StatusSkipped:
 				createdCharts <- nil
 				return
-			} else if modifiedManifests == nil {
+			}
+			if mainCtx.Err() != nil {
+				releaseLog.Warnf("Run cancelled, not generating Chart for release %s", release.Repo)
 				createdCharts <- nil
 				return
 			}
+			modifiedManifests := result.Manifests
+			releaseLog.Infof("Release %s: %s -> %s", release.Repo, result.OldVersion, result.NewVersion)
 
-			charts, err := packager.NewHelmCharts(&config.Helm, release.ChartName, modifiedManifests)
+			var charts *packager.HelmizedManifests
+			if helmSettings.RawOutput() {
+				charts, err = packager.NewRawManifests(releaseLog, &helmSettings, release.ChartName, modifiedManifests)
+			} else if umbrella != nil {
+				charts, err = packager.NewUmbrellaMemberCharts(releaseLog, &helmSettings, umbrella, release.ChartName, release.ResolvedCrdMode(&config.Helm), modifiedManifests)
+			} else {
+				charts, err = packager.NewHelmCharts(releaseLog, &helmSettings, release.ChartName, release.ResolvedCrdMode(&config.Helm), modifiedManifests)
+			}
 			if err != nil {
 				createdCharts <- nil
 				return
 			}
-			common.Log.Infof("Successfully created Helm chart for release: %s", release.Repo)
+			// an interrupt during generation discards the scratch charts instead of installing them
+			if install && mainCtx.Err() != nil {
+				releaseLog.Warnf("Run cancelled, not installing Chart for release %s", release.Repo)
+				createdCharts <- nil
+				return
+			}
+			if skipVersionOnly {
+				charts, err = changedCharts(charts, config.Helm.SrcDir)
+				if err != nil {
+					releaseLog.Errorf("Error comparing Chart for release %s: %v", release.Repo, err)
+					createdCharts <- nil
+					return
+				} else if charts == nil {
+					releaseLog.Infof("Only version fields changed for release %s, skipping", release.Repo)
+					createdCharts <- nil
+					return
+				}
+			}
+			if install && !commits {
+				if err := charts.MoveTo(config.Helm.BuildDir()); err != nil {
+					releaseLog.Errorf("Error installing Chart for release %s: %v", release.Repo, err)
+					createdCharts <- nil
+					return
+				}
+			}
+			if install && config.Changelog != "" {
+				changelogMu.Lock()
+				changelog[charts] = packager.NewChangelogEntry(runDate, &release, result.OldVersion, result.NewVersion)
+				changelogMu.Unlock()
+			}
+			releaseLog.Infof("Successfully created Helm chart for release: %s", release.Repo)
 			createdCharts <- charts
 		}()
 	}
 
 	wg.Wait()
 	close(createdCharts)
+	if mainCtx.Err() != nil {
+		return runCancelled(mainCtx)
+	}
+
+	if config.Diff {
+		return DiffCharts(createdCharts, config.Helm.SrcDir)
+	}
+
+	if config.Output != "" {
+		return OutputCharts(mainCtx, createdCharts, &helmSettings, config.Output)
+	}
 
 	if config.Offline {
 		common.Log.Infof("Offline mode, skipping git operations")
-		return nil
+		return writeChangelog(config.Changelog, createdCharts, changelog)
+	}
+
+	if config.DryRun {
+		return DryRunReport(createdCharts, gitRepo, config)
 	}
 
 	timeoutCtx, cancel := context.WithTimeout(mainCtx, 30*time.Second)
 	defer cancel()
+	// detected once per run, an explicit defaultBranch wins
+	if config.PullRequest.DefaultBranch == "" {
+		defaultBranch, err := ghup.DefaultBranch(timeoutCtx, &config.PullRequest)
+		if err != nil {
+			return err
+		}
+		common.Log.Infof("Detected default branch: %s", defaultBranch)
+		config.PullRequest.DefaultBranch = defaultBranch
+	}
+	// every branch gets the committed changelog plus the entry of its own chart
+	restoreChangelog, err := snapshotFile(config.Changelog)
+	if err != nil {
+		return err
+	}
 	//commit starts once we receive all charts and workdir is not externally modified
 	for charts := range createdCharts {
 		if charts == nil {
 			continue
 		}
+		if mainCtx.Err() != nil {
+			return runCancelled(mainCtx)
+		}
 		// naming by main chart
-		branch := fmt.Sprintf("update/%s-%s", charts.Chart.Metadata.Name, charts.AppVersion())
+		branch, err := git.BranchName(config.PullRequest.BranchTemplate, charts)
+		if err != nil {
+			return err
+		}
 
 		exists, err := gitRepo.BranchExists(branch)
 		if err != nil {
@@ -97,10 +300,25 @@ func UpdateMode(config *common.Config) error {
 			continue
 		}
 		err = gitRepo.CreateBranch(config.PullRequest.DefaultBranch, branch)
+		if errors.Is(err, git.ErrBranchSkipped) {
+			continue
+		}
 		if err != nil {
 			return err
 		}
-		err = gitRepo.Commit(charts)
+		if err := charts.MoveTo(config.Helm.BuildDir()); err != nil {
+			return fmt.Errorf("failed to install charts on branch %s: %w", branch, err)
+		}
+		if entry, ok := changelog[charts]; ok {
+			if err := restoreChangelog(); err != nil {
+				return err
+			}
+			if err := packager.AppendChangelog(config.Changelog, []packager.ChangelogEntry{entry}); err != nil {
+				return err
+			}
+			charts.Files = append(charts.Files, config.Changelog)
+		}
+		err = gitRepo.Commit(charts, config.Helm.BuildDir(), config.PullRequest.CommitStrategy)
 		if err != nil {
 			return err
 		}
@@ -109,7 +327,7 @@ func UpdateMode(config *common.Config) error {
 			return err
 		}
 
-		err = ghup.CreatePr(timeoutCtx, &config.PullRequest, branch)
+		err = ghup.CreatePr(timeoutCtx, &config.PullRequest, branch, charts.LintReport())
 		if err != nil {
 			return err
 		}
@@ -118,27 +336,332 @@ func UpdateMode(config *common.Config) error {
 	return nil
 }
 
-// PublishMode publishes the charts to the chart repository
-// iterates over all charts/* and releases them
-func PublishMode(config *common.Config) error {
-	common.Log.Infof("Publishing Charts")
-	files, err := os.ReadDir(config.Helm.SrcDir)
+// resolveVersionSuffix interpolates ${TIMESTAMP}, ${DATE} and ${GIT_SHA} of this run into suffix
+// and fails early when it cannot give valid chart versions
+func resolveVersionSuffix(suffix string, now time.Time) (string, error) {
+	if suffix == "" {
+		return "", nil
+	}
+	tokens := map[string]string{
+		common.TokenTimestamp: now.UTC().Format("20060102150405"),
+		common.TokenDate:      now.UTC().Format("20060102"),
+	}
+	if strings.Contains(suffix, "${"+common.TokenGitSha+"}") {
+		gitRepo, err := git.NewClient(".")
+		if err != nil {
+			return "", err
+		}
+		tokens[common.TokenGitSha], err = gitRepo.HeadShortHash()
+		if err != nil {
+			return "", err
+		}
+	}
+	resolved := common.Interpolate(suffix, tokens)
+	if _, err := common.WithVersionSuffix(*semver.New(0, 0, 0, "", ""), resolved); err != nil {
+		return "", err
+	}
+	common.Log.Infof("Chart versions get the suffix %s", resolved)
+	return resolved, nil
+}
+
+// writeChangelog records the installed charts in the changelog at path, sorted by chart name
+func writeChangelog(path string, createdCharts <-chan *packager.HelmizedManifests, changelog map[*packager.HelmizedManifests]packager.ChangelogEntry) error {
+	entries := make([]packager.ChangelogEntry, 0, len(changelog))
+	for charts := range createdCharts {
+		if entry, ok := changelog[charts]; ok {
+			entries = append(entries, entry)
+		}
+	}
+	if path == "" || len(entries) == 0 {
+		return nil
+	}
+	slices.SortFunc(entries, func(a, b packager.ChangelogEntry) int { return strings.Compare(a.ChartName, b.ChartName) })
+	return packager.AppendChangelog(path, entries)
+}
+
+// snapshotFile returns a function restoring the file at path to its current content, removing it
+// when it doesn't exist yet, it does nothing for an empty path
+func snapshotFile(path string) (func() error, error) {
+	if path == "" {
+		return func() error { return nil }, nil
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return func() error {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			return nil
+		}, nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to read charts directory: %w", err)
+		return nil, err
+	}
+	return func() error { return os.WriteFile(path, content, 0644) }, nil
+}
+
+// releasesWithoutUpdateBranch drops releases whose update branch for the latest upstream version
+// already exists, branch templates needing the chart version are only checked after generation
+func releasesWithoutUpdateBranch(ctx context.Context, gitRepo *git.Client, config *common.Config) ([]common.GithubRelease, error) {
+	needsVersion, err := git.BranchNeedsVersion(config.PullRequest.BranchTemplate)
+	if err != nil {
+		return nil, err
+	}
+	if needsVersion {
+		return config.Releases, nil
+	}
+	pending := make([]common.GithubRelease, 0, len(config.Releases))
+	for _, release := range config.Releases {
+		timeoutCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		appVersion, err := ghup.LatestReleaseVersion(timeoutCtx, &release, config.PullRequest.AuthToken)
+		cancel()
+		if err != nil {
+			common.Log.Warnf("Failed to look up latest version of release %s, generating it: %v", release.Repo, err)
+			pending = append(pending, release)
+			continue
+		}
+		branch, planned, err := git.PlannedBranchName(config.PullRequest.BranchTemplate, release.ChartName, appVersion)
+		if err != nil {
+			return nil, err
+		}
+		if !planned {
+			pending = append(pending, release)
+			continue
+		}
+		exists, err := gitRepo.BranchExists(branch)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			common.Log.Infof("Branch %s of release %s already exists from an earlier run, skipping", branch, release.Repo)
+			continue
+		}
+		pending = append(pending, release)
+	}
+	return pending, nil
+}
+
+// changedCharts drops generated charts differing from the committed charts in srcDir only by version fields,
+// a main or CRD chart without changes of its own stays as committed and is not installed, nil is returned
+// when no chart changed, umbrella members are kept all or nothing
+func changedCharts(charts *packager.HelmizedManifests, srcDir string) (*packager.HelmizedManifests, error) {
+	if charts.Umbrella != nil {
+		versionOnly, err := versionOnlyChange(charts, srcDir)
+		if err != nil || versionOnly {
+			return nil, err
+		}
+		return charts, nil
+	}
+
+	unchanged := make([]string, 0)
+	for _, name := range charts.ChartNames() {
+		versionOnly, err := packager.IsVersionOnlyChange(filepath.Join(srcDir, name), filepath.Join(charts.Path, name))
+		if err != nil {
+			return nil, err
+		}
+		if versionOnly {
+			common.Log.Infof("Chart %s changed only by version fields, keeping the committed chart", name)
+			unchanged = append(unchanged, name)
+		}
+	}
+	charts.Unchanged = unchanged
+	if len(charts.ChartNames()) == 0 {
+		return nil, nil
+	}
+	return charts, nil
+}
+
+// versionOnlyChange tells whether all generated charts differ from the committed charts in srcDir
+// only by version fields
+func versionOnlyChange(charts *packager.HelmizedManifests, srcDir string) (bool, error) {
+	for _, chartDir := range charts.ChartDirs() {
+		versionOnly, err := packager.IsVersionOnlyChange(filepath.Join(srcDir, chartDir), filepath.Join(charts.Path, chartDir))
+		if err != nil {
+			return false, err
+		}
+		if !versionOnly {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// DryRunReport prints the branch and pull request update mode would create for each generated chart,
+// the repository is only read and GitHub is not called
+func DryRunReport(createdCharts <-chan *packager.HelmizedManifests, gitRepo *git.Client, config *common.Config) error {
+	baseBranch := config.PullRequest.DefaultBranch
+	if baseBranch == "" {
+		baseBranch = "the default branch"
+	}
+	for charts := range createdCharts {
+		if charts == nil {
+			continue
+		}
+		branch, err := git.BranchName(config.PullRequest.BranchTemplate, charts)
+		if err != nil {
+			return err
+		}
+		exists, err := gitRepo.BranchExists(branch)
+		if err != nil {
+			return err
+		}
+		if exists {
+			fmt.Printf("%s: branch %s already exists, would skip\n", charts.Chart.Metadata.Name, branch)
+			continue
+		}
+		if config.PullRequest.SkipVersionOnlyChanges {
+			versionOnly, err := versionOnlyChange(charts, config.Helm.SrcDir)
+			if err != nil {
+				return err
+			}
+			if versionOnly {
+				fmt.Printf("%s: only version fields changed, would skip\n", charts.Chart.Metadata.Name)
+				continue
+			}
+		}
+		fmt.Printf("%s: would create branch %s from %s committing %v\n", charts.Chart.Metadata.Name, branch, baseBranch, charts.ChartDirs())
+		fmt.Printf("%s: would open PR %q:\n%s\n", charts.Chart.Metadata.Name, ghup.PrTitle(&config.PullRequest, branch), ghup.PrBody(&config.PullRequest, charts.LintReport()))
 	}
-	for _, file := range files {
-		if file.IsDir() {
-			chartPath := filepath.Join(config.Helm.SrcDir, file.Name())
-			common.Log.Infof("Found chart directory: %s", chartPath)
-			packagedPath, err := packager.Package(chartPath, &config.Helm)
+	return nil
+}
+
+// DiffCharts prints a unified diff of each regenerated chart against the committed chart in srcDir
+func DiffCharts(createdCharts <-chan *packager.HelmizedManifests, srcDir string) error {
+	for charts := range createdCharts {
+		if charts == nil {
+			continue
+		}
+		for _, chartDir := range charts.ChartDirs() {
+			chartDiff, err := packager.DiffCharts(filepath.Join(srcDir, chartDir), filepath.Join(charts.Path, chartDir))
 			if err != nil {
 				return err
 			}
-			ref, err := packager.Push(packagedPath, config.Helm.Remote)
+			if chartDiff.Empty() {
+				common.Log.Infof("Chart %s: no changes", chartDir)
+				continue
+			}
+			common.Log.Infof("Chart %s: added %v, removed %v, modified %v", chartDir, chartDiff.Added, chartDiff.Removed, chartDiff.Modified)
+			fmt.Print(chartDiff.Unified)
+		}
+	}
+	return nil
+}
+
+// OutputCharts packages the generated charts and writes them as a tar stream
+// to the output file, or to stdout when output is "-"
+func OutputCharts(ctx context.Context, createdCharts <-chan *packager.HelmizedManifests, helmSettings *common.HelmSettings, output string) error {
+	packagedPaths := make([]string, 0)
+	for charts := range createdCharts {
+		if charts == nil {
+			continue
+		}
+		for _, chartDir := range charts.ChartDirs() {
+			packagedPath, err := packager.Package(ctx, filepath.Join(charts.Path, chartDir), helmSettings)
 			if err != nil {
 				return err
 			}
-			common.Log.Infof("Chart %s published to %s", file.Name(), ref)
+			packagedPaths = append(packagedPaths, packagedPath)
+		}
+	}
+
+	out := os.Stdout
+	if output != common.OutputStdout {
+		f, err := os.Create(output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	common.Log.Infof("Writing %d packaged charts to %s", len(packagedPaths), output)
+	return packager.WriteArchive(out, packagedPaths)
+}
+
+// CheckMode prints releases whose committed chart is behind upstream,
+// fails when there is at least one so scheduled jobs surface drift
+func CheckMode(mainCtx context.Context, config *common.Config) error {
+	ctx, cancel := context.WithTimeout(mainCtx, 30*time.Second)
+	defer cancel()
+	statuses, err := packager.ReleaseStatuses(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	pending := 0
+	for _, status := range statuses {
+		if status.UpToDate {
+			common.Log.Infof("Chart %s is up to date with %s", status.ChartName, status.LatestVersion)
+			continue
+		}
+		pending++
+		current := status.CurrentVersion
+		if current == "" {
+			current = "none"
+		}
+		fmt.Printf("%s: %s -> %s\n", status.ChartName, current, status.LatestVersion)
+	}
+	if pending > 0 {
+		return fmt.Errorf("%d of %d releases have pending updates", pending, len(statuses))
+	}
+	return nil
+}
+
+// DeleteMode removes a single chart version from the registry, it is never part of publishing
+// and refuses to run without --confirm
+func DeleteMode(mainCtx context.Context, config *common.Config) error {
+	if config.DeleteRef == "" {
+		return fmt.Errorf("no chart version to delete, use --delete=oci://<remote>/<chart>:<version>")
+	}
+	if !config.Confirm {
+		return fmt.Errorf("deleting %s cannot be undone, re-run with --confirm", config.DeleteRef)
+	}
+	ctx, cancel := context.WithTimeout(mainCtx, 30*time.Second)
+	defer cancel()
+	if err := packager.Delete(ctx, config.DeleteRef, &config.Helm); err != nil {
+		return err
+	}
+	common.Log.Infof("Deleted %s", config.DeleteRef)
+	return nil
+}
+
+// PublishMode packages and pushes the charts in SrcDir, versions already in the registry are skipped
+// unless helm.failOnExists is set
+func PublishMode(mainCtx context.Context, config *common.Config) error {
+	common.Log.Infof("Publishing Charts")
+	chartPaths, err := packager.PublishChartDirs(&config.Helm)
+	if err != nil {
+		return err
+	}
+	records := make([]*packager.PublishRecord, 0)
+	for _, chartPath := range chartPaths {
+		if mainCtx.Err() != nil {
+			return runCancelled(mainCtx)
+		}
+		chartName := filepath.Base(chartPath)
+		common.Log.Infof("Found chart directory: %s", chartPath)
+		ctx, cancel := context.WithTimeout(mainCtx, 30*time.Second)
+		packagedPath, err := packager.Package(ctx, chartPath, &config.Helm)
+		if err != nil {
+			cancel()
+			return err
+		}
+		record, err := packager.Push(ctx, packagedPath, packager.RemoteFor(chartName, &config.Helm), &config.Helm)
+		cancel()
+		if errors.Is(err, packager.ErrVersionExists) && packager.SkipExistingVersion(chartName, &config.Helm) {
+			common.Log.Infof("Chart %s version %s already published to %s, skipping", chartName, record.Version, record.Ref)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		common.Log.Infof("Chart %s published to %s", chartName, record.Ref)
+		records = append(records, record)
+	}
+
+	if config.Helm.WriteProvenance {
+		if _, err := packager.WritePublishManifest(config.Helm.TargetDir, records); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWaitLaunchDelay(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	testCases := map[string]struct {
+		ctx    context.Context
+		delay  time.Duration
+		jitter time.Duration
+		want   bool
+	}{
+		"zero_delay": {
+			ctx:  context.Background(),
+			want: true,
+		},
+		"delay": {
+			ctx:    context.Background(),
+			delay:  time.Millisecond,
+			jitter: time.Millisecond,
+			want:   true,
+		},
+		"cancelled_while_waiting": {
+			ctx:   cancelled,
+			delay: time.Hour,
+		},
+		"cancelled_without_delay": {
+			ctx: cancelled,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//when
+			got := waitLaunchDelay(tc.ctx, tc.delay, tc.jitter)
+
+			//then
+			if got != tc.want {
+				t.Errorf("waitLaunchDelay() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
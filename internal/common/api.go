@@ -2,7 +2,9 @@ package common
 
 import (
 	"regexp"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/Masterminds/semver/v3"
 )
@@ -12,10 +14,73 @@ const (
 	Kind                        = "kind"
 	ModeUpdate  ModeOfOperation = "update"
 	ModePublish ModeOfOperation = "publish"
+	ModeCheck   ModeOfOperation = "check"
+	ModeDoctor  ModeOfOperation = "doctor"
+	ModeDelete  ModeOfOperation = "delete"
+
+	DefaultCrdChartName  = "{{.ChartName}}-crds"
+	ChartPathPlaceholder = "{chartPath}"
+	OutputStdout         = "-"
+
+	DanglingValuesIgnore = "ignore"
+	DanglingValuesWarn   = "warn"
+	DanglingValuesFail   = "fail"
+
+	MissingKindFail = "fail"
+	MissingKindSkip = "skip"
+	MissingKindWarn = "warn"
+
+	CrdModeSeparate = "separate"
+	CrdModeInline   = "inline"
+	CrdModeCrdsDir  = "crds-dir"
+	CrdModeDrop     = "drop"
+
+	CrdVersioningRelease = "release"
+	CrdVersioningContent = "content"
+
+	PreserveCrdsNone      = "none"
+	PreserveCrdsAll       = "all"
+	PreserveCrdsAnnotated = "annotated"
+
+	PrereleaseVersionsTrack  = "track"
+	PrereleaseVersionsStable = "stable"
+
+	TemplateLayoutPerKind     = "per-kind"
+	TemplateLayoutPerResource = "per-resource"
+	TemplateLayoutSingle      = "single"
+
+	ChartTypeApplication = "application"
+	ChartTypeLibrary     = "library"
+
+	OutputFormatHelm      = "helm"
+	OutputFormatManifests = "manifests"
+	OutputFormatKustomize = "kustomize"
+
+	CommitStrategySingle = "single"
+	CommitStrategySplit  = "split"
+
+	UnstagedChangesFail  = "fail"
+	UnstagedChangesReset = "reset"
+	UnstagedChangesSkip  = "skip"
+
+	ExtraTagLatest = "latest"
+	ExtraTagMajor  = "major"
+	ExtraTagMinor  = "minor"
+
+	OciAnnotationSource   = "org.opencontainers.image.source"
+	OciAnnotationRevision = "org.opencontainers.image.revision"
 )
 
 var (
 	ValuesRegexCompiled = regexp.MustCompile(ValuesRegex)
+
+	// DefaultStripAnnotations are volatile annotations normalizeMetadata strips when stripAnnotations is empty,
+	// they are left by kubectl and controllers or pin content that changes with every release
+	DefaultStripAnnotations = []string{
+		`kubectl\.kubernetes\.io/last-applied-configuration`,
+		`deployment\.kubernetes\.io/revision`,
+		`checksum/.*`,
+	}
 )
 
 type ModeOfOperation string
@@ -27,55 +92,261 @@ type Config struct {
 
 	ModeOfOperation ModeOfOperation `koanf:"mode"`
 	Offline         bool            `koanf:"offline"`
+	Output          string          `koanf:"output"`        // if set, packaged charts are written here instead of committed, "-" for stdout
+	Diff            bool            `koanf:"diff"`          // print diff of regenerated charts against SrcDir instead of committing
+	DryRun          bool            `koanf:"dryRun"`        // generate charts and report the branches and PRs update mode would create, git and GitHub stay untouched
+	Proxy           Proxy           `koanf:"proxy"`         // optional, HTTP_PROXY/HTTPS_PROXY/NO_PROXY are honored otherwise
+	UserAgent       string          `koanf:"userAgent"`     // sent with all outbound requests, defaults to krezh-charts/<version>
+	Deadline        time.Duration   `koanf:"deadline"`      // bounds the whole run, e.g. 15m, unbounded when zero
+	RequestDelay    time.Duration   `koanf:"requestDelay"`  // spaces the start of each release's processing, e.g. 500ms, all start at once when zero
+	RequestJitter   time.Duration   `koanf:"requestJitter"` // random extra delay of up to this much per release start
+	Changelog       string          `koanf:"changelog"`     // markdown file recording every chart update, e.g. CHANGELOG.md, none when empty
+	DeleteRef       string          `koanf:"-"`             // chart version removed by delete mode, set by --delete only
+	Confirm         bool            `koanf:"-"`             // delete mode refuses to run without it, set by --confirm only
 
 	PullRequest PullRequest `koanf:"pr"`
 
 	Helm HelmSettings `koanf:"helm"`
 
+	GlobalModifications []Modification `koanf:"globalModifications"` // applied to every release before its own modifications
+
 	Releases []GithubRelease `koanf:"githubReleases"`
+
+	Umbrellas []Umbrella `koanf:"umbrellas"` // parent charts bundling generated release charts as subcharts
+}
+
+// UmbrellaFor returns the umbrella chart the release chart belongs to, nil for standalone charts
+func (c Config) UmbrellaFor(chartName string) *Umbrella {
+	for i := range c.Umbrellas {
+		if slices.Contains(c.Umbrellas[i].Releases, chartName) {
+			return &c.Umbrellas[i]
+		}
+	}
+	return nil
 }
 
 type PullRequest struct {
-	DefaultBranch string `koanf:"defaultBranch"`
-	Title         string `koanf:"title"`
-	Body          string `koanf:"body"`
-	Repo          string `koanf:"repo"`
-	Owner         string `koanf:"owner"`
-	AuthToken     string `koanf:"authToken"`
+	DefaultBranch          string `koanf:"defaultBranch"`
+	Title                  string `koanf:"title"`
+	Body                   string `koanf:"body"`
+	Repo                   string `koanf:"repo"`
+	Owner                  string `koanf:"owner"`
+	AuthToken              string `koanf:"authToken"`              // precedence: authToken, GITHUB_TOKEN env, authTokenFile
+	AuthTokenFile          string `koanf:"authTokenFile"`          // file holding the token, whitespace is trimmed
+	SkipVersionOnlyChanges bool   `koanf:"skipVersionOnlyChanges"` // charts changed only in Chart.yaml versions are not committed, no branch or PR when none changed
+	CommitStrategy         string `koanf:"commitStrategy"`         // single (default) or split: CRDs, templates, values/metadata
+	BranchTemplate         string `koanf:"branchTemplate"`         // update branch name with .ChartName, .Version, .AppVersion
+	OnUnstagedChanges      string `koanf:"onUnstagedChanges"`      // fail (default), reset changes outside the charts and retry, or skip the chart when checkout fails
 }
 
 type HelmSettings struct {
-	SrcDir    string `koanf:"srcDir"`
-	TargetDir string `koanf:"targetDir"`
-	LintK8s   string `koanf:"lintK8s"`
-	Remote    string `koanf:"remote"`
+	SrcDir          string `koanf:"srcDir"`
+	TargetDir       string `koanf:"targetDir"`
+	OutputDir       string `koanf:"outputDir"` // where generated charts are installed, defaults to SrcDir
+	LintK8s         string `koanf:"lintK8s"`
+	LintNamespace   string `koanf:"lintNamespace"`   // namespace charts are linted in, defaults to "lint-namespace"
+	LintReleaseName string `koanf:"lintReleaseName"` // release name templates are rendered with, defaults to "test-release"
+	Remote          string `koanf:"remote"`
+	// CrdRemote is an optional separate OCI remote for CRD charts, defaults to Remote
+	CrdRemote     string       `koanf:"crdRemote"`
+	ChartRemotes  ChartRemotes `koanf:"chartRemotes"`  // OCI remotes of single charts, overriding Remote and CrdRemote
+	CrdChartName  string       `koanf:"crdChartName"`  // CRD chart naming template, defaults to DefaultCrdChartName
+	CrdVersioning string       `koanf:"crdVersioning"` // release (default): CRD charts share the main chart version, content: own version bumped only on CRD changes
+	VersionSuffix string       `koanf:"versionSuffix"` // appended to generated chart versions, -prerelease and/or +build, may use ${TIMESTAMP}, ${DATE} and ${GIT_SHA}
+	// RemoteAuth holds optional credentials for Remote, anonymous access when empty
+	RemoteAuth RemoteAuth `koanf:"remoteAuth"`
+	// AllowOverwrite re-pushes versions already present in Remote, never enable for production publishes
+	AllowOverwrite          bool     `koanf:"allowOverwrite"`
+	FailOnExists            bool     `koanf:"failOnExists"`            // fail publishing on an existing version instead of skipping the chart
+	VerifyAfterPush         bool     `koanf:"verifyAfterPush"`         // pull pushed charts back and fail publishing unless digest and version match
+	IgnoreExistingCrdCharts bool     `koanf:"ignoreExistingCrdCharts"` // CRD charts with an existing version are skipped even with failOnExists
+	PublishCharts           []string `koanf:"publishCharts"`           // chart directories or globs below SrcDir to publish in order, all subdirectories when empty
+	// ExtraTags are floating tags pushed alongside the version: latest, major (1), minor (1.2)
+	ExtraTags      []string       `koanf:"extraTags"`
+	OciAnnotations OciAnnotations `koanf:"ociAnnotations"` // annotations of pushed charts, GHCR links packages to the source repository
+	// WriteProvenance writes a JSON record per pushed chart and a per-run manifest into TargetDir
+	WriteProvenance bool `koanf:"writeProvenance"`
+	WriteImageList  bool `koanf:"writeImageList"` // images.txt listing referenced container images in each generated chart
+	// PostGenerateHooks are shell commands run after a chart is generated,
+	// ChartPathPlaceholder is replaced with the generated chart's path, quoted by the hook runner
+	PostGenerateHooks []string     `koanf:"postGenerateHooks"`
+	DanglingValues    string       `koanf:"danglingValues"`   // template references to undefined values: ignore, warn (default), fail
+	MissingKind       string       `koanf:"missingKind"`      // manifests without kind: fail (default), skip drops them with a warning, warn also logs the manifest
+	ValuesOnly        bool         `koanf:"valuesOnly"`       // regenerate values of existing charts only, templates stay untouched
+	CacheDir          string       `koanf:"cacheDir"`         // fetched release assets are cached here for regenerate, no cache when empty
+	Regenerate        bool         `koanf:"regenerate"`       // rebuild charts from cached assets and the current modifications, GitHub is never called
+	FailOnEmptyChart  bool         `koanf:"failOnEmptyChart"` // error instead of generating a chart without templates, recommended for CI
+	ValidateCrds      bool         `koanf:"validateCrds"`     // check CRDs against the Kubernetes structural schema rules before charts are generated
+	CrdMode           string       `koanf:"crdMode"`          // default crdMode of releases, crds-dir uses Helm's native crds/ directory
+	TemplateLayout    string       `koanf:"templateLayout"`   // template files per-kind (default), per-resource or a single file
+	TemplateDirs      TemplateDirs `koanf:"templateDirs"`     // subdirectories of templates/ by kind, flat when unmapped
+	OutputFormat      string       `koanf:"outputFormat"`     // helm charts (default), plain multi-doc manifests or a kustomize base
+}
+
+// ChartRemotes maps chart names to the OCI remote they are published to instead of the default remote,
+// e.g. for charts that must go to a mirror
+type ChartRemotes map[string]string
+
+// TemplateDirs maps kinds, case-insensitive, to the subdirectory of templates/ their template files go to,
+// e.g. ClusterRole: rbac, Helm renders templates recursively so the layout is purely organizational
+type TemplateDirs map[string]string
+
+type OciAnnotations struct {
+	Source   string `koanf:"source"`   // org.opencontainers.image.source, defaults to the pr repository URL
+	Revision string `koanf:"revision"` // org.opencontainers.image.revision, defaults to the chart version
+	Disabled bool   `koanf:"disabled"` // push charts with Helm's default annotations only
+}
+
+type Proxy struct {
+	URL     string `koanf:"url"`     // proxy for all HTTP and HTTPS requests
+	NoProxy string `koanf:"noProxy"` // comma separated hosts bypassing the proxy, defaults to NO_PROXY
+}
+
+// RawOutput tells whether releases are emitted as plain manifests instead of Helm charts
+func (h HelmSettings) RawOutput() bool {
+	return h.OutputFormat == OutputFormatManifests || h.OutputFormat == OutputFormatKustomize
+}
+
+// BuildDir returns the directory charts are generated into
+func (h HelmSettings) BuildDir() string {
+	if h.OutputDir != "" {
+		return h.OutputDir
+	}
+	return h.SrcDir
+}
+
+type RemoteAuth struct {
+	Username        string `koanf:"username"`
+	Password        string `koanf:"password"`        // password or token
+	CredentialsFile string `koanf:"credentialsFile"` // docker config.json style credentials, e.g. ~/.docker/config.json
 }
 
 type GithubRelease struct {
-	Owner         string         `koanf:"owner"`
-	Repo          string         `koanf:"repo"`
-	Assets        []string       `koanf:"assets"`
-	ChartName     string         `koanf:"chartName"`
-	Drop          []string       `koanf:"drop"`
-	Modifications []Modification `koanf:"modifications"`
-	AddValues     map[string]any `koanf:"addValues"`
-	AddCrdValues  map[string]any `koanf:"addCrdValues"`
+	Owner                string                 `koanf:"owner"`
+	Repo                 string                 `koanf:"repo"`
+	Assets               []string               `koanf:"assets"`
+	AssetContentTypes    []string               `koanf:"assetContentTypes"`  // download all assets of these content types too, e.g. application/x-yaml
+	AssetURLs            []string               `koanf:"assetURLs"`          // direct download URLs supplementing Assets, named by their last path segment
+	SourcePaths          []string               `koanf:"sourcePaths"`        // globs of manifests in the release's source tarball, e.g. deploy/*.yaml
+	AssetConcurrency     int                    `koanf:"assetConcurrency"`   // parallel asset downloads within the release, defaults to 4
+	MinAssetSize         int                    `koanf:"minAssetSize"`       // assets smaller than this many bytes fail the release, defaults to 16
+	SkipAssetSizeCheck   bool                   `koanf:"skipAssetSizeCheck"` // accept legitimately tiny assets
+	ReleaseID            int64                  `koanf:"releaseId"`          // pin a specific release instead of the latest one
+	ChartName            string                 `koanf:"chartName"`
+	InitialVersion       string                 `koanf:"initialVersion"` // chart version baseline when the chart does not exist yet
+	Preprocess           []AssetReplacement     `koanf:"preprocess"`     // regex replacements applied in order to the raw assets before YAML parsing
+	Drop                 []string               `koanf:"drop"`
+	DropNames            []string               `koanf:"dropNames"`      // regexes on metadata.name of resources to exclude
+	DropNamespaces       bool                   `koanf:"dropNamespaces"` // drop Namespace resources, install with --create-namespace instead
+	Modifications        []Modification         `koanf:"modifications"`
+	AddValues            map[string]any         `koanf:"addValues"`            // strings may reference ${ENV}, ${CHART_VERSION} and ${APP_VERSION}
+	AddCrdValues         map[string]any         `koanf:"addCrdValues"`         // interpolated like addValues
+	OverrideValues       map[string]any         `koanf:"overrideValues"`       // merged last, wins over extracted values, whereas addValues are defaults extracted values win over
+	CustomResourceValues []CustomResourceValues `koanf:"customResourceValues"` // CRs whose whole spec becomes values, validated by their CRD schema
+	HelmHooks            []HelmHook             `koanf:"helmHooks"`            // resources turned into Helm hooks by kind and name
+	Renames              []ResourceRename       `koanf:"renames"`              // resources renamed by kind and name regex, references to them follow on a best-effort basis
+	TemplateNamespace    bool                   `koanf:"templateNamespace"`    // template .metadata.namespace of namespaced resources as the release namespace
+	ParametrizeImages    bool                   `koanf:"parametrizeImages"`    // template workload images as image.registry/repository/tag/digest and image.pullPolicy values
+	ParametrizeResources bool                   `koanf:"parametrizeResources"` // template container resources as resources.<container> values
+	ExtraTemplates       []string               `koanf:"extraTemplates"`       // local template files copied verbatim into the main chart, e.g. a NetworkPolicy
+	IncludePrereleases   bool                   `koanf:"includePrereleases"`   // track the newest release by SemVer including prereleases
+	IncludeDrafts        bool                   `koanf:"includeDrafts"`        // consider draft releases too, requires the pr token with push access
+	PrereleaseVersions   string                 `koanf:"prereleaseVersions"`   // track (default) follows prerelease progressions by SemVer precedence, stable never updates to a prerelease
+	CrdMode              string                 `koanf:"crdMode"`              // separate CRD chart, inline templates, crds-dir of the main chart, drop, defaults to helm.crdMode
+	ChartType            string                 `koanf:"chartType"`            // application (default) or library, library templates become named templates
+	NormalizeMetadata    bool                   `koanf:"normalizeMetadata"`    // trim and stringify labels and annotations, strip volatile annotations
+	StripAnnotations     []string               `koanf:"stripAnnotations"`     // regexes on annotation keys stripped by normalizeMetadata, defaults to DefaultStripAnnotations
+	PreserveCrds         string                 `koanf:"preserveCrds"`         // committed CRDs missing upstream: none (default) drops them, all keeps them, annotated keeps those marked for preservation
+}
+
+// ResolvedCrdMode returns the release's crdMode, falling back to the default of the helm settings
+func (r GithubRelease) ResolvedCrdMode(settings *HelmSettings) string {
+	if r.CrdMode != "" {
+		return r.CrdMode
+	}
+	if settings.CrdMode != "" {
+		return settings.CrdMode
+	}
+	return CrdModeSeparate
+}
+
+// DroppedKinds returns the kinds of resources the release excludes, drop plus Namespace with dropNamespaces
+func (r GithubRelease) DroppedKinds() []string {
+	if r.DropNamespaces {
+		return append(slices.Clone(r.Drop), "Namespace")
+	}
+	return r.Drop
+}
+
+// StrippedAnnotations returns the regexes of annotation keys normalizeMetadata strips
+func (r GithubRelease) StrippedAnnotations() []string {
+	if len(r.StripAnnotations) > 0 {
+		return r.StripAnnotations
+	}
+	return DefaultStripAnnotations
+}
+
+// CustomResourceValues moves the spec of a custom resource into values, replacing per-field
+// modifications of that spec, values.schema.json is generated from the CRD in the release
+type CustomResourceValues struct {
+	Kind      string `koanf:"kind"`
+	ValuesKey string `koanf:"valuesKey"` // defaults to the kind in lowerCamelCase
+}
+
+// AssetReplacement replaces every match of Pattern in a raw asset, Replacement may reference groups as ${1},
+// flags like (?m) go into the pattern
+type AssetReplacement struct {
+	Pattern     string `koanf:"pattern"`
+	Replacement string `koanf:"replacement"`
+}
+
+// HelmHook annotates the resources of a kind, optionally only the one named Name, as Helm hook
+type HelmHook struct {
+	Kind         string `koanf:"kind"`
+	Name         string `koanf:"name"`         // metadata.name of the resource, all resources of Kind when empty
+	Hook         string `koanf:"hook"`         // helm.sh/hook, e.g. pre-install,pre-upgrade
+	Weight       int    `koanf:"weight"`       // helm.sh/hook-weight
+	DeletePolicy string `koanf:"deletePolicy"` // optional helm.sh/hook-delete-policy, e.g. before-hook-creation
+}
+
+// ResourceRename renames the resources of Kind whose metadata.name fully matches the regex From to To,
+// RBAC subjects and roleRefs, serviceAccountNames of workloads and ownerReferences follow the renamed resource
+type ResourceRename struct {
+	Kind string `koanf:"kind"`
+	From string `koanf:"from"` // regex on metadata.name, e.g. "kubevirt-(.*)"
+	To   string `koanf:"to"`   // new name, may reference groups of From as ${1}
+}
+
+type Umbrella struct {
+	ChartName      string            `koanf:"chartName"`
+	Description    string            `koanf:"description"`
+	InitialVersion string            `koanf:"initialVersion"` // umbrella chart version when it does not exist yet
+	Releases       []string          `koanf:"releases"`       // chartName of each githubRelease bundled as subchart
+	Aliases        map[string]string `koanf:"aliases"`        // dependency alias per subchart name, its enabled flag and overrides live under the alias in the umbrella values.yaml
 }
 
 type Modification struct {
 	Expression     string   `koanf:"expression"`     // yq expression to modify manifest
+	ExpressionFile string   `koanf:"expressionFile"` // file holding a multi-line yq program, read at config load instead of expression
 	ValuesSelector []string `koanf:"valuesSelector"` // cuts selected section and moves to Values
 	Kind           string   `koanf:"kind"`           // if set, apply modification only to resources of this kind
 	Reject         string   `koanf:"reject"`         // don't apply for these
+	When           string   `koanf:"when"`           // yq condition on the manifest, e.g. '.spec.replicas > 1', empty always applies
+	Priority       int      `koanf:"priority"`       // modifications run in ascending priority, ties keep their configured order
+	WithDefault    bool     `koanf:"withDefault"`    // template falls back to the extracted value when no value is supplied
 }
 
 type Manifests struct {
-	Crds       []map[string]any
-	Manifests  []map[string]any
-	Version    semver.Version
-	AppVersion string
-	Values     map[string]any
-	CrdsValues map[string]any
+	Crds           []map[string]any
+	Manifests      []map[string]any
+	Version        semver.Version
+	AppVersion     string
+	Values         map[string]any
+	CrdsValues     map[string]any
+	ValuesSchema   map[string]any // values.schema.json of the main chart, optional
+	ChartType      string         // type of the main chart, application when empty
+	ExtraTemplates []string       // local files copied verbatim into templates/ of the main chart
+	PreserveCrds   string         // policy for committed CRDs missing upstream, none when empty
 }
 
 func (m Manifests) ContainsCrds() bool {
@@ -102,13 +373,15 @@ func NewManifests(assetsData *map[string][]byte, version *semver.Version, appVer
 	}
 
 	Log.Debugf("Manifests extracted: %d, CRDs: %d", len(manifests), len(crds))
+	// injected values may reference the environment and the versions of the chart being generated
+	tokens := map[string]string{TokenChartVersion: version.String(), TokenAppVersion: appVersion}
 	return &Manifests{
 		Crds:       crds,
 		Manifests:  manifests,
 		Version:    *version,
 		AppVersion: appVersion,
-		Values:     *initialValues,
-		CrdsValues: *initialCrdValues,
+		Values:     InterpolateValues(*initialValues, tokens),
+		CrdsValues: InterpolateValues(*initialCrdValues, tokens),
 	}, nil
 }
 
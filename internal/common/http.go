@@ -0,0 +1,78 @@
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// HTTPClient is shared by the GitHub, registry and git clients so they agree on proxy settings and user-agent
+var HTTPClient = &http.Client{Transport: newTransport(http.ProxyFromEnvironment)}
+
+var userAgent = DefaultUserAgent()
+
+// DefaultUserAgent identifies this tool and its version
+func DefaultUserAgent() string {
+	return "krezh-charts/" + ReadBuildInfo().Version
+}
+
+// UserAgent returns the user-agent sent with every outbound request
+func UserAgent() string {
+	return userAgent
+}
+
+// SetUserAgent overrides the user-agent of all outbound requests, empty keeps the default
+func SetUserAgent(ua string) {
+	if ua != "" {
+		userAgent = ua
+	}
+}
+
+// SetupProxy configures HTTPClient, the proxy URL and no-proxy list override
+// HTTP_PROXY/HTTPS_PROXY and NO_PROXY, which are honored when unset
+func SetupProxy(proxy *Proxy) error {
+	if proxy.URL == "" {
+		if proxy.NoProxy != "" {
+			Log.Warnf("proxy.noProxy is ignored without proxy.url, NO_PROXY applies")
+		}
+		return nil
+	}
+	if _, err := url.Parse(proxy.URL); err != nil {
+		return fmt.Errorf("invalid proxy url %s: %w", proxy.URL, err)
+	}
+
+	noProxy := proxy.NoProxy
+	if noProxy == "" {
+		noProxy = httpproxy.FromEnvironment().NoProxy
+	}
+	proxyFunc := (&httpproxy.Config{
+		HTTPProxy:  proxy.URL,
+		HTTPSProxy: proxy.URL,
+		NoProxy:    noProxy,
+	}).ProxyFunc()
+
+	Log.Infof("Using proxy %s for outbound requests", proxy.URL)
+	HTTPClient = &http.Client{Transport: newTransport(func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	})}
+	return nil
+}
+
+func newTransport(proxy func(*http.Request) (*url.URL, error)) http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	return &userAgentTransport{base: transport}
+}
+
+// userAgentTransport replaces the user-agent set by client libraries, e.g. go-git and ORAS
+type userAgentTransport struct {
+	base http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", userAgent)
+	return t.base.RoundTrip(req)
+}
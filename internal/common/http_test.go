@@ -0,0 +1,45 @@
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUserAgent(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("User-Agent")
+	}))
+	defer server.Close()
+	t.Cleanup(func() { userAgent = DefaultUserAgent() })
+
+	testCases := []struct {
+		name       string
+		configured string
+		want       string
+	}{
+		{name: "default", configured: "", want: "krezh-charts/" + ReadBuildInfo().Version},
+		{name: "configured", configured: "platform-bot/1.0", want: "platform-bot/1.0"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//given
+			SetUserAgent(tc.configured)
+			req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+			req.Header.Set("User-Agent", "go-git/5.x")
+
+			//when
+			resp, err := HTTPClient.Do(req)
+
+			//then
+			if err != nil {
+				t.Fatalf("HTTPClient.Do() error = %v", err)
+			}
+			resp.Body.Close()
+			if got != tc.want {
+				t.Errorf("User-Agent = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package common
+
+import (
+	"os"
+	"regexp"
+)
+
+const (
+	TokenChartVersion = "CHART_VERSION"
+	TokenAppVersion   = "APP_VERSION"
+	TokenTimestamp    = "TIMESTAMP" // UTC start of the run, e.g. 20240601153000
+	TokenDate         = "DATE"      // UTC date of the run, e.g. 20240601
+	TokenGitSha       = "GIT_SHA"   // short hash of the checked out commit
+)
+
+var interpolationRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Interpolate replaces ${NAME} in s by tokens[NAME], falling back to the environment variable NAME,
+// references resolving to neither are kept as they are
+func Interpolate(s string, tokens map[string]string) string {
+	return interpolationRef.ReplaceAllStringFunc(s, func(ref string) string {
+		name := interpolationRef.FindStringSubmatch(ref)[1]
+		if value, ok := tokens[name]; ok {
+			return value
+		}
+		if value, ok := os.LookupEnv(name); ok {
+			return value
+		}
+		return ref
+	})
+}
+
+// InterpolateValues returns a copy of values with Interpolate applied to every string, nested maps and lists
+// included, other values pass through unchanged
+func InterpolateValues(values map[string]any, tokens map[string]string) map[string]any {
+	if values == nil {
+		return nil
+	}
+	interpolated := make(map[string]any, len(values))
+	for key, value := range values {
+		interpolated[key] = interpolateValue(value, tokens)
+	}
+	return interpolated
+}
+
+func interpolateValue(value any, tokens map[string]string) any {
+	switch v := value.(type) {
+	case string:
+		return Interpolate(v, tokens)
+	case map[string]any:
+		return InterpolateValues(v, tokens)
+	case []any:
+		items := make([]any, len(v))
+		for i, item := range v {
+			items[i] = interpolateValue(item, tokens)
+		}
+		return items
+	default:
+		return value
+	}
+}
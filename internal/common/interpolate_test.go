@@ -0,0 +1,37 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInterpolateValues(t *testing.T) {
+	//given
+	t.Setenv("REGISTRY_HOST", "registry.example.com")
+	values := map[string]any{
+		"image":    map[string]any{"registry": "${REGISTRY_HOST}", "tag": "v${APP_VERSION}"},
+		"labels":   []any{"chart-${CHART_VERSION}", 3},
+		"replicas": 2,
+		"enabled":  true,
+		"template": "${UNSET_VARIABLE_FOR_TEST} and $HOME",
+	}
+	tokens := map[string]string{TokenChartVersion: "1.2.3", TokenAppVersion: "0.9.0"}
+
+	//when
+	interpolated := InterpolateValues(values, tokens)
+
+	//then
+	want := map[string]any{
+		"image":    map[string]any{"registry": "registry.example.com", "tag": "v0.9.0"},
+		"labels":   []any{"chart-1.2.3", 3},
+		"replicas": 2,
+		"enabled":  true,
+		"template": "${UNSET_VARIABLE_FOR_TEST} and $HOME",
+	}
+	if !reflect.DeepEqual(interpolated, want) {
+		t.Errorf("InterpolateValues() = %v, want %v", interpolated, want)
+	}
+	if values["image"].(map[string]any)["registry"] != "${REGISTRY_HOST}" {
+		t.Errorf("InterpolateValues() modified its input")
+	}
+}
@@ -0,0 +1,103 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const Redacted = "***"
+
+var (
+	secretsMu sync.RWMutex
+	secrets   []string
+)
+
+// RegisterSecret makes Redact, and thereby every log entry, mask the secret
+func RegisterSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	secrets = append(secrets, secret)
+}
+
+// Redact masks registered secrets in s
+func Redact(s string) string {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, Redacted)
+	}
+	return s
+}
+
+// RedactError masks registered secrets in the error message, the original error stays reachable via errors.Is/As
+func RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := Redact(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	return &redactedError{msg: msg, err: err}
+}
+
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+// redactHook masks registered secrets in log messages and string or error fields
+type redactHook struct{}
+
+func (redactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (redactHook) Fire(entry *logrus.Entry) error {
+	entry.Message = Redact(entry.Message)
+	for k, v := range entry.Data {
+		switch value := v.(type) {
+		case string:
+			entry.Data[k] = Redact(value)
+		case error:
+			entry.Data[k] = RedactError(value)
+		}
+	}
+	return nil
+}
+
+func (p PullRequest) String() string {
+	p.AuthToken = mask(p.AuthToken)
+	type plain PullRequest // drops String to avoid recursion
+	return fmt.Sprintf("%+v", plain(p))
+}
+
+func (p PullRequest) GoString() string {
+	return p.String()
+}
+
+func (a RemoteAuth) String() string {
+	a.Password = mask(a.Password)
+	type plain RemoteAuth
+	return fmt.Sprintf("%+v", plain(a))
+}
+
+func (a RemoteAuth) GoString() string {
+	return a.String()
+}
+
+func mask(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return Redacted
+}
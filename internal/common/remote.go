@@ -0,0 +1,119 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	gogit "github.com/go-git/go-git/v5"
+	gogitplumbing "github.com/go-git/go-git/v5/plumbing"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+const (
+	gitConfigPrefix     = "git::"
+	remoteConfigTimeout = 30 * time.Second
+)
+
+// remoteConfig is a koanf provider reading the config from an https:// URL or a git:: reference
+// of the form git::<repository>//<path>[?ref=<branch or tag>], token authenticates HTTPS git clones when set
+type remoteConfig struct {
+	source string
+	token  string
+}
+
+func (r remoteConfig) ReadBytes() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteConfigTimeout)
+	defer cancel()
+	switch {
+	case strings.HasPrefix(r.source, gitConfigPrefix):
+		repository, path, ref, err := parseGitConfigSource(strings.TrimPrefix(r.source, gitConfigPrefix))
+		if err != nil {
+			return nil, err
+		}
+		return readGitConfig(ctx, repository, path, ref, r.token)
+	case strings.HasPrefix(r.source, "https://"):
+		return readHTTPConfig(ctx, r.source)
+	default:
+		return nil, fmt.Errorf("config url must start with https:// or %s, got %s", gitConfigPrefix, r.source)
+	}
+}
+
+func (r remoteConfig) Read() (map[string]any, error) {
+	return nil, errors.New("remote config provider does not support Read()")
+}
+
+func readHTTPConfig(ctx context.Context, source string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch config from %s: %w", source, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch config from %s: status %d", source, resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// parseGitConfigSource splits <repository>//<path>[?ref=<ref>] into its parts
+func parseGitConfigSource(source string) (string, string, string, error) {
+	ref := ""
+	if base, query, found := strings.Cut(source, "?"); found {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			return "", "", "", fmt.Errorf("invalid git config reference %s: %w", source, err)
+		}
+		source, ref = base, values.Get("ref")
+	}
+	start := 0
+	if i := strings.Index(source, "://"); i >= 0 {
+		start = i + len("://")
+	}
+	i := strings.Index(source[start:], "//")
+	if i < 0 || start+i+2 == len(source) {
+		return "", "", "", fmt.Errorf("git config reference %s needs a path, e.g. git::https://github.com/org/repo.git//config.yaml", source)
+	}
+	return source[:start+i], source[start+i+2:], ref, nil
+}
+
+// readGitConfig shallow clones the repository into memory and reads path at ref, the default branch
+// when ref is empty, the token authenticates HTTPS clones when set
+func readGitConfig(ctx context.Context, repository, path, ref, token string) ([]byte, error) {
+	options := &gogit.CloneOptions{URL: repository, Depth: 1, SingleBranch: true}
+	if token != "" && strings.HasPrefix(repository, "https://") {
+		options.Auth = &githttp.BasicAuth{Username: "x-access-token", Password: token}
+	}
+	refNames := []gogitplumbing.ReferenceName{""}
+	if ref != "" {
+		refNames = []gogitplumbing.ReferenceName{gogitplumbing.NewBranchReferenceName(ref), gogitplumbing.NewTagReferenceName(ref)}
+	}
+
+	var err error
+	for _, refName := range refNames {
+		fs := memfs.New()
+		options.ReferenceName = refName
+		_, err = gogit.CloneContext(ctx, memory.NewStorage(), fs, options)
+		if err != nil {
+			continue
+		}
+		f, err := fs.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("config %s not found in %s: %w", path, repository, err)
+		}
+		defer f.Close()
+		return io.ReadAll(f)
+	}
+	// go-git errors may carry the repository URL including credentials
+	return nil, fmt.Errorf("failed to clone config repository %s: %w", repository, RedactError(err))
+}
@@ -0,0 +1,92 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseGitConfigSource(t *testing.T) {
+	testCases := map[string]struct {
+		source         string
+		wantRepository string
+		wantPath       string
+		wantRef        string
+		wantErr        bool
+	}{
+		"https": {
+			source:         "https://github.com/krezh/charts-config.git//updater/config.yaml",
+			wantRepository: "https://github.com/krezh/charts-config.git",
+			wantPath:       "updater/config.yaml",
+		},
+		"with_ref": {
+			source:         "https://github.com/krezh/charts-config.git//config.yaml?ref=v1.2.0",
+			wantRepository: "https://github.com/krezh/charts-config.git",
+			wantPath:       "config.yaml",
+			wantRef:        "v1.2.0",
+		},
+		"ssh": {
+			source:         "git@github.com:krezh/charts-config.git//config.yaml?ref=main",
+			wantRepository: "git@github.com:krezh/charts-config.git",
+			wantPath:       "config.yaml",
+			wantRef:        "main",
+		},
+		"no_path": {
+			source:  "https://github.com/krezh/charts-config.git",
+			wantErr: true,
+		},
+		"empty_path": {
+			source:  "https://github.com/krezh/charts-config.git//",
+			wantErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//when
+			repository, path, ref, err := parseGitConfigSource(tc.source)
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseGitConfigSource() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if repository != tc.wantRepository || path != tc.wantPath || ref != tc.wantRef {
+				t.Errorf("parseGitConfigSource() = %s, %s, %s, want %s, %s, %s", repository, path, ref, tc.wantRepository, tc.wantPath, tc.wantRef)
+			}
+		})
+	}
+}
+
+func TestReadHTTPConfig(t *testing.T) {
+	//given
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/config.yaml" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("mode: update\n"))
+	}))
+	defer server.Close()
+
+	//when
+	data, err := readHTTPConfig(context.Background(), server.URL+"/config.yaml")
+	_, missingErr := readHTTPConfig(context.Background(), server.URL+"/missing.yaml")
+
+	//then
+	if err != nil || string(data) != "mode: update\n" {
+		t.Errorf("readHTTPConfig() = %q, %v", data, err)
+	}
+	if missingErr == nil {
+		t.Errorf("readHTTPConfig() expected error for missing config")
+	}
+}
+
+func TestRemoteConfigRejectsPlainHTTP(t *testing.T) {
+	//when
+	_, err := remoteConfig{source: "http://example.com/config.yaml"}.ReadBytes()
+
+	//then
+	if err == nil {
+		t.Errorf("ReadBytes() expected error for plain http config url")
+	}
+}
@@ -7,8 +7,10 @@ import (
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 
+	"github.com/knadh/koanf/maps"
 	kyaml "github.com/knadh/koanf/parsers/yaml"
 	kfile "github.com/knadh/koanf/providers/file"
 	"github.com/knadh/koanf/providers/posflag"
@@ -31,6 +33,7 @@ func Setup(logLevel string) {
 	}
 
 	Log.SetLevel(level)
+	Log.AddHook(redactHook{})
 	Log.SetFormatter(&logrus.TextFormatter{
 		FullTimestamp: true,
 	})
@@ -42,35 +45,101 @@ func Setup(logLevel string) {
 	glog.SetLevel(lvl, "yq-lib")
 }
 
+// loadConfig loads the base config strictly and merges each overlay on top of it, values of an overlay
+// replace those loaded before even when their types differ, e.g. a map set over a null default
+func loadConfig(k *koanf.Koanf, base koanf.Provider, overlays []koanf.Provider) error {
+	parser := kyaml.Parser()
+	if base != nil {
+		if err := k.Load(base, parser); err != nil {
+			return err
+		}
+	}
+	for _, overlay := range overlays {
+		if err := k.Load(overlay, parser, koanf.WithMergeFunc(overrideMerge)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// overrideMerge merges src into dest with src winning, unlike the strict merge of the base config
+func overrideMerge(src, dest map[string]any) error {
+	maps.Merge(src, dest)
+	return nil
+}
+
 func SetupConfig() (*Config, error) {
 	f := pflag.NewFlagSet("config", pflag.ContinueOnError)
 	f.Usage = func() {
 		fmt.Println(f.FlagUsages())
 		os.Exit(0)
 	}
-	f.String("mode", "", "update|publish mode (overrides yaml file)")
+	f.Bool("version", false, "print the version, commit and Go version of this build and exit")
+	f.String("mode", "", "update|publish|check|doctor mode (overrides yaml file), delete mode needs --delete")
+	f.Bool("check", false, "report releases behind upstream and exit non-zero if any, same as --mode=check")
+	f.Bool("doctor", false, "run preflight checks of git, GitHub, directories and the registry without changing anything, same as --mode=doctor")
+	f.String("delete", "", "delete a chart version from the registry, e.g. oci://ghcr.io/krezh/charts/kubevirt:1.2.3, needs --confirm")
+	f.Bool("confirm", false, "confirm destructive operations like --delete")
 	f.Bool("offline", false, "skip git operations, useful for development")
+	f.Bool("dry-run", false, "generate charts in a scratch directory and print the branches and PRs that would be created")
+	f.Bool("diff", false, "print a diff of regenerated charts against the committed ones instead of committing")
+	f.String("output", "", "write packaged charts as a tar stream to this file instead of committing, - for stdout")
+	f.String("config-url", "", "load the config from an https:// URL or git::<repository>//<path>?ref=<ref>, over config.yaml")
+	f.StringSlice("config", nil, "additional config files overriding config.yaml and .local/config.yaml, later files win")
+	f.Duration("deadline", 0, "bound the whole run, e.g. 15m (overrides yaml file)")
 	f.String("log.level", "", "log level (overrides yaml file)")
 	f.String("pr.authToken", "", "user token for auth")
+	f.String("helm.remoteAuth.password", "", "password or token for the OCI registry")
+	f.Bool("values-only", false, "regenerate values.yaml of existing charts from the current upstream release, leaving templates untouched")
+	f.Bool("regenerate", false, "rebuild all charts from the assets cached in helm.cacheDir and the current modifications, offline")
+	f.Bool("allow-overwrite", false, "re-push chart versions that already exist in the registry, for development only")
+	f.Bool("fail-on-exists", false, "fail publishing when a chart version already exists in the registry instead of skipping it")
 	if err := f.Parse(os.Args[1:]); err != nil {
 		log.Fatalf("error parsing flags: %v", err)
 	}
+	if version, _ := f.GetBool("version"); version {
+		fmt.Println(ReadBuildInfo())
+		os.Exit(0)
+	}
 
 	k := koanf.NewWithConf(koanf.Conf{
 		Delim:       ".",
 		StrictMerge: true,
 	})
-	parser := kyaml.Parser()
-	files := []string{"config.yaml", ".local/config.yaml"}
-
-	for _, file := range files {
-		if fileExists(file) {
-			if err := k.Load(kfile.Provider(file), parser); err != nil {
-				log.Fatalf("error loading config: %v", err)
-			}
+	var base koanf.Provider
+	if fileExists("config.yaml") {
+		base = kfile.Provider("config.yaml")
+	}
+	// overlays apply in order, each overriding the previous: the remote config, .local/config.yaml for
+	// development and finally every --config file as given
+	overlays := make([]koanf.Provider, 0)
+	if fileExists(".local/config.yaml") {
+		overlays = append(overlays, kfile.Provider(".local/config.yaml"))
+	}
+	configFiles, _ := f.GetStringSlice("config")
+	for _, file := range configFiles {
+		overlays = append(overlays, kfile.Provider(file))
+	}
+	if configURL, _ := f.GetString("config-url"); configURL != "" {
+		// the remote config is authenticated with the token of the local config and flags
+		local := koanf.New(".")
+		if err := loadConfig(local, base, overlays); err != nil {
+			log.Fatalf("error loading config: %v", err)
 		}
+		if err := local.Load(posflag.Provider(f, ".", local), nil, koanf.WithMergeFunc(overrideMerge)); err != nil {
+			log.Fatalf("error loading config: %v", err)
+		}
+		token, err := resolveAuthToken(local.String("pr.authToken"), local.String("pr.authTokenFile"))
+		if err != nil {
+			return nil, err
+		}
+		overlays = append([]koanf.Provider{remoteConfig{source: configURL, token: token}}, overlays...)
+	}
+
+	if err := loadConfig(k, base, overlays); err != nil {
+		log.Fatalf("error loading config: %v", err)
 	}
-	if err := k.Load(posflag.Provider(f, ".", k), nil); err != nil {
+	if err := k.Load(posflag.Provider(f, ".", k), nil, koanf.WithMergeFunc(overrideMerge)); err != nil {
 		log.Fatalf("error loading config: %v", err)
 	}
 
@@ -80,20 +149,118 @@ func SetupConfig() (*Config, error) {
 		log.Fatalf("error unmarshalling config: %v", err)
 	}
 
-	// Fallback: if pr.authToken still empty, use GITHUB_TOKEN env
-	if config.PullRequest.AuthToken == "" {
-		if envTok := os.Getenv("GITHUB_TOKEN"); envTok != "" {
-			config.PullRequest.AuthToken = envTok
+	if check, _ := f.GetBool("check"); check {
+		config.ModeOfOperation = ModeCheck
+	}
+
+	if doctor, _ := f.GetBool("doctor"); doctor {
+		config.ModeOfOperation = ModeDoctor
+	}
+
+	// never read from config files, deleting needs both flags on the command line
+	if deleteRef, _ := f.GetString("delete"); deleteRef != "" {
+		config.ModeOfOperation = ModeDelete
+		config.DeleteRef = deleteRef
+		config.Confirm, _ = f.GetBool("confirm")
+	}
+
+	if dryRun, _ := f.GetBool("dry-run"); dryRun {
+		config.DryRun = true
+	}
+
+	if valuesOnly, _ := f.GetBool("values-only"); valuesOnly {
+		config.Helm.ValuesOnly = true
+	}
+
+	if regenerate, _ := f.GetBool("regenerate"); regenerate {
+		config.Helm.Regenerate = true
+	}
+
+	// regeneration never fetches, so neither git nor GitHub are touched
+	if config.Helm.Regenerate {
+		config.Offline = true
+	}
+
+	if allowOverwrite, _ := f.GetBool("allow-overwrite"); allowOverwrite {
+		config.Helm.AllowOverwrite = true
+	}
+
+	if failOnExists, _ := f.GetBool("fail-on-exists"); failOnExists {
+		config.Helm.FailOnExists = true
+	}
+
+	config.PullRequest.AuthToken, err = resolveAuthToken(config.PullRequest.AuthToken, config.PullRequest.AuthTokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fallback: if registry password still empty, use REGISTRY_PASSWORD env
+	if config.Helm.RemoteAuth.Password == "" {
+		if envPass := os.Getenv("REGISTRY_PASSWORD"); envPass != "" {
+			config.Helm.RemoteAuth.Password = envPass
+		}
+	}
+
+	if config.Helm.OciAnnotations.Source == "" && config.PullRequest.Owner != "" && config.PullRequest.Repo != "" {
+		config.Helm.OciAnnotations.Source = fmt.Sprintf("https://github.com/%s/%s", config.PullRequest.Owner, config.PullRequest.Repo)
+	}
+
+	if err := loadExpressionFiles(config.GlobalModifications); err != nil {
+		return nil, err
+	}
+	for _, release := range config.Releases {
+		if err := loadExpressionFiles(release.Modifications); err != nil {
+			return nil, fmt.Errorf("release %s: %w", release.Repo, err)
 		}
 	}
 
+	RegisterSecret(config.PullRequest.AuthToken)
+	RegisterSecret(config.Helm.RemoteAuth.Password)
+
 	if config.ModeOfOperation == "" {
-		log.Fatalf("No operation specified, use --mode=publish or --mode=update")
+		log.Fatalf("No operation specified, use --mode=publish, --mode=update, --check or --doctor")
 	}
 
 	return &config, nil
 }
 
+// resolveAuthToken returns the token authenticating GitHub requests: pr.authToken, falling back to
+// GITHUB_TOKEN and finally to the content of pr.authTokenFile, e.g. a mounted secret
+func resolveAuthToken(token, tokenFile string) (string, error) {
+	if token != "" {
+		return token, nil
+	}
+	if envTok := os.Getenv("GITHUB_TOKEN"); envTok != "" {
+		return envTok, nil
+	}
+	if tokenFile == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read pr.authTokenFile: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// loadExpressionFiles replaces the expression of modifications referencing an expressionFile with the file's content
+func loadExpressionFiles(mods []Modification) error {
+	for i := range mods {
+		if mods[i].ExpressionFile == "" {
+			continue
+		}
+		if mods[i].Expression != "" {
+			return fmt.Errorf("modification sets both expression and expressionFile %s", mods[i].ExpressionFile)
+		}
+		expression, err := os.ReadFile(mods[i].ExpressionFile)
+		if err != nil {
+			return fmt.Errorf("failed to read expressionFile: %w", err)
+		}
+		mods[i].Expression = string(expression)
+	}
+	return nil
+}
+
 func DeepMerge(first *map[string]any, second *map[string]any) *map[string]any {
 	out := make(map[string]any)
 
@@ -144,3 +311,22 @@ func fileExists(path string) bool {
 	_, err := os.Stat(path)
 	return !errors.Is(err, os.ErrNotExist)
 }
+
+// CheckWritable tells whether files can be created in dir, or in its nearest existing parent when dir
+// doesn't exist yet, the probe file is removed again
+func CheckWritable(dir string) error {
+	existing := dir
+	for !fileExists(existing) {
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		existing = parent
+	}
+	probe, err := os.CreateTemp(existing, ".write-check-")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", existing, err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
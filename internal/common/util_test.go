@@ -0,0 +1,155 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	kfile "github.com/knadh/koanf/providers/file"
+	"github.com/knadh/koanf/v2"
+)
+
+func TestLoadExpressionFiles(t *testing.T) {
+	program := ".metadata.namespace |= \"{{ .Release.Namespace }}\" |\n.metadata.labels.team = \"platform\"\n"
+	expressionFile := filepath.Join(t.TempDir(), "namespace.yq")
+	if err := os.WriteFile(expressionFile, []byte(program), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testCases := map[string]struct {
+		mod     Modification
+		want    string
+		wantErr bool
+	}{
+		"inline_expression": {mod: Modification{Expression: ".a = 1"}, want: ".a = 1"},
+		"expression_file":   {mod: Modification{ExpressionFile: expressionFile}, want: program},
+		"both_set":          {mod: Modification{Expression: ".a = 1", ExpressionFile: expressionFile}, wantErr: true},
+		"missing_file":      {mod: Modification{ExpressionFile: filepath.Join(t.TempDir(), "missing.yq")}, wantErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			mods := []Modification{tc.mod}
+
+			//when
+			err := loadExpressionFiles(mods)
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("loadExpressionFiles() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if !tc.wantErr && mods[0].Expression != tc.want {
+				t.Errorf("loadExpressionFiles() expression = %q, want %q", mods[0].Expression, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigOverlays(t *testing.T) {
+	//given
+	dir := t.TempDir()
+	base := filepath.Join(dir, "config.yaml")
+	local := filepath.Join(dir, "local.yaml")
+	extra := filepath.Join(dir, "extra.yaml")
+	files := map[string]string{
+		base:  "log:\n  level: warn\nhelm:\n  srcDir: charts\n  lintK8s: \"1.30.0\"\naddValues:\n",
+		local: "log:\n  level: debug\nhelm:\n  lintK8s: 1.31\naddValues:\n  team: platform\n",
+		extra: "log:\n  level: trace\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	k := koanf.NewWithConf(koanf.Conf{Delim: ".", StrictMerge: true})
+
+	//when
+	err := loadConfig(k, kfile.Provider(base), []koanf.Provider{kfile.Provider(local), kfile.Provider(extra)})
+
+	//then
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	want := map[string]string{
+		"log.level":      "trace",
+		"helm.srcDir":    "charts",
+		"helm.lintK8s":   "1.31",
+		"addValues.team": "platform",
+	}
+	for key, value := range want {
+		if got := k.String(key); got != value {
+			t.Errorf("loadConfig() %s = %q, want %q", key, got, value)
+		}
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	testCases := map[string]struct {
+		subdir   string
+		readOnly bool
+		wantErr  bool
+	}{
+		"existing":  {},
+		"missing":   {subdir: "charts/target"},
+		"read_only": {subdir: "charts", readOnly: true, wantErr: os.Geteuid() != 0},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			base := t.TempDir()
+			if tc.readOnly {
+				if err := os.Chmod(base, 0555); err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { os.Chmod(base, 0755) })
+			}
+
+			//when
+			err := CheckWritable(filepath.Join(base, tc.subdir))
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("CheckWritable() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if entries, _ := os.ReadDir(base); len(entries) > 0 {
+				t.Errorf("CheckWritable() left %s behind", entries[0].Name())
+			}
+		})
+	}
+}
+
+func TestResolveAuthToken(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("from-file\n"), 0600); err != nil {
+		t.Fatalf("failed to write token file: %v", err)
+	}
+	testCases := map[string]struct {
+		token     string
+		env       string
+		tokenFile string
+		want      string
+		wantErr   bool
+	}{
+		"config_wins":  {token: "from-config", env: "from-env", tokenFile: tokenFile, want: "from-config"},
+		"env":          {env: "from-env", tokenFile: tokenFile, want: "from-env"},
+		"file":         {tokenFile: tokenFile, want: "from-file"},
+		"none":         {want: ""},
+		"missing_file": {tokenFile: filepath.Join(t.TempDir(), "missing"), wantErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			t.Setenv("GITHUB_TOKEN", tc.env)
+
+			//when
+			got, err := resolveAuthToken(tc.token, tc.tokenFile)
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("resolveAuthToken() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("resolveAuthToken() = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
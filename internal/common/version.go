@@ -0,0 +1,82 @@
+package common
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// Version and Commit of the build, set via
+// -ldflags "-X github.com/krezh/charts/internal/common.Version=... -X github.com/krezh/charts/internal/common.Commit=..."
+var (
+	Version = "dev"
+	Commit  = ""
+)
+
+type BuildInfo struct {
+	Version   string
+	Commit    string
+	GoVersion string
+}
+
+// ReadBuildInfo returns the build information, values not set via -ldflags fall back to what the
+// Go toolchain embedded, e.g. the module version of go install builds and the VCS revision
+func ReadBuildInfo() BuildInfo {
+	info := BuildInfo{Version: Version, Commit: Commit, GoVersion: runtime.Version()}
+	embedded, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if info.Version == "dev" && embedded.Main.Version != "" && embedded.Main.Version != "(devel)" {
+		info.Version = embedded.Main.Version
+	}
+	if info.Commit == "" {
+		modified := false
+		for _, setting := range embedded.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				info.Commit = setting.Value
+			case "vcs.modified":
+				modified = setting.Value == "true"
+			}
+		}
+		if info.Commit != "" && modified {
+			info.Commit += "-dirty"
+		}
+	}
+	return info
+}
+
+func (b BuildInfo) String() string {
+	commit := b.Commit
+	if commit == "" {
+		commit = "unknown"
+	}
+	return fmt.Sprintf("krezh-charts %s (commit %s, %s)", b.Version, commit, b.GoVersion)
+}
+
+// WithVersionSuffix appends the resolved versionSuffix to version, the suffix must start a prerelease (-)
+// or build metadata (+) segment and the result must be valid semver, the segments the suffix sets are replaced,
+// so the version of a chart suffixed by an earlier run doesn't get a second suffix
+func WithVersionSuffix(version semver.Version, suffix string) (semver.Version, error) {
+	if suffix == "" {
+		return version, nil
+	}
+	prerelease := version.Prerelease()
+	switch {
+	case strings.HasPrefix(suffix, "-"):
+		prerelease = ""
+	case strings.HasPrefix(suffix, "+"):
+	default:
+		return version, fmt.Errorf("versionSuffix '%s' must start with - (prerelease) or + (build metadata)", suffix)
+	}
+	base := semver.New(version.Major(), version.Minor(), version.Patch(), prerelease, "")
+	suffixed, err := semver.StrictNewVersion(base.String() + suffix)
+	if err != nil {
+		return version, fmt.Errorf("versionSuffix '%s' gives the invalid version %s%s: %w", suffix, base.String(), suffix, err)
+	}
+	return *suffixed, nil
+}
@@ -0,0 +1,59 @@
+package common
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+func TestReadBuildInfo(t *testing.T) {
+	//given
+	t.Cleanup(func() { Version, Commit = "dev", "" })
+	Version, Commit = "v1.4.0", "0a1b2c3"
+
+	//when
+	info := ReadBuildInfo()
+
+	//then
+	want := BuildInfo{Version: "v1.4.0", Commit: "0a1b2c3", GoVersion: runtime.Version()}
+	if info != want {
+		t.Errorf("ReadBuildInfo() = %+v, want %+v", info, want)
+	}
+	if got := info.String(); got != "krezh-charts v1.4.0 (commit 0a1b2c3, "+runtime.Version()+")" {
+		t.Errorf("String() = %s", got)
+	}
+}
+
+func TestWithVersionSuffix(t *testing.T) {
+	testCases := map[string]struct {
+		version string
+		suffix  string
+		want    string
+		wantErr bool
+	}{
+		"none":                {version: "1.2.3", want: "1.2.3"},
+		"prerelease":          {version: "1.2.3", suffix: "-dev.20240601", want: "1.2.3-dev.20240601"},
+		"build_metadata":      {version: "1.2.3", suffix: "+abc1234", want: "1.2.3+abc1234"},
+		"both":                {version: "1.2.3", suffix: "-dev.20240601+abc1234", want: "1.2.3-dev.20240601+abc1234"},
+		"already_suffixed":    {version: "1.2.3-dev.20240531+0a1b2c3", suffix: "-dev.20240601+abc1234", want: "1.2.3-dev.20240601+abc1234"},
+		"keeps_prerelease":    {version: "1.2.3-rc.1+0a1b2c3", suffix: "+abc1234", want: "1.2.3-rc.1+abc1234"},
+		"missing_dash":        {version: "1.2.3", suffix: "dev", wantErr: true},
+		"invalid_segment":     {version: "1.2.3", suffix: "-dev..1", wantErr: true},
+		"leading_zero_number": {version: "1.2.3", suffix: "-dev.01", wantErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//when
+			got, err := WithVersionSuffix(*semver.MustParse(tc.version), tc.suffix)
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("WithVersionSuffix() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if err == nil && got.String() != tc.want {
+				t.Errorf("WithVersionSuffix() = %s, want %s", got.String(), tc.want)
+			}
+		})
+	}
+}
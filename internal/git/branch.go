@@ -0,0 +1,87 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	gogitplumbing "github.com/go-git/go-git/v5/plumbing"
+	"github.com/krezh/charts/internal/packager"
+)
+
+var invalidRefChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// sanitizeRefComponent replaces characters that are invalid or ambiguous in git refs, e.g. '/' and '+build'
+func sanitizeRefComponent(value string) string {
+	value = invalidRefChars.ReplaceAllString(value, "-")
+	for strings.Contains(value, "..") {
+		value = strings.ReplaceAll(value, "..", ".")
+	}
+	return strings.Trim(strings.TrimSuffix(value, ".lock"), ".-")
+}
+
+// BranchName renders the update branch for charts from branchTemplate,
+// ChartName, Version and AppVersion are sanitized before rendering
+func BranchName(branchTemplate string, charts *packager.HelmizedManifests) (string, error) {
+	return renderBranch(branchTemplate, map[string]string{
+		"ChartName":  sanitizeRefComponent(charts.Chart.Metadata.Name),
+		"Version":    sanitizeRefComponent(charts.Chart.Metadata.Version),
+		"AppVersion": sanitizeRefComponent(charts.AppVersion()),
+	})
+}
+
+// PlannedBranchName renders the update branch of a chart before it is generated,
+// false is returned when branchTemplate needs the chart version, which is only known afterwards
+func PlannedBranchName(branchTemplate, chartName, appVersion string) (string, bool, error) {
+	branch, err := renderBranch(branchTemplate, map[string]string{
+		"ChartName":  sanitizeRefComponent(chartName),
+		"AppVersion": sanitizeRefComponent(appVersion),
+	})
+	if needsVersion(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return branch, true, nil
+}
+
+// BranchNeedsVersion tells whether branchTemplate references the chart version, so no branch can be planned
+// before generation
+func BranchNeedsVersion(branchTemplate string) (bool, error) {
+	_, planned, err := PlannedBranchName(branchTemplate, "chart", "v0.0.0")
+	if err != nil {
+		return false, err
+	}
+	return !planned, nil
+}
+
+// needsVersion tells whether err comes from rendering a branch template referencing the missing chart version,
+// other errors, e.g. of mistyped fields, are no reason to wait for generation
+func needsVersion(err error) bool {
+	var execErr template.ExecError
+	return errors.As(err, &execErr) && strings.Contains(execErr.Error(), `map has no entry for key "Version"`)
+}
+
+func renderBranch(branchTemplate string, data map[string]string) (string, error) {
+	if branchTemplate == "" {
+		branchTemplate = DefaultBranchTemplate
+	}
+	tmpl, err := template.New("branch").Option("missingkey=error").Parse(branchTemplate)
+	if err != nil {
+		return "", fmt.Errorf("invalid branch template '%s': %w", branchTemplate, err)
+	}
+	out := new(strings.Builder)
+	err = tmpl.Execute(out, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to render branch template '%s': %w", branchTemplate, err)
+	}
+
+	branch := out.String()
+	if err := gogitplumbing.NewBranchReferenceName(branch).Validate(); err != nil {
+		return "", fmt.Errorf("branch template '%s' produced invalid branch name %s: %w", branchTemplate, branch, err)
+	}
+	return branch, nil
+}
@@ -0,0 +1,113 @@
+package git
+
+import (
+	"testing"
+
+	"github.com/krezh/charts/internal/packager"
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+func TestBranchName(t *testing.T) {
+	testCases := map[string]struct {
+		template   string
+		appVersion string
+		want       string
+		wantErr    bool
+	}{
+		"default_template": {
+			appVersion: "v1.2.3",
+			want:       "update/kubevirt-v1.2.3",
+		},
+		"build_metadata": {
+			appVersion: "v1.2.3+build.1",
+			want:       "update/kubevirt-v1.2.3-build.1",
+		},
+		"slash_in_app_version": {
+			appVersion: "release/v1.2",
+			want:       "update/kubevirt-release-v1.2",
+		},
+		"custom_template": {
+			template:   "charts/{{.ChartName}}/{{.Version}}",
+			appVersion: "v1.2.3",
+			want:       "charts/kubevirt/1.2.3",
+		},
+		"invalid_result": {
+			template:   "update//{{.ChartName}}",
+			appVersion: "v1.2.3",
+			wantErr:    true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			charts := &packager.HelmizedManifests{
+				Chart: &chart.Chart{Metadata: &chart.Metadata{Name: "kubevirt", Version: "1.2.3", AppVersion: tc.appVersion}},
+			}
+
+			//when
+			got, err := BranchName(tc.template, charts)
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("BranchName() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("BranchName() = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPlannedBranchName(t *testing.T) {
+	testCases := map[string]struct {
+		template    string
+		want        string
+		wantPlanned bool
+		wantErr     bool
+	}{
+		"default_template": {want: "update/kubevirt-v1.2.3", wantPlanned: true},
+		"needs_version":    {template: "charts/{{.ChartName}}/{{.Version}}"},
+		"invalid_template": {template: "update/{{.ChartName", wantErr: true},
+		"mistyped_field":   {template: "update/{{.ChartNmae}}", wantErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//when
+			got, planned, err := PlannedBranchName(tc.template, "kubevirt", "v1.2.3")
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("PlannedBranchName() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if got != tc.want || planned != tc.wantPlanned {
+				t.Errorf("PlannedBranchName() = %s, %v, want %s, %v", got, planned, tc.want, tc.wantPlanned)
+			}
+		})
+	}
+}
+
+func TestBranchNeedsVersion(t *testing.T) {
+	testCases := map[string]struct {
+		template string
+		want     bool
+		wantErr  bool
+	}{
+		"default_template": {},
+		"needs_version":    {template: "charts/{{.ChartName}}/{{.Version}}", want: true},
+		"mistyped_field":   {template: "charts/{{.Versoin}}", wantErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//when
+			got, err := BranchNeedsVersion(tc.template)
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("BranchNeedsVersion() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("BranchNeedsVersion() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
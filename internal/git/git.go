@@ -4,6 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -11,20 +14,30 @@ import (
 	"github.com/go-git/go-git/v5/config"
 	gogitplumbing "github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/krezh/charts/internal/common"
 	"github.com/krezh/charts/internal/packager"
 )
 
 const (
-	RemoteOrigin = "origin"
+	RemoteOrigin          = "origin"
+	DefaultBranchTemplate = "update/{{.ChartName}}-{{.AppVersion}}"
 )
 
 type Client struct {
 	Repository *gogit.Repository
 	usesSsh    bool
+	// OnUnstagedChanges is what CreateBranch does when checkout fails on unstaged changes, fail when empty
+	OnUnstagedChanges string
+	// KeepPaths are never reset by the reset policy of OnUnstagedChanges, e.g. the charts directory,
+	// relative to the worktree or absolute
+	KeepPaths []string
 }
 
+// ErrBranchSkipped is returned by CreateBranch when unstaged changes block the checkout and OnUnstagedChanges is skip
+var ErrBranchSkipped = errors.New("branch skipped")
+
 func NewClient(repoPath string) (*Client, error) {
 	repo, err := gogit.PlainOpen(repoPath)
 	if err != nil {
@@ -45,6 +58,10 @@ func NewClient(repoPath string) (*Client, error) {
 		}
 	}
 
+	// share the proxy-aware HTTP client with the GitHub and registry clients
+	client.InstallProtocol("https", http.NewClient(common.HTTPClient))
+	client.InstallProtocol("http", http.NewClient(common.HTTPClient))
+
 	return &Client{
 		Repository: repo,
 		usesSsh:    usesSsh,
@@ -80,45 +97,67 @@ func (g *Client) BranchExists(branchName string) (bool, error) {
 	return false, fmt.Errorf("failed to check remote branch '%s': %w", branchName, err)
 }
 
+// CreateBranch creates branchName from defaultBranch and checks it out, the worktree follows the branch so files
+// committed on a previously created branch don't leak into it, untracked files are kept,
+// changes to tracked files block the checkout unless OnUnstagedChanges resets them or skips the branch
 func (g *Client) CreateBranch(defaultBranch, branchName string) error {
-	defaultRefName := gogitplumbing.NewBranchReferenceName(defaultBranch)
-	defaultRef, err := g.Repository.Reference(defaultRefName, true)
+	switch g.OnUnstagedChanges {
+	case "", common.UnstagedChangesFail, common.UnstagedChangesReset, common.UnstagedChangesSkip:
+	default:
+		return fmt.Errorf("unknown onUnstagedChanges '%s'", g.OnUnstagedChanges)
+	}
+
+	defaultRef, err := g.defaultBranchRef(defaultBranch)
 	if err != nil {
 		common.Log.Errorf("Failed to get reference for branch %s: %v", defaultBranch, err)
 		return err
 	}
 
+	wt, err := g.Repository.Worktree()
+	if err != nil {
+		common.Log.Errorf("Failed to get worktree: %v", err)
+		return err
+	}
+
 	refName := gogitplumbing.NewBranchReferenceName(branchName)
-	err = g.Repository.Storer.SetReference(gogitplumbing.NewHashReference(refName, defaultRef.Hash()))
+	// checked before the branch is created, a checkout blocked half-way would leave HEAD on the new branch
+	files, err := g.blockingChanges(wt)
+	if err == nil && len(files) > 0 && g.OnUnstagedChanges == common.UnstagedChangesReset {
+		var reset []string
+		reset, err = g.resetUnrelatedChanges(wt)
+		if err == nil {
+			common.Log.Warnf("Discarded unstaged changes outside %v blocking checkout of %s: %v", g.KeepPaths, refName, reset)
+			files, err = g.blockingChanges(wt)
+		}
+	}
 	if err != nil {
-		common.Log.Errorf("Failed to create branch: %s, due to: %v", refName, err)
+		common.Log.Errorf("Failed to checkout branch: %s, due to: %v", refName, err)
 		return err
 	}
+	if len(files) > 0 {
+		if g.OnUnstagedChanges == common.UnstagedChangesSkip {
+			common.Log.Warnf("Skipping branch %s, worktree contains unstaged changes in files: %v", refName, files)
+			return fmt.Errorf("%w: %s: %w", ErrBranchSkipped, branchName, gogit.ErrUnstagedChanges)
+		}
+		common.Log.Errorf("Failed to checkout branch: %s, worktree contains unstaged changes in files: %v, "+
+			"commit or discard them, or set pr.onUnstagedChanges to reset or skip", refName, files)
+		return gogit.ErrUnstagedChanges
+	}
 
-	wt, err := g.Repository.Worktree()
+	err = g.Repository.Storer.SetReference(gogitplumbing.NewHashReference(refName, defaultRef.Hash()))
 	if err != nil {
-		common.Log.Errorf("Failed to get worktree: %v", err)
+		common.Log.Errorf("Failed to create branch: %s, due to: %v", refName, err)
 		return err
 	}
 
 	common.Log.Infof("Switching branch to: %v", refName)
-	err = wt.Checkout(&gogit.CheckoutOptions{
-		Branch: refName,
-		Keep:   true, // allows to checkout even if there are unstaged changes
-		Create: false,
-		Force:  false,
-	})
+	err = wt.Checkout(&gogit.CheckoutOptions{Branch: refName})
 	if err != nil {
-		if err == gogit.ErrUnstagedChanges {
-			status, _ := wt.Status()
-			var files []string
-			for file := range status {
-				files = append(files, file)
-			}
-			common.Log.Errorf("Failed to checkout branch: %s, worktree contains unstaged changes in files: %v", refName, files)
-		} else {
-			common.Log.Errorf("Failed to checkout branch: %s, due to: %v", refName, err)
+		// the branch is dropped again so a re-run doesn't mistake it for an update branch of an earlier run
+		if removeErr := g.Repository.Storer.RemoveReference(refName); removeErr != nil {
+			common.Log.Warnf("Failed to remove branch %s: %v", refName, removeErr)
 		}
+		common.Log.Errorf("Failed to checkout branch: %s, due to: %v", refName, err)
 		return err
 	}
 
@@ -128,42 +167,256 @@ func (g *Client) CreateBranch(defaultBranch, branchName string) error {
 	return nil
 }
 
+// blockingChanges lists the changed tracked files a checkout would overwrite, sorted
+func (g *Client) blockingChanges(wt *gogit.Worktree) ([]string, error) {
+	status, err := wt.Status()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get status: %w", err)
+	}
+	files := make([]string, 0)
+	for _, file := range changedFiles(status) {
+		if status[file].Worktree != gogit.Untracked {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+func changedFiles(status gogit.Status) []string {
+	files := make([]string, 0, len(status))
+	for file, fileStatus := range status {
+		if fileStatus.Worktree != gogit.Unmodified || fileStatus.Staging != gogit.Unmodified {
+			files = append(files, file)
+		}
+	}
+	slices.Sort(files)
+	return files
+}
+
+// resetUnrelatedChanges restores changed files outside KeepPaths to HEAD and removes untracked ones,
+// it returns the discarded files
+func (g *Client) resetUnrelatedChanges(wt *gogit.Worktree) ([]string, error) {
+	status, err := wt.Status()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get status: %w", err)
+	}
+	keepPaths, err := worktreePaths(wt.Filesystem.Root(), g.KeepPaths)
+	if err != nil {
+		return nil, err
+	}
+	tracked := make([]string, 0)
+	discarded := make([]string, 0)
+	for _, file := range changedFiles(status) {
+		if slices.ContainsFunc(keepPaths, func(keepPath string) bool {
+			return keepPath == "." || withinPath(file, keepPath)
+		}) {
+			continue
+		}
+		discarded = append(discarded, file)
+		if status[file].Worktree == gogit.Untracked {
+			if err := wt.Filesystem.Remove(file); err != nil {
+				return nil, fmt.Errorf("failed to remove %s: %w", file, err)
+			}
+			continue
+		}
+		tracked = append(tracked, file)
+	}
+	if len(tracked) > 0 {
+		if err := wt.Restore(&gogit.RestoreOptions{Files: tracked, Staged: true, Worktree: true}); err != nil {
+			return nil, fmt.Errorf("failed to restore: %w", err)
+		}
+	}
+	return discarded, nil
+}
+
+// worktreePaths converts paths to the slash-separated form of git status relative to the worktree at root,
+// relative paths are taken relative to root, paths outside the worktree are dropped
+func worktreePaths(root string, paths []string) ([]string, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve worktree %s: %w", root, err)
+	}
+	cleaned := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		rel := filepath.Clean(path)
+		if filepath.IsAbs(path) {
+			rel, err = filepath.Rel(absRoot, path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve %s against worktree %s: %w", path, root, err)
+			}
+		}
+		if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		cleaned = append(cleaned, filepath.ToSlash(rel))
+	}
+	return cleaned, nil
+}
+
+// defaultBranchRef resolves the local default branch, CI checkouts often lack it:
+// it is then created from origin/<defaultBranch> or, on a detached HEAD, from HEAD
+func (g *Client) defaultBranchRef(defaultBranch string) (*gogitplumbing.Reference, error) {
+	defaultRefName := gogitplumbing.NewBranchReferenceName(defaultBranch)
+	defaultRef, err := g.Repository.Reference(defaultRefName, true)
+	if err == nil {
+		return defaultRef, nil
+	}
+	if !errors.Is(err, gogitplumbing.ErrReferenceNotFound) {
+		return nil, err
+	}
+
+	var source *gogitplumbing.Reference
+	if remoteRef, err := g.Repository.Reference(gogitplumbing.NewRemoteReferenceName(RemoteOrigin, defaultBranch), true); err == nil {
+		common.Log.Infof("Local branch %s missing, creating it from %s", defaultBranch, remoteRef.Name().Short())
+		source = remoteRef
+	} else if head, err := g.Repository.Head(); err == nil && head.Name() == gogitplumbing.HEAD {
+		common.Log.Warnf("Detached HEAD at %s, creating local branch %s from it", head.Hash(), defaultBranch)
+		source = head
+	} else {
+		return nil, fmt.Errorf("branch %s not found locally nor on %s and HEAD is not detached, "+
+			"check out the repository with fetch-depth: 0 and a branch: %w", defaultBranch, RemoteOrigin, gogitplumbing.ErrReferenceNotFound)
+	}
+
+	defaultRef = gogitplumbing.NewHashReference(defaultRefName, source.Hash())
+	if err := g.Repository.Storer.SetReference(defaultRef); err != nil {
+		return nil, fmt.Errorf("failed to create branch %s: %w", defaultBranch, err)
+	}
+	return defaultRef, nil
+}
+
 // Commit commits all charts from
-// charts.Path/{charts.Chart.Metadata.Name} and
-// charts.Path/crds/{charts.CrdChart.Metadata.Name}
-func (g *Client) Commit(charts *packager.HelmizedManifests) error {
+// chartsDir/{charts.Chart.Metadata.Name},
+// chartsDir/{charts.CrdChart.Metadata.Name} and,
+// for umbrella members, the umbrella Chart.yaml and values.yaml, and charts.Files,
+// templates removed upstream are staged as deletions as adding a directory stages its removed files,
+// the split strategy commits CRDs, templates and values/metadata separately,
+// chartsDir is where the charts are installed, relative to the worktree or absolute within it
+func (g *Client) Commit(charts *packager.HelmizedManifests, chartsDir, strategy string) error {
 	wt, err := g.Repository.Worktree()
 	if err != nil {
 		return fmt.Errorf("failed to get worktree: %w", err)
 	}
+	repoPaths, err := worktreePaths(wt.Filesystem.Root(), []string{chartsDir})
+	if err != nil {
+		return err
+	}
+	if len(repoPaths) == 0 {
+		return fmt.Errorf("charts directory '%s' is not within the repository", chartsDir)
+	}
+	repoChartsDir := repoPaths[0]
 
-	chartPath := fmt.Sprintf("%s/%s", charts.Path, charts.Chart.Metadata.Name)
-	crdsChartPath := fmt.Sprintf("%s/%s", charts.Path, charts.CrdChart.Metadata.Name)
+	chartPaths := make([]string, 0)
+	for _, chartDir := range charts.ChartDirs() {
+		chartPaths = append(chartPaths, path.Join(repoChartsDir, chartDir))
+	}
+	for _, file := range charts.UmbrellaFiles() {
+		chartPaths = append(chartPaths, path.Join(repoChartsDir, file))
+	}
+	chartPaths = append(chartPaths, charts.Files...)
 
-	err = g.unstage(wt, chartPath, crdsChartPath)
+	err = g.unstage(wt, chartPaths...)
 	if err != nil {
-		return fmt.Errorf("failed to unstage files irrelevant to: %s, due to: %v", charts.Path, err)
+		return fmt.Errorf("failed to unstage files irrelevant to: %s, due to: %v", chartsDir, err)
+	}
+
+	message := fmt.Sprintf("Automated update to version: %s", charts.AppVersion())
+	if strategy == common.CommitStrategySplit {
+		crdsChartPath := ""
+		if crdChartDir := charts.CrdChartDir(); crdChartDir != "" {
+			crdsChartPath = path.Join(repoChartsDir, crdChartDir)
+		}
+		return g.commitByConcern(wt, message, chartPaths, crdsChartPath)
 	}
 
 	// Add all chart files
-	_, err = wt.Add(chartPath)
+	headRef, _ := g.Repository.Head()
+	for _, chartPath := range chartPaths {
+		_, err = wt.Add(chartPath)
+		if err != nil {
+			return fmt.Errorf("failed to add chart %s: %w", chartPath, err)
+		}
+		common.Log.Infof("Added chart files from path: %s (current branch: %s)", chartPath, headRef.Name().Short())
+	}
+
+	err = g.commit(wt, message)
 	if err != nil {
-		return fmt.Errorf("failed to add chart %s: %w", chartPath, err)
+		return err
 	}
-	headRef, _ := g.Repository.Head()
-	common.Log.Infof("Added chart files from path: %s (current branch: %s)", chartPath, headRef.Name().Short())
 
-	// Add all CRD chart files
-	if charts.CrdChart != nil {
-		_, err = wt.Add(crdsChartPath)
-		if err != nil {
-			return fmt.Errorf("failed to add CRD chart %s: %w", crdsChartPath, err)
+	g.status(wt)
+
+	return nil
+}
+
+// withinPath tells whether the slash-separated worktree path file is dir or lies below it, so charts/kubevirt
+// doesn't claim charts/kubevirt-crds
+func withinPath(file, dir string) bool {
+	return file == dir || strings.HasPrefix(file, dir+"/")
+}
+
+// commitByConcern makes one commit per concern with changed files, in order: CRDs, templates, values and metadata
+func (g *Client) commitByConcern(wt *gogit.Worktree, message string, chartPaths []string, crdsChartPath string) error {
+	status, err := wt.Status()
+	if err != nil {
+		return fmt.Errorf("failed to get status: %w", err)
+	}
+	changed := make([]string, 0)
+	for filePath, fileStatus := range status {
+		if fileStatus.Worktree == gogit.Unmodified && fileStatus.Staging == gogit.Unmodified {
+			continue
+		}
+		if slices.ContainsFunc(chartPaths, func(chartPath string) bool { return withinPath(filePath, chartPath) }) {
+			changed = append(changed, filePath)
+		}
+	}
+	slices.Sort(changed)
+
+	groups := groupByConcern(changed, crdsChartPath)
+	for _, concern := range concerns {
+		files := groups[concern]
+		if len(files) == 0 {
+			continue
+		}
+		for _, filePath := range files {
+			if _, err := wt.Add(filePath); err != nil {
+				return fmt.Errorf("failed to add file %s: %w", filePath, err)
+			}
+		}
+		common.Log.Infof("Committing %d %s files", len(files), concern)
+		if err := g.commit(wt, fmt.Sprintf("%s (%s)", message, concern)); err != nil {
+			return err
+		}
+	}
+
+	g.status(wt)
+
+	return nil
+}
+
+var concerns = []string{"CRDs", "templates", "values and metadata"}
+
+// groupByConcern assigns chart files to concerns: anything in the CRD chart or a crds/ directory,
+// anything in a templates/ directory, and everything else
+func groupByConcern(files []string, crdsChartPath string) map[string][]string {
+	groups := make(map[string][]string)
+	for _, filePath := range files {
+		concern := concerns[2]
+		if (crdsChartPath != "" && strings.HasPrefix(filePath, crdsChartPath+"/")) || strings.Contains(filePath, "/crds/") {
+			concern = concerns[0]
+		} else if strings.Contains(filePath, "/templates/") {
+			concern = concerns[1]
 		}
-		common.Log.Infof("Added crd-chart files from path: %s (current branch: %s)", crdsChartPath, headRef.Name().Short())
+		groups[concern] = append(groups[concern], filePath)
 	}
+	return groups
+}
 
-	_, err = wt.Commit(
-		fmt.Sprintf("Automated update to version: %s", charts.AppVersion()),
+func (g *Client) commit(wt *gogit.Worktree, message string) error {
+	_, err := wt.Commit(
+		message,
 		&gogit.CommitOptions{
 			Author: &object.Signature{
 				Name:  "charts-bot",
@@ -174,10 +427,29 @@ func (g *Client) Commit(charts *packager.HelmizedManifests) error {
 	if err != nil {
 		return fmt.Errorf("failed to commit: %w", err)
 	}
+	return nil
+}
 
-	g.status(wt)
+// HeadShortHash returns the abbreviated hash of the checked out commit
+func (g *Client) HeadShortHash() (string, error) {
+	head, err := g.Repository.Head()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	return head.Hash().String()[:7], nil
+}
 
-	return nil
+// OriginURL returns the first URL of the "origin" remote, which Push publishes to
+func (g *Client) OriginURL() (string, error) {
+	remote, err := g.Repository.Remote(RemoteOrigin)
+	if err != nil {
+		return "", fmt.Errorf("no %s remote: %w", RemoteOrigin, err)
+	}
+	urls := remote.Config().URLs
+	if len(urls) == 0 {
+		return "", fmt.Errorf("remote %s has no URL", RemoteOrigin)
+	}
+	return common.Redact(urls[0]), nil
 }
 
 // Push publishes the branch to the remote named "origin"
@@ -205,7 +477,8 @@ func (g *Client) Push(ctx context.Context, prSettings *common.PullRequest, branc
 		}
 	}
 
-	err := g.Repository.PushContext(ctx, pushOptions)
+	// go-git errors may carry the remote URL including credentials
+	err := common.RedactError(g.Repository.PushContext(ctx, pushOptions))
 	if err != nil {
 		if errors.Is(err, gogit.NoErrAlreadyUpToDate) {
 			common.Log.Infof("Branch %s already up-to-date on remote", branch)
@@ -219,19 +492,17 @@ func (g *Client) Push(ctx context.Context, prSettings *common.PullRequest, branc
 	return nil
 }
 
-func (g *Client) unstage(wt *gogit.Worktree, chartPath, crdsChartPath string) error {
+func (g *Client) unstage(wt *gogit.Worktree, chartPaths ...string) error {
 	status, err := wt.Status()
 	if err != nil {
 		return fmt.Errorf("failed to get status: %w", err)
 	}
 	unstageFiles := make([]string, 0)
 	for filePath, status := range status {
-		if strings.HasPrefix(filePath, chartPath) || strings.HasPrefix(filePath, crdsChartPath) {
-			_, err = wt.Add(filePath)
-			if err != nil {
-				return fmt.Errorf("failed to add file %s: %w", filePath, err)
-			}
-		} else if status.Staging == gogit.Modified || status.Staging == gogit.Deleted || status.Worktree == gogit.Added || status.Worktree == gogit.Renamed {
+		if slices.ContainsFunc(chartPaths, func(chartPath string) bool { return withinPath(filePath, chartPath) }) {
+			continue // staged by Commit
+		}
+		if status.Staging == gogit.Modified || status.Staging == gogit.Deleted || status.Worktree == gogit.Added || status.Worktree == gogit.Renamed {
 			unstageFiles = append(unstageFiles, filePath)
 		}
 	}
@@ -0,0 +1,397 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	gogitplumbing "github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/krezh/charts/internal/common"
+	"github.com/krezh/charts/internal/packager"
+)
+
+func TestMain(m *testing.M) {
+	common.Setup("debug")
+	exitVal := m.Run()
+	os.Exit(exitVal)
+}
+
+func TestCreateBranchDetachedHead(t *testing.T) {
+	//given
+	repo, head := initRepo(t)
+	wt, _ := repo.Worktree()
+	if err := wt.Checkout(&gogit.CheckoutOptions{Hash: head}); err != nil {
+		t.Fatalf("failed to detach HEAD: %v", err)
+	}
+	if err := repo.Storer.RemoveReference(gogitplumbing.NewBranchReferenceName("main")); err != nil {
+		t.Fatalf("failed to remove main: %v", err)
+	}
+	client := &Client{Repository: repo}
+
+	//when
+	err := client.CreateBranch("main", "update/test")
+
+	//then
+	if err != nil {
+		t.Fatalf("CreateBranch() error = %v", err)
+	}
+	ref, err := repo.Reference(gogitplumbing.NewBranchReferenceName("update/test"), true)
+	if err != nil || ref.Hash() != head {
+		t.Errorf("CreateBranch() branch = %v, %v, want %s", ref, err, head)
+	}
+}
+
+func TestCreateBranchMissingDefaultBranch(t *testing.T) {
+	//given
+	repo, _ := initRepo(t)
+	client := &Client{Repository: repo}
+
+	//when
+	err := client.CreateBranch("develop", "update/test")
+
+	//then
+	if !errors.Is(err, gogitplumbing.ErrReferenceNotFound) {
+		t.Errorf("CreateBranch() error = %v, want %v", err, gogitplumbing.ErrReferenceNotFound)
+	}
+}
+
+func TestOriginURL(t *testing.T) {
+	testCases := map[string]struct {
+		urls    []string
+		want    string
+		wantErr bool
+	}{
+		"origin":    {urls: []string{"git@github.com:krezh/charts.git"}, want: "git@github.com:krezh/charts.git"},
+		"no_origin": {wantErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			repo, _ := initRepo(t)
+			if tc.urls != nil {
+				if _, err := repo.CreateRemote(&config.RemoteConfig{Name: RemoteOrigin, URLs: tc.urls}); err != nil {
+					t.Fatalf("failed to create remote: %v", err)
+				}
+			}
+			client := &Client{Repository: repo}
+
+			//when
+			url, err := client.OriginURL()
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("OriginURL() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if url != tc.want {
+				t.Errorf("OriginURL() = %s, want %s", url, tc.want)
+			}
+		})
+	}
+}
+
+func TestHeadShortHash(t *testing.T) {
+	//given
+	repo, head := initRepo(t)
+	client := &Client{Repository: repo}
+
+	//when
+	hash, err := client.HeadShortHash()
+
+	//then
+	if err != nil {
+		t.Fatalf("HeadShortHash() error = %v", err)
+	}
+	if hash != head.String()[:7] {
+		t.Errorf("HeadShortHash() = %s, want %s", hash, head.String()[:7])
+	}
+}
+
+func TestResetUnrelatedChanges(t *testing.T) {
+	//given
+	repo, _ := initRepo(t)
+	wt, _ := repo.Worktree()
+	root := wt.Filesystem.Root()
+	files := map[string]string{
+		"README.md":                  "modified\n",
+		"notes.txt":                  "scratch\n",
+		"charts/kubevirt/Chart.yaml": "name: kubevirt\n",
+		"charts-old/Chart.yaml":      "name: old\n",
+		"cache/kubevirt.yaml":        "kind: Deployment\n",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, path)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, path), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := &Client{Repository: repo, KeepPaths: []string{"./charts/", filepath.Join(root, "cache"), "/outside"}}
+
+	//when
+	discarded, err := client.resetUnrelatedChanges(wt)
+
+	//then
+	if err != nil {
+		t.Fatalf("resetUnrelatedChanges() error = %v", err)
+	}
+	if !reflect.DeepEqual(discarded, []string{"README.md", "charts-old/Chart.yaml", "notes.txt"}) {
+		t.Errorf("resetUnrelatedChanges() discarded %v, want README.md, charts-old/Chart.yaml and notes.txt", discarded)
+	}
+	if content, _ := os.ReadFile(filepath.Join(root, "README.md")); string(content) != "charts\n" {
+		t.Errorf("resetUnrelatedChanges() README.md = %q, want it restored", content)
+	}
+	if _, err := os.Stat(filepath.Join(root, "notes.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("resetUnrelatedChanges() kept the untracked notes.txt")
+	}
+	if _, err := os.Stat(filepath.Join(root, "charts/kubevirt/Chart.yaml")); err != nil {
+		t.Errorf("resetUnrelatedChanges() removed the generated chart: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "cache/kubevirt.yaml")); err != nil {
+		t.Errorf("resetUnrelatedChanges() removed the cached asset: %v", err)
+	}
+}
+
+func TestCreateBranchUnknownOnUnstagedChanges(t *testing.T) {
+	//given
+	repo, _ := initRepo(t)
+	client := &Client{Repository: repo, OnUnstagedChanges: "stash"}
+
+	//when
+	err := client.CreateBranch("main", "update/test")
+
+	//then
+	if err == nil {
+		t.Fatalf("CreateBranch() expected error for an unknown onUnstagedChanges")
+	}
+	if _, err := repo.Reference(gogitplumbing.NewBranchReferenceName("update/test"), true); err == nil {
+		t.Errorf("CreateBranch() created the branch despite the invalid configuration")
+	}
+}
+
+func TestCreateBranchLeavesPreviousBranchCharts(t *testing.T) {
+	//given
+	repo, _ := initRepo(t)
+	wt, _ := repo.Worktree()
+	root := wt.Filesystem.Root()
+	client := &Client{Repository: repo}
+	if err := client.CreateBranch("main", "update/kubevirt"); err != nil {
+		t.Fatalf("CreateBranch() error = %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "charts/kubevirt"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "charts/kubevirt/Chart.yaml"), []byte("name: kubevirt\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Add("charts/kubevirt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Commit("kubevirt", &gogit.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	//when
+	err := client.CreateBranch("main", "update/cdi")
+
+	//then
+	if err != nil {
+		t.Fatalf("CreateBranch() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "charts/kubevirt/Chart.yaml")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("CreateBranch() kept the chart committed on update/kubevirt in the worktree")
+	}
+	status, _ := wt.Status()
+	if !status.IsClean() {
+		t.Errorf("CreateBranch() worktree = %v, want it clean", status)
+	}
+}
+
+func TestCreateBranchUnstagedChanges(t *testing.T) {
+	testCases := map[string]struct {
+		policy     string
+		wantErr    error
+		wantBranch bool
+	}{
+		"fail":  {policy: common.UnstagedChangesFail, wantErr: gogit.ErrUnstagedChanges},
+		"skip":  {policy: common.UnstagedChangesSkip, wantErr: ErrBranchSkipped},
+		"reset": {policy: common.UnstagedChangesReset, wantBranch: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			repo, _ := initRepo(t)
+			wt, _ := repo.Worktree()
+			if err := os.WriteFile(filepath.Join(wt.Filesystem.Root(), "README.md"), []byte("modified\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			client := &Client{Repository: repo, OnUnstagedChanges: tc.policy}
+
+			//when
+			err := client.CreateBranch("main", "update/test")
+
+			//then
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("CreateBranch() error = %v, want %v", err, tc.wantErr)
+			}
+			_, refErr := repo.Reference(gogitplumbing.NewBranchReferenceName("update/test"), true)
+			if (refErr == nil) != tc.wantBranch {
+				t.Errorf("CreateBranch() branch created = %v, want %v", refErr == nil, tc.wantBranch)
+			}
+			head, _ := repo.Head()
+			if !tc.wantBranch && head.Name() != gogitplumbing.NewBranchReferenceName("main") {
+				t.Errorf("CreateBranch() HEAD = %s, want it left on main", head.Name())
+			}
+		})
+	}
+}
+
+// initRepo creates a repository with a single commit on main
+func initRepo(t *testing.T) (*gogit.Repository, gogitplumbing.Hash) {
+	dir := t.TempDir()
+	repo, err := gogit.PlainInitWithOptions(dir, &gogit.PlainInitOptions{
+		InitOptions: gogit.InitOptions{DefaultBranch: gogitplumbing.NewBranchReferenceName("main")},
+	})
+	if err != nil {
+		t.Fatalf("failed to init repo: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("charts\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	wt, _ := repo.Worktree()
+	if _, err := wt.Add("README.md"); err != nil {
+		t.Fatalf("failed to add file: %v", err)
+	}
+	head, err := wt.Commit("initial", &gogit.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	return repo, head
+}
+
+func TestCommitStagesUpstreamRemovals(t *testing.T) {
+	testCases := map[string]struct {
+		chartType string
+		strategy  string
+		removed   string
+	}{
+		"single":  {strategy: common.CommitStrategySingle, removed: "charts/operator/templates/secret.yaml"},
+		"split":   {strategy: common.CommitStrategySplit, removed: "charts/operator/templates/secret.yaml"},
+		"library": {chartType: common.ChartTypeLibrary, strategy: common.CommitStrategySingle, removed: "charts/operator/templates/_secret.tpl"},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			repo, _ := initRepo(t)
+			wt, _ := repo.Worktree()
+			repoDir := wt.Filesystem.Root()
+			client := &Client{Repository: repo}
+			settings := &common.HelmSettings{SrcDir: filepath.Join(repoDir, "charts"), LintK8s: "1.30.0", DanglingValues: common.DanglingValuesIgnore}
+			if err := os.MkdirAll(settings.SrcDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			configMap := map[string]any{"apiVersion": "v1", "kind": "ConfigMap", "metadata": map[string]any{"name": "operator"}, "data": map[string]any{"mode": "ha"}}
+			secret := map[string]any{"apiVersion": "v1", "kind": "Secret", "metadata": map[string]any{"name": "operator"}, "type": "Opaque"}
+			generate := func(version string, manifests ...map[string]any) {
+				m := &common.Manifests{Manifests: manifests, Version: *semver.MustParse(version), AppVersion: version, ChartType: tc.chartType}
+				charts, err := packager.NewHelmCharts(common.Log.WithField("release", "test"), settings, "operator", common.CrdModeSeparate, m)
+				if err != nil {
+					t.Fatalf("NewHelmCharts() error = %v", err)
+				}
+				if err := client.Commit(charts, settings.SrcDir, tc.strategy); err != nil {
+					t.Fatalf("Commit() error = %v", err)
+				}
+			}
+			generate("1.0.0", configMap, secret)
+			if _, err := os.Stat(filepath.Join(repoDir, tc.removed)); err != nil {
+				t.Fatalf("NewHelmCharts() did not generate %s: %v", tc.removed, err)
+			}
+
+			//when
+			generate("1.1.0", configMap)
+
+			//then
+			head, _ := repo.Head()
+			commit, _ := repo.CommitObject(head.Hash())
+			tree, _ := commit.Tree()
+			if _, err := tree.File(tc.removed); err == nil {
+				t.Errorf("Commit() kept %s removed upstream", tc.removed)
+			}
+			status, _ := wt.Status()
+			if !status.IsClean() {
+				t.Errorf("Commit() left changes behind:\n%s", status)
+			}
+		})
+	}
+}
+
+func TestCommitLeavesSiblingCharts(t *testing.T) {
+	testCases := map[string]string{
+		"single": common.CommitStrategySingle,
+		"split":  common.CommitStrategySplit,
+	}
+	for name, strategy := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			repo, _ := initRepo(t)
+			wt, _ := repo.Worktree()
+			repoDir := wt.Filesystem.Root()
+			client := &Client{Repository: repo}
+			settings := &common.HelmSettings{SrcDir: filepath.Join(repoDir, "charts"), LintK8s: "1.30.0", DanglingValues: common.DanglingValuesIgnore}
+			sibling := "charts/operator-extra/values.yaml"
+			if err := os.MkdirAll(filepath.Join(repoDir, filepath.Dir(sibling)), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(repoDir, sibling), []byte("replicas: 1\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := wt.Add(sibling); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := wt.Commit("add sibling chart", &gogit.CommitOptions{Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}}); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(repoDir, sibling), []byte("replicas: 2\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := wt.Add(sibling); err != nil {
+				t.Fatal(err)
+			}
+			configMap := map[string]any{"apiVersion": "v1", "kind": "ConfigMap", "metadata": map[string]any{"name": "operator"}}
+			m := &common.Manifests{Manifests: []map[string]any{configMap}, Version: *semver.MustParse("1.0.0"), AppVersion: "1.0.0"}
+			charts, err := packager.NewHelmCharts(common.Log.WithField("release", "test"), settings, "operator", common.CrdModeSeparate, m)
+			if err != nil {
+				t.Fatalf("NewHelmCharts() error = %v", err)
+			}
+
+			//when
+			err = client.Commit(charts, settings.SrcDir, strategy)
+
+			//then
+			if err != nil {
+				t.Fatalf("Commit() error = %v", err)
+			}
+			head, _ := repo.Head()
+			commit, _ := repo.CommitObject(head.Hash())
+			tree, _ := commit.Tree()
+			file, err := tree.File(sibling)
+			if err != nil {
+				t.Fatalf("Commit() removed %s: %v", sibling, err)
+			}
+			if content, _ := file.Contents(); content != "replicas: 1\n" {
+				t.Errorf("Commit() committed %s of the sibling chart, content = %q", sibling, content)
+			}
+		})
+	}
+}
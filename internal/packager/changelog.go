@@ -0,0 +1,120 @@
+package packager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/krezh/charts/internal/common"
+)
+
+const (
+	changelogHeader     = "# Changelog\n"
+	changelogDateFormat = "2006-01-02"
+)
+
+// ChangelogEntry records the update of a chart to a new upstream release
+type ChangelogEntry struct {
+	Date       time.Time
+	ChartName  string
+	OldVersion string
+	NewVersion string
+	ReleaseURL string
+}
+
+// NewChangelogEntry records the update of the release's chart to its upstream release tagged newVersion
+func NewChangelogEntry(date time.Time, release *common.GithubRelease, oldVersion, newVersion string) ChangelogEntry {
+	return ChangelogEntry{
+		Date:       date,
+		ChartName:  release.ChartName,
+		OldVersion: oldVersion,
+		NewVersion: newVersion,
+		ReleaseURL: fmt.Sprintf("https://github.com/%s/%s/releases/tag/%s", release.Owner, release.Repo, newVersion),
+	}
+}
+
+func (e ChangelogEntry) line() string {
+	oldVersion := e.OldVersion
+	if oldVersion == "" {
+		oldVersion = "none"
+	}
+	return fmt.Sprintf("- %s: %s → %s ([release](%s))", e.ChartName, oldVersion, e.NewVersion, e.ReleaseURL)
+}
+
+// recordedIn tells whether lines already record the update of the chart to the entry's version
+func (e ChangelogEntry) recordedIn(lines []string) bool {
+	return slices.ContainsFunc(lines, func(line string) bool {
+		return strings.HasPrefix(line, "- "+e.ChartName+": ") && strings.Contains(line, " → "+e.NewVersion+" ")
+	})
+}
+
+// AppendChangelog adds the entries to the changelog at path under a heading per date, newest first,
+// updates already recorded for the same chart and version are skipped, so re-runs don't duplicate them
+func AppendChangelog(path string, entries []ChangelogEntry) error {
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read changelog %s: %w", path, err)
+	}
+	if len(content) == 0 {
+		content = []byte(changelogHeader)
+	}
+	lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+
+	// text before the first date heading is kept as it is, sections keep their lines in order
+	header := lines
+	sections := make(map[string][]string)
+	dates := make([]string, 0)
+	current := ""
+	for i, line := range lines {
+		if date, ok := strings.CutPrefix(line, "## "); ok {
+			if current == "" {
+				header = lines[:i]
+			}
+			current = date
+			dates = append(dates, date)
+			continue
+		}
+		if current != "" {
+			sections[current] = append(sections[current], line)
+		}
+	}
+
+	added := 0
+	for _, entry := range entries {
+		if entry.recordedIn(lines) {
+			continue
+		}
+		date := entry.Date.Format(changelogDateFormat)
+		if _, ok := sections[date]; !ok {
+			dates = append(dates, date)
+			sections[date] = []string{""}
+		} else {
+			sections[date] = trimTrailingBlank(sections[date])
+		}
+		sections[date] = append(sections[date], entry.line())
+		lines = append(lines, entry.line())
+		added++
+	}
+	if added == 0 {
+		return nil
+	}
+
+	slices.SortStableFunc(dates, func(a, b string) int { return strings.Compare(b, a) })
+	out := new(strings.Builder)
+	out.WriteString(strings.Join(trimTrailingBlank(header), "\n") + "\n")
+	for _, date := range dates {
+		fmt.Fprintf(out, "\n## %s\n", date)
+		out.WriteString(strings.Join(trimTrailingBlank(sections[date]), "\n") + "\n")
+	}
+	return os.WriteFile(path, []byte(out.String()), 0644)
+}
+
+func trimTrailingBlank(lines []string) []string {
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
@@ -0,0 +1,75 @@
+package packager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/krezh/charts/internal/common"
+)
+
+func TestAppendChangelog(t *testing.T) {
+	day1 := time.Date(2026, 10, 15, 8, 0, 0, 0, time.UTC)
+	day2 := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+	kubevirtRelease := &common.GithubRelease{Owner: "kubevirt", Repo: "kubevirt", ChartName: "kubevirt"}
+	cdiRelease := &common.GithubRelease{Owner: "kubevirt", Repo: "containerized-data-importer", ChartName: "cdi"}
+	kubevirt := NewChangelogEntry(day1, kubevirtRelease, "v1.5.1", "v1.5.2")
+	cdi := NewChangelogEntry(day2, cdiRelease, "", "v1.61.0")
+	testCases := map[string]struct {
+		existing string
+		entries  []ChangelogEntry
+		want     string
+	}{
+		"new_file": {
+			entries: []ChangelogEntry{kubevirt},
+			want: "# Changelog\n\n## 2026-10-15\n\n" +
+				"- kubevirt: v1.5.1 → v1.5.2 ([release](https://github.com/kubevirt/kubevirt/releases/tag/v1.5.2))\n",
+		},
+		"newest_date_first": {
+			existing: "# Changelog\n\nChart updates.\n\n## 2026-10-15\n\n" +
+				"- kubevirt: v1.5.1 → v1.5.2 ([release](https://github.com/kubevirt/kubevirt/releases/tag/v1.5.2))\n",
+			entries: []ChangelogEntry{cdi},
+			want: "# Changelog\n\nChart updates.\n\n## 2026-10-16\n\n" +
+				"- cdi: none → v1.61.0 ([release](https://github.com/kubevirt/containerized-data-importer/releases/tag/v1.61.0))\n\n" +
+				"## 2026-10-15\n\n" +
+				"- kubevirt: v1.5.1 → v1.5.2 ([release](https://github.com/kubevirt/kubevirt/releases/tag/v1.5.2))\n",
+		},
+		"already_recorded": {
+			existing: "# Changelog\n\n## 2026-10-14\n\n" +
+				"- kubevirt: v1.5.1 → v1.5.2 ([release](https://github.com/kubevirt/kubevirt/releases/tag/v1.5.2))\n",
+			entries: []ChangelogEntry{kubevirt},
+			want: "# Changelog\n\n## 2026-10-14\n\n" +
+				"- kubevirt: v1.5.1 → v1.5.2 ([release](https://github.com/kubevirt/kubevirt/releases/tag/v1.5.2))\n",
+		},
+		"same_date": {
+			existing: "# Changelog\n\n## 2026-10-16\n\n" +
+				"- cdi: none → v1.61.0 ([release](https://github.com/kubevirt/containerized-data-importer/releases/tag/v1.61.0))\n",
+			entries: []ChangelogEntry{NewChangelogEntry(day2, kubevirtRelease, "v1.5.2", "v1.6.0")},
+			want: "# Changelog\n\n## 2026-10-16\n\n" +
+				"- cdi: none → v1.61.0 ([release](https://github.com/kubevirt/containerized-data-importer/releases/tag/v1.61.0))\n" +
+				"- kubevirt: v1.5.2 → v1.6.0 ([release](https://github.com/kubevirt/kubevirt/releases/tag/v1.6.0))\n",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			path := filepath.Join(t.TempDir(), "CHANGELOG.md")
+			if tc.existing != "" {
+				writeTestFile(t, path, tc.existing)
+			}
+
+			//when
+			err := AppendChangelog(path, tc.entries)
+
+			//then
+			if err != nil {
+				t.Fatalf("AppendChangelog() error = %v", err)
+			}
+			content, _ := os.ReadFile(path)
+			if string(content) != tc.want {
+				t.Errorf("AppendChangelog() =\n%s\nwant\n%s", content, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package packager
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/krezh/charts/internal/common"
+	"github.com/pmezard/go-difflib/difflib"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// ChartDiff lists the files that differ between a committed chart and a regenerated one
+type ChartDiff struct {
+	Added    []string
+	Removed  []string
+	Modified []string
+	Unified  string
+}
+
+func (d *ChartDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
+
+// DiffCharts compares the chart at committedPath with the chart at generatedPath,
+// a missing committedPath is treated as an empty chart
+func DiffCharts(committedPath, generatedPath string) (*ChartDiff, error) {
+	committed, err := readChartFiles(committedPath)
+	if err != nil {
+		return nil, err
+	}
+	generated, err := readChartFiles(generatedPath)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(committed)+len(generated))
+	for name := range committed {
+		names = append(names, name)
+	}
+	for name := range generated {
+		if _, ok := committed[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	chartDiff := &ChartDiff{}
+	unified := new(strings.Builder)
+	for _, name := range names {
+		oldData, inCommitted := committed[name]
+		newData, inGenerated := generated[name]
+		switch {
+		case !inCommitted:
+			chartDiff.Added = append(chartDiff.Added, name)
+		case !inGenerated:
+			chartDiff.Removed = append(chartDiff.Removed, name)
+		case !bytes.Equal(oldData, newData):
+			chartDiff.Modified = append(chartDiff.Modified, name)
+		default:
+			continue
+		}
+
+		err := difflib.WriteUnifiedDiff(unified, difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(oldData)),
+			B:        difflib.SplitLines(string(newData)),
+			FromFile: filepath.Join(committedPath, name),
+			ToFile:   filepath.Join(generatedPath, name),
+			Context:  3,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to diff %s: %w", name, err)
+		}
+	}
+	chartDiff.Unified = unified.String()
+
+	common.Log.Debugf("Chart diff %s -> %s: added %v, removed %v, modified %v",
+		committedPath, generatedPath, chartDiff.Added, chartDiff.Removed, chartDiff.Modified)
+	return chartDiff, nil
+}
+
+// readChartFiles reads all files below path keyed by their relative path
+func readChartFiles(path string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return files, nil
+	}
+
+	err := filepath.WalkDir(path, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(path, filePath)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		files[rel] = data
+		return nil
+	})
+	if err != nil {
+		common.Log.Errorf("Failed to read chart files from %s: %v", path, err)
+		return nil, err
+	}
+	return files, nil
+}
+
+// IsVersionOnlyChange reports whether the generated chart differs from the committed one
+// only by the version and appVersion fields of Chart.yaml
+func IsVersionOnlyChange(committedPath, generatedPath string) (bool, error) {
+	chartDiff, err := DiffCharts(committedPath, generatedPath)
+	if err != nil {
+		return false, err
+	}
+	if chartDiff.Empty() {
+		return true, nil
+	}
+	if len(chartDiff.Added) > 0 || len(chartDiff.Removed) > 0 ||
+		len(chartDiff.Modified) != 1 || chartDiff.Modified[0] != chartutil.ChartfileName {
+		return false, nil
+	}
+
+	committed, err := chartutil.LoadChartfile(filepath.Join(committedPath, chartutil.ChartfileName))
+	if err != nil {
+		return false, err
+	}
+	generated, err := chartutil.LoadChartfile(filepath.Join(generatedPath, chartutil.ChartfileName))
+	if err != nil {
+		return false, err
+	}
+	committed.Version, committed.AppVersion = "", ""
+	generated.Version, generated.AppVersion = "", ""
+	return reflect.DeepEqual(committed, generated), nil
+}
@@ -0,0 +1,116 @@
+package packager
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffCharts(t *testing.T) {
+	//given
+	committed := t.TempDir()
+	generated := t.TempDir()
+	writeTestFile(t, filepath.Join(committed, "Chart.yaml"), "version: 1.0.0\n")
+	writeTestFile(t, filepath.Join(generated, "Chart.yaml"), "version: 1.1.0\n")
+	writeTestFile(t, filepath.Join(committed, "values.yaml"), "replicas: 1\n")
+	writeTestFile(t, filepath.Join(generated, "values.yaml"), "replicas: 1\n")
+	writeTestFile(t, filepath.Join(committed, "templates", "job.yaml"), "kind: Job\n")
+	writeTestFile(t, filepath.Join(generated, "templates", "deployment.yaml"), "kind: Deployment\n")
+
+	//when
+	chartDiff, err := DiffCharts(committed, generated)
+
+	//then
+	if err != nil {
+		t.Fatalf("DiffCharts() error = %v", err)
+	}
+	if want := []string{filepath.Join("templates", "deployment.yaml")}; !reflect.DeepEqual(chartDiff.Added, want) {
+		t.Errorf("DiffCharts() added = %v, want %v", chartDiff.Added, want)
+	}
+	if want := []string{filepath.Join("templates", "job.yaml")}; !reflect.DeepEqual(chartDiff.Removed, want) {
+		t.Errorf("DiffCharts() removed = %v, want %v", chartDiff.Removed, want)
+	}
+	if want := []string{"Chart.yaml"}; !reflect.DeepEqual(chartDiff.Modified, want) {
+		t.Errorf("DiffCharts() modified = %v, want %v", chartDiff.Modified, want)
+	}
+	if !strings.Contains(chartDiff.Unified, "-version: 1.0.0") || !strings.Contains(chartDiff.Unified, "+version: 1.1.0") {
+		t.Errorf("DiffCharts() unified diff missing version change:\n%s", chartDiff.Unified)
+	}
+}
+
+func TestDiffChartsIdentical(t *testing.T) {
+	//given
+	committed := t.TempDir()
+	generated := t.TempDir()
+	writeTestFile(t, filepath.Join(committed, "Chart.yaml"), "version: 1.0.0\n")
+	writeTestFile(t, filepath.Join(generated, "Chart.yaml"), "version: 1.0.0\n")
+
+	//when
+	chartDiff, err := DiffCharts(committed, generated)
+
+	//then
+	if err != nil {
+		t.Fatalf("DiffCharts() error = %v", err)
+	}
+	if !chartDiff.Empty() || chartDiff.Unified != "" {
+		t.Errorf("DiffCharts() = %+v, want empty diff", chartDiff)
+	}
+}
+
+func TestIsVersionOnlyChange(t *testing.T) {
+	testCases := map[string]struct {
+		committedChart  string
+		generatedChart  string
+		generatedValues string
+		want            bool
+	}{
+		"identical": {
+			committedChart: "apiVersion: v2\nname: test\nversion: 1.0.0\nappVersion: v1.0.0\n",
+			generatedChart: "apiVersion: v2\nname: test\nversion: 1.0.0\nappVersion: v1.0.0\n",
+			want:           true,
+		},
+		"version_bump": {
+			committedChart: "apiVersion: v2\nname: test\nversion: 1.0.0\nappVersion: v1.0.0\n",
+			generatedChart: "apiVersion: v2\nname: test\nversion: 1.1.0\nappVersion: v1.1.0\n",
+			want:           true,
+		},
+		"metadata_change": {
+			committedChart: "apiVersion: v2\nname: test\nversion: 1.0.0\nappVersion: v1.0.0\n",
+			generatedChart: "apiVersion: v2\nname: test\nversion: 1.1.0\nappVersion: v1.1.0\ndescription: changed\n",
+			want:           false,
+		},
+		"values_change": {
+			committedChart:  "apiVersion: v2\nname: test\nversion: 1.0.0\nappVersion: v1.0.0\n",
+			generatedChart:  "apiVersion: v2\nname: test\nversion: 1.1.0\nappVersion: v1.1.0\n",
+			generatedValues: "replicas: 2\n",
+			want:            false,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			committed := t.TempDir()
+			generated := t.TempDir()
+			writeTestFile(t, filepath.Join(committed, "Chart.yaml"), tc.committedChart)
+			writeTestFile(t, filepath.Join(generated, "Chart.yaml"), tc.generatedChart)
+			writeTestFile(t, filepath.Join(committed, "values.yaml"), "replicas: 1\n")
+			values := tc.generatedValues
+			if values == "" {
+				values = "replicas: 1\n"
+			}
+			writeTestFile(t, filepath.Join(generated, "values.yaml"), values)
+
+			//when
+			got, err := IsVersionOnlyChange(committed, generated)
+
+			//then
+			if err != nil {
+				t.Fatalf("IsVersionOnlyChange() error = %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("IsVersionOnlyChange() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -1,21 +1,58 @@
 package packager
 
 import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/Masterminds/semver/v3"
 	"github.com/krezh/charts/internal/common"
+	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chart/loader"
 	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
 	"helm.sh/helm/v3/pkg/lint"
+	"helm.sh/helm/v3/pkg/lint/support"
 	"helm.sh/helm/v3/pkg/registry"
+	"oras.land/oras-go/v2/registry/remote/retry"
+)
+
+const (
+	VersionFileName    = "VERSION"
+	AppVersionFileName = "APP_VERSION" // appVersion of plain manifest output, which has no Chart.yaml
+	CrdsDir            = "crds"
+
+	defaultLintNamespace   = "lint-namespace"
+	defaultLintReleaseName = "test-release" // hardcoded by the Helm linter
+)
+
+var (
+	// ErrVersionExists is returned by Push when the chart version is already in the registry and overwriting is not allowed
+	ErrVersionExists = errors.New("chart version already exists")
+	// ErrChartEmpty is returned by NewHelmChart for charts without templates when helm.failOnEmptyChart is set
+	ErrChartEmpty = errors.New("chart has no templates")
+	// ErrPushNotVerified is returned by Push when helm.verifyAfterPush is set and the registry doesn't serve the pushed chart
+	ErrPushNotVerified = errors.New("pushed chart not verified")
 )
 
 // HelmizedManifests holds the Helm chart and its path created from Kubernetes manifests.
@@ -23,21 +60,184 @@ type HelmizedManifests struct {
 	Path     string
 	Chart    *chart.Chart
 	CrdChart *chart.Chart
+	Umbrella *chart.Chart // parent chart when Chart and CrdChart are its subcharts
+	// LintFindings of Chart and CrdChart, for reporting
+	LintFindings []LintFinding
+	// Images referenced by Chart, for scanning and mirroring
+	Images []string
+	// Unchanged charts differ from the committed ones only by version fields, they are neither installed nor committed
+	Unchanged []string
+	// Replace installs the directories under Path as they are, dropping files of the installed ones, e.g. plain manifests
+	Replace bool
+	// Files of the repository committed along with the charts, e.g. the changelog
+	Files []string
+}
+
+// LintReport renders the lint findings as a markdown list for pull request bodies, empty without findings
+func (packaged *HelmizedManifests) LintReport() string {
+	if len(packaged.LintFindings) == 0 {
+		return ""
+	}
+	report := new(strings.Builder)
+	report.WriteString("### Lint findings\n")
+	for _, finding := range packaged.LintFindings {
+		fmt.Fprintf(report, "- %s\n", finding)
+	}
+	return report.String()
 }
 
 func (packaged *HelmizedManifests) AppVersion() string {
 	return packaged.Chart.Metadata.AppVersion
 }
 
-func createTemplates(ch *chart.Chart, newManifests *[]map[string]any) error {
-	common.Log.Debugf("Updating: %d Helm Chart manifests in: %s", len(*newManifests), ch.Metadata.Name)
+// ChartNames returns the name of the main chart followed by the CRD chart, if any, unchanged charts are left out
+func (packaged *HelmizedManifests) ChartNames() []string {
+	names := make([]string, 0, 2)
+	for _, ch := range []*chart.Chart{packaged.Chart, packaged.CrdChart} {
+		if ch != nil && !slices.Contains(packaged.Unchanged, ch.Metadata.Name) {
+			names = append(names, ch.Metadata.Name)
+		}
+	}
+	return names
+}
+
+// ChartDirs returns the directories of the charts relative to Path,
+// subcharts of an umbrella chart live in its charts/ directory
+func (packaged *HelmizedManifests) ChartDirs() []string {
+	dirs := packaged.ChartNames()
+	for i, name := range dirs {
+		dirs[i] = packaged.chartDir(name)
+	}
+	return dirs
+}
+
+// CrdChartDir returns the directory of the CRD chart relative to Path, empty without a changed CRD chart
+func (packaged *HelmizedManifests) CrdChartDir() string {
+	if packaged.CrdChart == nil || slices.Contains(packaged.Unchanged, packaged.CrdChart.Metadata.Name) {
+		return ""
+	}
+	return packaged.chartDir(packaged.CrdChart.Metadata.Name)
+}
+
+func (packaged *HelmizedManifests) chartDir(name string) string {
+	if packaged.Umbrella != nil {
+		return filepath.Join(packaged.Umbrella.Metadata.Name, chartutil.ChartsDir, name)
+	}
+	return name
+}
+
+// UmbrellaFiles returns the Chart.yaml and, when it has values, the values.yaml of the umbrella chart
+// relative to Path, empty for standalone charts
+func (packaged *HelmizedManifests) UmbrellaFiles() []string {
+	if packaged.Umbrella == nil {
+		return nil
+	}
+	files := []string{filepath.Join(packaged.Umbrella.Metadata.Name, chartutil.ChartfileName)}
+	if len(packaged.Umbrella.Values) > 0 {
+		files = append(files, filepath.Join(packaged.Umbrella.Metadata.Name, chartutil.ValuesfileName))
+	}
+	return files
+}
+
+// MoveTo installs the charts into destDir the same way in-place generation would:
+// generated templates replace existing ones, other files are overwritten, extra files are kept.
+// Each chart is assembled next to its destination and swapped in by renaming, an interrupted
+// install leaves either the committed or the generated chart, never a mix of both
+func (packaged *HelmizedManifests) MoveTo(destDir string) error {
+	if packaged.Path == destDir {
+		return nil
+	}
+	for _, name := range packaged.ChartDirs() {
+		src := filepath.Join(packaged.Path, name)
+		dst := filepath.Join(destDir, name)
+		if err := installDir(src, dst, packaged.Replace); err != nil {
+			common.Log.Errorf("Failed to move chart %s to %s: %v", src, dst, err)
+			return err
+		}
+		common.Log.Infof("Moved chart %s to %s", name, dst)
+	}
+	for _, file := range packaged.UmbrellaFiles() {
+		if err := copyFile(filepath.Join(packaged.Path, file), filepath.Join(destDir, file)); err != nil {
+			common.Log.Errorf("Failed to move umbrella chart %s to %s: %v", file, destDir, err)
+			return err
+		}
+	}
+	packaged.Path = destDir
+	return nil
+}
+
+// installDir stages the installed directory next to dst, starting from the committed one unless replace is set,
+// stale generated files are cleared like save does, then swaps the staged directory in
+func installDir(src, dst string, replace bool) error {
+	parent := filepath.Dir(dst)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return err
+	}
+	staging, err := os.MkdirTemp(parent, "."+filepath.Base(dst)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+	if err := os.Chmod(staging, 0755); err != nil {
+		return err
+	}
+
+	exists := fileExists(dst)
+	if exists && !replace {
+		if err := copyDir(dst, staging); err != nil {
+			return err
+		}
+		if err := clearTemplates(staging); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(filepath.Join(staging, CrdsDir)); err != nil {
+			return err
+		}
+		if err := os.Remove(filepath.Join(staging, chartutil.SchemafileName)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := copyDir(src, staging); err != nil {
+		return err
+	}
+
+	if !exists {
+		return os.Rename(staging, dst)
+	}
+	previous := staging + ".old"
+	if err := os.Rename(dst, previous); err != nil {
+		return err
+	}
+	if err := os.Rename(staging, dst); err != nil {
+		if restoreErr := os.Rename(previous, dst); restoreErr != nil {
+			return fmt.Errorf("%w, restoring %s failed: %v", err, dst, restoreErr)
+		}
+		return err
+	}
+	return os.RemoveAll(previous)
+}
+
+// createTemplates replaces the chart's templates, files are laid out according to layout,
+// manifests without kind fail or are dropped according to missingKind
+func createTemplates(log *logrus.Entry, ch *chart.Chart, newManifests *[]map[string]any, layout, missingKind string, templateDirs map[string]string) error {
+	log.Debugf("Updating: %d Helm Chart manifests in: %s", len(*newManifests), ch.Metadata.Name)
+	switch missingKind {
+	case "", common.MissingKindFail, common.MissingKindSkip, common.MissingKindWarn:
+	default:
+		return fmt.Errorf("unknown missingKind '%s'", missingKind)
+	}
+	dirs, err := kindDirs(templateDirs)
+	if err != nil {
+		return err
+	}
 	templates := make(map[string]*chart.File, len(*newManifests))
+	names := make([]string, 0, len(*newManifests))
 	re := regexp.MustCompile(`'(\{\{.*?\}\})'|"(\{\{.*?\}\})"`)
 
 	for i, manifest := range *newManifests {
-		manifestYAML, err := yaml.Marshal(manifest)
+		manifestYAML, err := marshalManifest(manifest)
 		if err != nil {
-			common.Log.Errorf("Failed to marshal manifest %d: %v", i, err)
+			log.Errorf("Failed to marshal manifest %d: %v", i, err)
 			return err
 		}
 		manifestYAML = re.ReplaceAllFunc(manifestYAML, func(match []byte) []byte {
@@ -46,114 +246,368 @@ func createTemplates(ch *chart.Chart, newManifests *[]map[string]any) error {
 		})
 		kind, ok := manifest["kind"].(string)
 		if !ok {
-			common.Log.Errorf("Broken manifest: %s", string(manifestYAML))
-			return fmt.Errorf("manifest %d does not have a valid 'kind' field", i)
+			if err := dropWithoutKind(log, missingKind, ch.Metadata.Name, i, manifestYAML); err != nil {
+				return err
+			}
+			continue
+		}
+		name, err := templateFileName(layout, kind, manifest, i)
+		if err != nil {
+			return err
+		}
+		if dir, ok := dirs[strings.ToLower(kind)]; ok {
+			name = path.Join(dir, name)
 		}
 
-		if existingTemplate, exists := templates[kind]; exists {
+		if existingTemplate, exists := templates[name]; exists {
 			newData := append(existingTemplate.Data, []byte("\n---\n")...)
 			newData = append(newData, manifestYAML...)
 			existingTemplate.Data = newData
 		} else {
-			templates[kind] = &chart.File{
-				Name: fmt.Sprintf("templates/%s.yaml", strings.ToLower(kind)),
+			templates[name] = &chart.File{
+				Name: fmt.Sprintf("templates/%s.yaml", name),
 				Data: manifestYAML,
 			}
+			names = append(names, name)
 		}
 	}
 
 	ch.Templates = make([]*chart.File, 0, len(templates))
-	for _, tmpl := range templates {
-		ch.Templates = append(ch.Templates, tmpl)
+	for _, name := range names {
+		if ch.Metadata.Type == common.ChartTypeLibrary {
+			libraryTemplate(ch.Metadata.Name, name, templates[name])
+		}
+		ch.Templates = append(ch.Templates, templates[name])
 	}
 
 	return nil
 }
 
-func updateChartManifest(ch *chart.Chart, version *semver.Version, appVersion string) error {
+// dropWithoutKind applies the missingKind policy to manifest i of chartName lacking a kind,
+// nil when the manifest is dropped
+func dropWithoutKind(log *logrus.Entry, missingKind, chartName string, i int, manifestYAML []byte) error {
+	switch missingKind {
+	case common.MissingKindSkip:
+		log.Warnf("Skipping manifest %d of chart %s without a valid 'kind' field", i, chartName)
+		return nil
+	case common.MissingKindWarn:
+		log.Warnf("Dropping manifest %d of chart %s without a valid 'kind' field: %s", i, chartName, string(manifestYAML))
+		return nil
+	}
+	log.Errorf("Broken manifest: %s", string(manifestYAML))
+	return fmt.Errorf("manifest %d does not have a valid 'kind' field", i)
+}
+
+// CrdsDigestAnnotation records the content digest of a CRD chart with its own version lineage
+const CrdsDigestAnnotation = "krezh-charts/crds-digest"
+
+// generatedPartialHeader marks partials generated from manifests, unlike hand-written ones like _helpers.tpl
+// they are cleared with the other templates so resources removed upstream don't survive
+const generatedPartialHeader = "{{- /* generated from the upstream release, do not edit */ -}}\n"
+
+// addExtraTemplates adds the local files verbatim to templates/ of the chart, they may use Helm template syntax,
+// a file named like a generated template is an error rather than silently replacing it
+func addExtraTemplates(log *logrus.Entry, ch *chart.Chart, paths []string) error {
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read extra template: %w", err)
+		}
+		name := "templates/" + filepath.Base(path)
+		if slices.ContainsFunc(ch.Templates, func(template *chart.File) bool { return template.Name == name }) {
+			return fmt.Errorf("extra template %s collides with generated template %s of chart %s", path, name, ch.Metadata.Name)
+		}
+		ch.Templates = append(ch.Templates, &chart.File{Name: name, Data: data})
+		log.Debugf("Added extra template %s to chart %s", path, ch.Metadata.Name)
+	}
+	return nil
+}
+
+// libraryTemplate turns a template file into the named template <chart>.<name> in a partial, as library charts
+// only render partials, consuming charts include it, a partial in a subdirectory is named by its path with dots,
+// e.g. <chart>.rbac.clusterrole, so equally named files of different directories don't clash
+func libraryTemplate(chartName, name string, template *chart.File) {
+	dir, file := path.Split(name)
+	template.Name = fmt.Sprintf("templates/%s_%s.tpl", dir, file)
+	data := []byte(generatedPartialHeader)
+	data = fmt.Appendf(data, "{{- define \"%s.%s\" }}\n", chartName, strings.ReplaceAll(name, "/", "."))
+	data = append(data, template.Data...)
+	template.Data = append(data, []byte("{{- end }}\n")...)
+}
+
+// leadingManifestKeys are emitted first, in this order, remaining top-level keys follow alphabetically
+var leadingManifestKeys = []string{"apiVersion", "kind", "metadata", "spec"}
+
+// marshalManifest renders a manifest with its top-level keys in conventional Kubernetes order
+func marshalManifest(manifest map[string]any) ([]byte, error) {
+	keys := make([]string, 0, len(manifest))
+	for key := range manifest {
+		if !slices.Contains(leadingManifestKeys, key) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	for i := len(leadingManifestKeys) - 1; i >= 0; i-- {
+		if _, ok := manifest[leadingManifestKeys[i]]; ok {
+			keys = slices.Insert(keys, 0, leadingManifestKeys[i])
+		}
+	}
+
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	for _, key := range keys {
+		keyNode, valueNode := &yaml.Node{}, &yaml.Node{}
+		if err := keyNode.Encode(key); err != nil {
+			return nil, err
+		}
+		if err := valueNode.Encode(manifest[key]); err != nil {
+			return nil, err
+		}
+		root.Content = append(root.Content, keyNode, valueNode)
+	}
+	return yaml.Marshal(root)
+}
+
+var unsafeFileNameChars = regexp.MustCompile(`[^a-z0-9.]+`)
+
+// kindDirs returns the templates/ subdirectory of each kind by lowercase kind, rejecting directories outside templates/
+// and kinds configured twice in different case
+func kindDirs(templateDirs map[string]string) (map[string]string, error) {
+	dirs := make(map[string]string, len(templateDirs))
+	for kind, dir := range templateDirs {
+		dir = path.Clean(dir)
+		if !filepath.IsLocal(dir) || dir == "." {
+			return nil, fmt.Errorf("templateDirs of kind %s must be a subdirectory of templates/, not '%s'", kind, templateDirs[kind])
+		}
+		if _, exists := dirs[strings.ToLower(kind)]; exists {
+			return nil, fmt.Errorf("templateDirs configures kind %s more than once", strings.ToLower(kind))
+		}
+		dirs[strings.ToLower(kind)] = dir
+	}
+	return dirs, nil
+}
+
+// templateFileName names the template file of a manifest without extension, manifests sharing a name share the file
+func templateFileName(layout, kind string, manifest map[string]any, index int) (string, error) {
+	switch layout {
+	case "", common.TemplateLayoutPerKind:
+		return strings.ToLower(kind), nil
+	case common.TemplateLayoutSingle:
+		return "manifests", nil
+	case common.TemplateLayoutPerResource:
+		metadata, _ := manifest["metadata"].(map[string]any)
+		name, _ := metadata["name"].(string)
+		name = strings.Trim(unsafeFileNameChars.ReplaceAllString(strings.ToLower(name), "-"), "-.")
+		if name == "" {
+			name = strconv.Itoa(index)
+		}
+		return strings.ToLower(kind) + "-" + name, nil
+	default:
+		return "", fmt.Errorf("unknown templateLayout '%s'", layout)
+	}
+}
+
+// createCrdFiles replaces the chart's crds/ files, one per CRD named by metadata.name
+func createCrdFiles(log *logrus.Entry, ch *chart.Chart, crds []map[string]any) error {
+	files := make([]*chart.File, 0, len(ch.Files)+len(crds))
+	for _, f := range ch.Files {
+		if !strings.HasPrefix(f.Name, CrdsDir+"/") {
+			files = append(files, f)
+		}
+	}
+	for i, crd := range crds {
+		metadata, _ := crd["metadata"].(map[string]any)
+		name, ok := metadata["name"].(string)
+		if !ok {
+			return fmt.Errorf("CRD %d does not have a valid 'metadata.name' field", i)
+		}
+		data, err := marshalManifest(crd)
+		if err != nil {
+			log.Errorf("Failed to marshal CRD %s: %v", name, err)
+			return err
+		}
+		files = append(files, &chart.File{Name: fmt.Sprintf("%s/%s.yaml", CrdsDir, name), Data: data})
+	}
+	ch.Files = files
+	return nil
+}
+
+func updateChartManifest(ch *chart.Chart, version *semver.Version, appVersion, chartType string) error {
+	switch chartType {
+	case "":
+		chartType = common.ChartTypeApplication
+	case common.ChartTypeApplication, common.ChartTypeLibrary:
+	default:
+		return fmt.Errorf("unknown chartType '%s' for chart %s", chartType, ch.Metadata.Name)
+	}
+	ch.Metadata.Type = chartType
 	ch.Metadata.AppVersion = appVersion
 	ch.Metadata.Version = version.String()
 	ch.Metadata.Description = fmt.Sprintf("A Helm Chart for %s", ch.Metadata.Name)
 	return nil
 }
 
-func save(chartFullPath string, ch *chart.Chart, extraValues *map[string]any) error {
+func save(log *logrus.Entry, chartFullPath string, ch *chart.Chart, extraValues *map[string]any) error {
 	err := clearTemplates(chartFullPath)
 	if err != nil {
-		common.Log.Errorf("Failed to clear templates directory: %v", err)
+		log.Errorf("Failed to clear templates directory: %v", err)
+		return err
+	}
+
+	// values.schema.json is only kept while the chart still has a schema
+	if ch.Schema == nil {
+		err = os.Remove(filepath.Join(chartFullPath, chartutil.SchemafileName))
+		if err != nil && !os.IsNotExist(err) {
+			log.Errorf("Failed to remove stale values schema: %v", err)
+			return err
+		}
+	}
+
+	// crds/ is fully generated, stale CRDs must not survive
+	err = os.RemoveAll(filepath.Join(chartFullPath, CrdsDir))
+	if err != nil {
+		log.Errorf("Failed to clear crds directory: %v", err)
 		return err
 	}
 
 	dir := filepath.Dir(chartFullPath)
-	common.Log.Infof("Saving Helm chart to: %s", dir)
+	log.Infof("Saving Helm chart to: %s", dir)
 	err = chartutil.SaveDir(ch, dir)
 	if err != nil {
-		common.Log.Errorf("Failed to save Helm chart to %s: %v", dir, err)
+		log.Errorf("Failed to save Helm chart to %s: %v", dir, err)
 		return err
 	}
 
-	//clear generated values
-	ch.Values = map[string]any{}
 	err = os.Remove(fmt.Sprintf("%s/%s", chartFullPath, chartutil.ValuesfileName))
 	if err != nil {
 		return err
 	}
 
 	// saving values separately as SaveDir doesn't respect the current ch.Values
-	mergedValues, err := chartutil.CoalesceValues(ch, *extraValues)
+	valuesData, err := valuesFileData(ch, *extraValues)
 	if err != nil {
-		common.Log.Errorf("Failed to merge values: %v", err)
+		log.Errorf("Failed to write values of %s: %v", chartFullPath, err)
 		return err
 	}
-	ch.Values = mergedValues
 	valuesPath := fmt.Sprintf("%s/%s", chartFullPath, chartutil.ValuesfileName)
-	var valuesData []byte
-
-	if len(ch.Values) > 0 {
-		valuesData, err = yaml.Marshal(ch.Values)
-		if err != nil {
-			common.Log.Errorf("failed to marshal values: %v", err)
-			return err
-		}
-	}
 
 	if err := os.WriteFile(valuesPath, valuesData, 0644); err != nil {
-		common.Log.Errorf("failed to write values.yaml: %v", err)
+		log.Errorf("failed to write values.yaml: %v", err)
 		return err
 	}
 
 	return nil
 }
 
-func Lint(chartFullPath string, ch *chart.Chart, settings *common.HelmSettings) error {
+// valuesFileData replaces the values of ch with extraValues merged as values.yaml holds them and returns the file's
+// content, none for empty values
+func valuesFileData(ch *chart.Chart, extraValues map[string]any) ([]byte, error) {
+	//clear generated values
+	ch.Values = map[string]any{}
+	mergedValues, err := chartutil.CoalesceValues(ch, extraValues)
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge values: %w", err)
+	}
+	ch.Values = mergedValues
+	if len(ch.Values) == 0 {
+		return nil, nil
+	}
+	valuesData, err := yaml.Marshal(ch.Values)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal values: %w", err)
+	}
+	return valuesData, nil
+}
+
+// LintFinding is a single message of the Helm linter, Severity is one of the lint support *Sev constants
+type LintFinding struct {
+	Path     string
+	Severity int
+	Message  string
+}
+
+func (f LintFinding) String() string {
+	name := "UNKNOWN"
+	switch f.Severity {
+	case support.InfoSev:
+		name = "INFO"
+	case support.WarningSev:
+		name = "WARNING"
+	case support.ErrorSev:
+		name = "ERROR"
+	}
+	return fmt.Sprintf("[%s] %s: %s", name, f.Path, f.Message)
+}
+
+// Lint lints the chart and returns every finding, the error is set when any finding is a warning or worse
+func Lint(log *logrus.Entry, chartFullPath string, ch *chart.Chart, settings *common.HelmSettings) ([]LintFinding, error) {
 	k8sVersionString := settings.LintK8s
-	lintNamespace := "lint-namespace"
+	lintNamespace := defaultLintNamespace
+	if settings.LintNamespace != "" {
+		lintNamespace = settings.LintNamespace
+	}
 	lintK8sVersion, err := chartutil.ParseKubeVersion(k8sVersionString)
 	if err != nil {
-		common.Log.Warnf("Invalid Kubernetes version for linting: %s, defaulting to 1.30.0", k8sVersionString)
+		log.Warnf("Invalid Kubernetes version for linting: %s, defaulting to 1.30.0", k8sVersionString)
 		k8sVersionString = "1.30.0"
 		lintK8sVersion, _ = chartutil.ParseKubeVersion(k8sVersionString)
 	}
-	common.Log.Infof("Linting Helm chart in: %s against Kubernetes version: %s", chartFullPath, k8sVersionString)
+	log.Infof("Linting Helm chart in: %s against Kubernetes version: %s", chartFullPath, k8sVersionString)
 	linter := lint.AllWithKubeVersion(chartFullPath, ch.Values, lintNamespace, lintK8sVersion)
 
-	if len(linter.Messages) > 0 {
-		for _, lintMsg := range linter.Messages {
-			if lintMsg.Severity > 1 {
-				common.Log.Warnf("%s", lintMsg)
-			} else {
-				common.Log.Infof("%s", lintMsg)
-			}
+	// the Helm linter always renders as defaultLintReleaseName and tolerates fail in lint mode,
+	// a configured release is rendered strictly
+	// library charts render no resources of their own
+	if (settings.LintReleaseName != "" || settings.LintNamespace != "") && ch.Metadata.Type != common.ChartTypeLibrary {
+		releaseName := defaultLintReleaseName
+		if settings.LintReleaseName != "" {
+			releaseName = settings.LintReleaseName
 		}
+		err := renderAsRelease(chartFullPath, ch.Values, releaseName, lintNamespace, lintK8sVersion)
+		linter.RunLinterRule(support.ErrorSev, "templates/", err)
 	}
-	if linter.HighestSeverity >= 2 {
-		return fmt.Errorf("chart %s has linting errors", chartFullPath)
+
+	findings := make([]LintFinding, 0, len(linter.Messages))
+	for _, lintMsg := range linter.Messages {
+		if lintMsg.Severity >= support.WarningSev {
+			log.Warnf("%s", lintMsg)
+		} else {
+			log.Infof("%s", lintMsg)
+		}
+		findings = append(findings, LintFinding{Path: lintMsg.Path, Severity: lintMsg.Severity, Message: lintMsg.Err.Error()})
+	}
+	if linter.HighestSeverity >= support.WarningSev {
+		return findings, fmt.Errorf("chart %s has linting errors", chartFullPath)
 	}
 
+	return findings, nil
+}
+
+// renderAsRelease renders the chart's templates as the named release in namespace
+func renderAsRelease(chartFullPath string, values map[string]any, releaseName, namespace string, kubeVersion *chartutil.KubeVersion) error {
+	ch, err := loader.Load(chartFullPath)
+	if err != nil {
+		return err
+	}
+	vals, err := chartutil.CoalesceValues(ch, values)
+	if err != nil {
+		return err
+	}
+	caps := chartutil.DefaultCapabilities.Copy()
+	caps.KubeVersion = *kubeVersion
+	renderValues, err := chartutil.ToRenderValues(ch, vals, chartutil.ReleaseOptions{Name: releaseName, Namespace: namespace}, caps)
+	if err != nil {
+		return err
+	}
+	if _, err := engine.Render(ch, renderValues); err != nil {
+		return fmt.Errorf("rendering as release %s in namespace %s failed: %w", releaseName, namespace, err)
+	}
 	return nil
 }
 
-func Package(chartPath string, settings *common.HelmSettings) (string, error) {
+func Package(ctx context.Context, chartPath string, settings *common.HelmSettings) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", fmt.Errorf("packaging chart %s cancelled: %w", chartPath, err)
+	}
 	if err := os.MkdirAll(settings.TargetDir, 0755); err != nil {
 		common.Log.Errorf("failed to create target directory: %v", err)
 		return "", err
@@ -173,53 +627,90 @@ func Package(chartPath string, settings *common.HelmSettings) (string, error) {
 	return packagePath, nil
 }
 
-func Push(packagedPath, remote string) (string, error) {
+// WriteArchive bundles packaged charts into a single tar stream written to w
+func WriteArchive(w io.Writer, packagedPaths []string) error {
+	tw := tar.NewWriter(w)
+	for _, packagedPath := range packagedPaths {
+		fi, err := os.Stat(packagedPath)
+		if err != nil {
+			common.Log.Errorf("failed to stat packaged chart %s: %v", packagedPath, err)
+			return err
+		}
+		header, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write archive header for %s: %w", packagedPath, err)
+		}
+		f, err := os.Open(packagedPath)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		_ = f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to write %s to archive: %w", packagedPath, err)
+		}
+		common.Log.Infof("Added packaged chart %s to output archive", fi.Name())
+	}
+
+	return tw.Close()
+}
+
+// Push pushes the packaged chart to the remote OCI registry and returns a record of what was published,
+// an existing version is not pushed again, the record is then returned along with ErrVersionExists,
+// with verifyAfterPush the chart is pulled back before extra tags are pushed
+func Push(ctx context.Context, packagedPath, remote string, settings *common.HelmSettings) (*PublishRecord, error) {
 	if !strings.HasPrefix(remote, "oci://") {
-		return "", fmt.Errorf("remote must start with oci://, got: %s", remote)
+		return nil, fmt.Errorf("remote must start with oci://, got: %s", remote)
 	}
 	if fi, err := os.Stat(packagedPath); err != nil || fi.IsDir() {
-		return "", fmt.Errorf("invalid packaged chart path: %s", packagedPath)
+		return nil, fmt.Errorf("invalid packaged chart path: %s", packagedPath)
 	}
 
 	chartData, err := os.ReadFile(packagedPath)
 	if err != nil {
 		common.Log.Errorf("failed to read packaged chart %s: %v", packagedPath, err)
-		return "", err
+		return nil, err
 	}
 	ch, err := loader.LoadFile(packagedPath)
 	if err != nil {
 		common.Log.Errorf("failed to load packaged chart %s: %v", packagedPath, err)
-		return "", err
+		return nil, err
 	}
 
-	rc, err := registry.NewClient(
-		registry.ClientOptEnableCache(true),
-	)
+	if annotations := ociAnnotations(ch.Metadata, &settings.OciAnnotations); len(annotations) > 0 {
+		chartData, err = annotateChart(ch, annotations, packagedPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rc, err := newRegistryClient(ctx, &settings.RemoteAuth)
 	if err != nil {
-		common.Log.Errorf("failed to create registry client: %v", err)
-		return "", err
+		return nil, err
 	}
 
-	trimmed := strings.TrimSuffix(remote, "/")
-	parts := strings.Split(trimmed, "/")
-	last := parts[len(parts)-1]
 	chartName := ch.Metadata.Name
-
-	var ref string // oci://registry/repository:version
-	if last == chartName {
-		ref = fmt.Sprintf("%s:%s", trimmed, ch.Metadata.Version)
-	} else {
-		ref = fmt.Sprintf("%s/%s:%s", trimmed, chartName, ch.Metadata.Version)
-	}
+	ref := buildOCIRef(remote, chartName, ch.Metadata.Version)
 
 	exists, err := versionExistsInRegistry(rc, ref, ch.Metadata.Version)
 	if err != nil {
 		common.Log.Errorf("failed to check if version exists in registry: %v", err)
-		return "", err
+		return nil, err
+	}
+	record := &PublishRecord{
+		Chart:     chartName,
+		Version:   ch.Metadata.Version,
+		Ref:       ref,
+		Timestamp: time.Now().UTC(),
+	}
+	if exists && !settings.AllowOverwrite {
+		return record, fmt.Errorf("%w: %s", ErrVersionExists, ref)
 	}
 	if exists {
-		common.Log.Infof("version %s of chart %s already exists in the registry %s, skipping", ch.Metadata.Version, chartName, ref)
-		return ref, nil
+		common.Log.Warnf("!!! OVERWRITING existing version %s of chart %s in the registry %s !!!", ch.Metadata.Version, chartName, ref)
 	}
 
 	common.Log.Infof("Pushing chart %s version %s to %s", chartName, ch.Metadata.Version, ref)
@@ -227,82 +718,418 @@ func Push(packagedPath, remote string) (string, error) {
 	result, err := rc.Push(chartData, ref)
 	if err != nil {
 		common.Log.Errorf("failed to push chart: %v", err)
-		return "", err
+		return nil, err
+	}
+	if settings.VerifyAfterPush {
+		if err := verifyPushed(rc, ref, ch.Metadata.Version, result.Chart.Digest); err != nil {
+			common.Log.Errorf("Failed to verify pushed chart %s: %v", ref, err)
+			return nil, err
+		}
+		common.Log.Infof("Verified pushed chart %s (chart digest: %s)", ref, result.Chart.Digest)
 	}
 
+	aliases, err := extraTagAliases(ch.Metadata.Version, settings.ExtraTags)
+	if err != nil {
+		return nil, err
+	}
+	repository := strings.TrimSuffix(ref, ":"+ch.Metadata.Version)
+	for _, alias := range aliases {
+		aliasRef := fmt.Sprintf("%s:%s", repository, alias)
+		common.Log.Infof("Pushing chart %s version %s as alias %s", chartName, ch.Metadata.Version, aliasRef)
+		// strict mode requires the tag to equal the chart version
+		if _, err := rc.Push(chartData, aliasRef, registry.PushOptStrictMode(false)); err != nil {
+			common.Log.Errorf("failed to push chart alias %s: %v", aliasRef, err)
+			return nil, err
+		}
+	}
+
+	record.Digest = result.Manifest.Digest
 	if fmt.Sprintf("oci://%s", result.Ref) != ref {
 		common.Log.Warnf("Pushed chart reference %s does not match expected %s", result.Ref, ref)
-		return result.Ref, nil
+		record.Ref = result.Ref
 	} else {
-		common.Log.Infof("Successfully pushed chart to %s", ref)
+		common.Log.Infof("Successfully pushed chart to %s (digest: %s)", ref, record.Digest)
+	}
+
+	if settings.WriteProvenance {
+		if err := writePublishRecord(settings.TargetDir, record); err != nil {
+			return nil, err
+		}
+	}
+
+	return record, nil
+}
+
+// verifyPushed pulls ref back and checks the registry serves the pushed chart, both the digest of the chart layer
+// and the version of the pulled chart must match, guarding against registries accepting a push but serving stale content
+func verifyPushed(rc *registry.Client, ref, version, chartDigest string) error {
+	pulled, err := rc.Pull(ref)
+	if err != nil {
+		return fmt.Errorf("%w: failed to pull %s: %w", ErrPushNotVerified, ref, err)
+	}
+	if pulled.Chart.Digest != chartDigest {
+		return fmt.Errorf("%w: %s serves chart digest %s, pushed %s", ErrPushNotVerified, ref, pulled.Chart.Digest, chartDigest)
+	}
+	ch, err := loader.LoadArchive(bytes.NewReader(pulled.Chart.Data))
+	if err != nil {
+		return fmt.Errorf("%w: pulled chart %s is invalid: %w", ErrPushNotVerified, ref, err)
+	}
+	if ch.Metadata.Version != version {
+		return fmt.Errorf("%w: %s serves chart version %s, pushed %s", ErrPushNotVerified, ref, ch.Metadata.Version, version)
+	}
+	return nil
+}
+
+// buildOCIRef builds oci://registry/repository/chartName:version,
+// chartName is appended unless remote already ends with it as the final path segment
+func buildOCIRef(remote, chartName, version string) string {
+	return fmt.Sprintf("%s:%s", chartRepositoryRef(remote, chartName), version)
+}
+
+// chartRepositoryRef builds oci://registry/repository/chartName, the repository holding every version of the chart
+func chartRepositoryRef(remote, chartName string) string {
+	trimmed := strings.TrimRight(remote, "/")
+	parts := strings.Split(strings.TrimPrefix(trimmed, "oci://"), "/")
+	// the first segment is the registry host, only repository segments may name the chart
+	if len(parts) > 1 && parts[len(parts)-1] == chartName {
+		return trimmed
+	}
+	return fmt.Sprintf("%s/%s", trimmed, chartName)
+}
+
+// ociAnnotations returns the configured OCI annotations for a chart, Helm copies chart annotations into
+// the pushed manifest
+func ociAnnotations(metadata *chart.Metadata, settings *common.OciAnnotations) map[string]string {
+	if settings.Disabled {
+		return nil
+	}
+	annotations := make(map[string]string)
+	if settings.Source != "" {
+		annotations[common.OciAnnotationSource] = settings.Source
+	}
+	annotations[common.OciAnnotationRevision] = settings.Revision
+	if settings.Revision == "" {
+		annotations[common.OciAnnotationRevision] = metadata.Version
+	}
+	return annotations
+}
+
+// annotateChart adds annotations to the chart and re-archives it in place, so the packaged chart matches
+// the pushed one
+func annotateChart(ch *chart.Chart, annotations map[string]string, packagedPath string) ([]byte, error) {
+	if ch.Metadata.Annotations == nil {
+		ch.Metadata.Annotations = make(map[string]string)
+	}
+	for key, value := range annotations {
+		ch.Metadata.Annotations[key] = value
+	}
+
+	tmpDir, err := os.MkdirTemp("", "annotated-chart-")
+	if err != nil {
+		common.Log.Errorf("failed to create temporary directory: %v", err)
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	archive, err := chartutil.Save(ch, tmpDir)
+	if err != nil {
+		common.Log.Errorf("failed to archive annotated chart %s: %v", ch.Metadata.Name, err)
+		return nil, err
+	}
+	chartData, err := os.ReadFile(archive)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(packagedPath, chartData, 0644); err != nil {
+		common.Log.Errorf("failed to write annotated chart %s: %v", packagedPath, err)
+		return nil, err
+	}
+	return chartData, nil
+}
+
+// extraTagAliases resolves configured extra tags (latest, major, minor) into tag names for version,
+// prereleases never receive floating aliases
+func extraTagAliases(version string, extraTags []string) ([]string, error) {
+	if len(extraTags) == 0 {
+		return nil, nil
+	}
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compute extra tags, chart version %s is not valid SemVer: %w", version, err)
+	}
+	if v.Prerelease() != "" {
+		common.Log.Infof("Chart version %s is a prerelease, skipping extra tags %v", version, extraTags)
+		return nil, nil
+	}
+
+	aliases := make([]string, 0, len(extraTags))
+	for _, tag := range extraTags {
+		switch strings.ToLower(tag) {
+		case common.ExtraTagLatest:
+			aliases = append(aliases, common.ExtraTagLatest)
+		case common.ExtraTagMajor:
+			aliases = append(aliases, fmt.Sprintf("%d", v.Major()))
+		case common.ExtraTagMinor:
+			aliases = append(aliases, fmt.Sprintf("%d.%d", v.Major(), v.Minor()))
+		default:
+			return nil, fmt.Errorf("unknown extra tag '%s', expected one of: latest, major, minor", tag)
+		}
+	}
+	return aliases, nil
+}
+
+// CrdChartName renders the CRD chart name for chartName from the configured naming template
+func CrdChartName(chartName string, settings *common.HelmSettings) (string, error) {
+	naming := settings.CrdChartName
+	if naming == "" {
+		naming = common.DefaultCrdChartName
+	}
+	tmpl, err := template.New("crdChartName").Parse(naming)
+	if err != nil {
+		return "", fmt.Errorf("invalid CRD chart naming template '%s': %w", naming, err)
+	}
+	out := new(strings.Builder)
+	if err := tmpl.Execute(out, struct{ ChartName string }{chartName}); err != nil {
+		return "", fmt.Errorf("failed to render CRD chart name for %s: %w", chartName, err)
+	}
+	return out.String(), nil
+}
+
+// IsCrdChart reports whether chartName names a generated CRD chart according to the naming template
+func IsCrdChart(chartName string, settings *common.HelmSettings) bool {
+	const marker = "\x00"
+	pattern, err := CrdChartName(marker, settings)
+	if err != nil {
+		return false
+	}
+	rc, err := regexp.Compile("^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), marker, ".+") + "$")
+	if err != nil {
+		return false
+	}
+	return rc.MatchString(chartName)
+}
+
+// SkipExistingVersion tells whether publishing skips a chart whose version is already in the registry instead of
+// failing, CRD charts are often re-published unchanged with a new main chart version
+func SkipExistingVersion(chartName string, settings *common.HelmSettings) bool {
+	return !settings.FailOnExists || (settings.IgnoreExistingCrdCharts && IsCrdChart(chartName, settings))
+}
+
+// RemoteFor resolves the OCI remote a chart is published to, chartRemotes win over crdRemote and remote
+func RemoteFor(chartName string, settings *common.HelmSettings) string {
+	if remote, ok := settings.ChartRemotes[chartName]; ok && remote != "" {
+		return remote
+	}
+	if settings.CrdRemote != "" && IsCrdChart(chartName, settings) {
+		return settings.CrdRemote
+	}
+	return settings.Remote
+}
+
+// contextTransport binds every registry request to ctx,
+// the registry client itself doesn't accept a context
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
+}
+
+// registryClientOptions binds the registry client to ctx and passes the configured credentials with every request
+func registryClientOptions(ctx context.Context, auth *common.RemoteAuth) []registry.ClientOption {
+	opts := []registry.ClientOption{
+		registry.ClientOptEnableCache(true),
+		registry.ClientOptHTTPClient(&http.Client{
+			Transport: &contextTransport{ctx: ctx, base: retry.NewTransport(common.HTTPClient.Transport)},
+		}),
+	}
+	if auth.CredentialsFile != "" {
+		opts = append(opts, registry.ClientOptCredentialsFile(auth.CredentialsFile))
 	}
+	if auth.Username != "" {
+		opts = append(opts, registry.ClientOptBasicAuth(auth.Username, auth.Password))
+	}
+	return opts
+}
+
+// newRegistryClient creates a registry client authenticating every request with the configured credentials,
+// nothing is written to the user's registry config
+func newRegistryClient(ctx context.Context, auth *common.RemoteAuth) (*registry.Client, error) {
+	rc, err := registry.NewClient(registryClientOptions(ctx, auth)...)
+	if err != nil {
+		common.Log.Errorf("failed to create registry client: %v", err)
+		return nil, err
+	}
+	return rc, nil
+}
+
+// CheckRegistry lists the tags of remote with the configured credentials, without logging in so no
+// credentials are stored, a repository that doesn't exist yet still proves the registry reachable
+func CheckRegistry(ctx context.Context, remote string, auth *common.RemoteAuth) error {
+	if remote == "" {
+		return fmt.Errorf("no remote configured")
+	}
+	rc, err := registry.NewClient(registryClientOptions(ctx, auth)...)
+	if err != nil {
+		return fmt.Errorf("failed to create registry client: %w", err)
+	}
+	if _, err := rc.Tags(strings.TrimPrefix(remote, "oci://")); err != nil && !repositoryNotFound(err) {
+		return fmt.Errorf("failed to reach %s: %w", remote, err)
+	}
+	return nil
+}
 
-	return ref, nil
+// repositoryNotFound tells whether a registry error means the repository has never been pushed
+func repositoryNotFound(err error) bool {
+	return strings.Contains(err.Error(), "404") || strings.Contains(err.Error(), "name unknown")
 }
 
 func versionExistsInRegistry(rc *registry.Client, ref, version string) (bool, error) {
+	tags, err := registryTags(rc, ref)
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(tags, version), nil
+}
+
+// registryTags returns the chart versions tagged in the repository of ref,
+// none when the repository doesn't exist yet (404)
+func registryTags(rc *registry.Client, ref string) ([]string, error) {
 	tags, err := rc.Tags(strings.TrimPrefix(ref, "oci://"))
 	if err != nil {
-		// If the repository doesn't exist yet (404), treat it as "version doesn't exist"
-		if strings.Contains(err.Error(), "404") || strings.Contains(err.Error(), "name unknown") {
+		if repositoryNotFound(err) {
 			common.Log.Debugf("Registry repository does not exist yet, will create on first push")
-			return false, nil
+			return []string{}, nil
 		}
-		return false, fmt.Errorf("failed to fetch tags: %w", err)
+		return nil, fmt.Errorf("failed to fetch tags: %w", err)
 	}
-	for _, tag := range tags {
-		if tag == version {
-			return true, nil
-		}
-	}
-	return false, nil
+	return tags, nil
 }
 
+// clearTemplates removes generated templates before the chart is saved, also from subdirectories of templates/,
+// hand-written partials are kept, subdirectories left empty are removed, extra templates are removed too
+// but saved again with the chart as they are part of its templates
 func clearTemplates(path string) error {
-	templatesDir := fmt.Sprintf("%s/templates", path)
-	files, err := os.ReadDir(templatesDir)
-	if err != nil {
-		return err
+	templatesDir := filepath.Join(path, "templates")
+	if _, err := os.Stat(templatesDir); os.IsNotExist(err) {
+		return nil
 	}
-	for _, file := range files {
-		if strings.HasSuffix(file.Name(), ".tpl") {
-			continue
-		}
-		err := os.RemoveAll(fmt.Sprintf("%s/%s", templatesDir, file.Name()))
+	dirs := make([]string, 0)
+	err := filepath.WalkDir(templatesDir, func(file string, entry fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
+		if entry.IsDir() {
+			if file != templatesDir {
+				dirs = append(dirs, file)
+			}
+			return nil
+		}
+		if strings.HasSuffix(entry.Name(), ".tpl") && !generatedPartial(filepath.Base(path), file) {
+			return nil
+		}
+		return os.Remove(file)
+	})
+	if err != nil {
+		return err
 	}
 
+	// walked parents first, so subdirectories are removed before their parents
+	slices.Reverse(dirs)
+	for _, dir := range dirs {
+		if entries, err := os.ReadDir(dir); err == nil && len(entries) == 0 {
+			if err := os.Remove(dir); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
-func NewHelmCharts(helmSettings *common.HelmSettings, chartName string, m *common.Manifests) (*HelmizedManifests, error) {
+// generatedPartial tells generated partials of chartName from hand-written ones, partials generated before
+// generatedPartialHeader existed are recognized by their generated file and define names, e.g.
+// _deployment.tpl defining <chart>.deployment, so they are migrated to the header on the next generation
+func generatedPartial(chartName, path string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	if bytes.HasPrefix(data, []byte(generatedPartialHeader)) {
+		return true
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "_"), ".tpl")
+	legacyDefine := fmt.Sprintf("{{- define \"%s.%s\" }}\n", chartName, name)
+	return bytes.HasPrefix(data, []byte(legacyDefine)) && bytes.HasSuffix(data, []byte("{{- end }}\n"))
+}
+
+func NewHelmCharts(log *logrus.Entry, helmSettings *common.HelmSettings, chartName string, crdMode string, m *common.Manifests) (*HelmizedManifests, error) {
 	var crdsChart *chart.Chart
-	var err error
+	var crdFiles []map[string]any
+	var crdFindings []LintFinding
+	m, err := withPreservedCrds(log, helmSettings, chartName, crdMode, m)
+	if err != nil {
+		return nil, err
+	}
 	if m.ContainsCrds() {
-		crdsChartName := fmt.Sprintf("%s-crds", chartName)
-		common.Log.Infof("Moving %d CRDs to dedicated chart %s", len(m.Crds), crdsChartName)
-		crdsChart, err = NewHelmChart(crdsChartName, m, true, helmSettings)
-		if err != nil {
-			return nil, err
+		switch crdMode {
+		case "", common.CrdModeSeparate:
+			crdsChartName, err := CrdChartName(chartName, helmSettings)
+			if err != nil {
+				return nil, err
+			}
+			log.Infof("Moving %d CRDs to dedicated chart %s", len(m.Crds), crdsChartName)
+			crdsChart, crdFindings, err = NewHelmChart(log, crdsChartName, m, true, nil, helmSettings)
+			if err != nil {
+				return nil, err
+			}
+		case common.CrdModeInline:
+			log.Infof("Templating %d CRDs in chart %s", len(m.Crds), chartName)
+			m = &common.Manifests{
+				Manifests:      append(append(make([]map[string]any, 0, len(m.Manifests)+len(m.Crds)), m.Manifests...), m.Crds...),
+				Version:        m.Version,
+				AppVersion:     m.AppVersion,
+				Values:         *common.DeepMerge(&m.Values, &m.CrdsValues),
+				ValuesSchema:   m.ValuesSchema,
+				ChartType:      m.ChartType,
+				ExtraTemplates: m.ExtraTemplates,
+				PreserveCrds:   m.PreserveCrds,
+			}
+		case common.CrdModeCrdsDir:
+			log.Infof("Placing %d CRDs in crds/ of chart %s", len(m.Crds), chartName)
+			crdFiles = m.Crds
+		case common.CrdModeDrop:
+			log.Infof("Dropping %d CRDs of chart %s", len(m.Crds), chartName)
+		default:
+			return nil, fmt.Errorf("unknown crdMode '%s' for chart %s", crdMode, chartName)
 		}
 	}
-	mainChart, err := NewHelmChart(chartName, m, false, helmSettings)
+	mainChart, findings, err := NewHelmChart(log, chartName, m, false, crdFiles, helmSettings)
 	if err != nil {
 		return nil, err
 	}
 
+	images := ReferencedImages(m.Manifests)
+	if helmSettings.WriteImageList {
+		if err := writeImageList(log, filepath.Join(helmSettings.BuildDir(), chartName), images); err != nil {
+			return nil, err
+		}
+	}
+
 	createdChart := &HelmizedManifests{
-		Path:     helmSettings.SrcDir,
-		Chart:    mainChart,
-		CrdChart: crdsChart,
+		Path:         helmSettings.BuildDir(),
+		Chart:        mainChart,
+		CrdChart:     crdsChart,
+		LintFindings: append(crdFindings, findings...),
+		Images:       images,
 	}
 
 	return createdChart, nil
 }
 
-func NewHelmChart(chartName string, m *common.Manifests, crds bool, helmSettings *common.HelmSettings) (*chart.Chart, error) {
+// NewHelmChart creates the chart from the manifests, or from the CRDs when crds is set,
+// crdFiles are written verbatim to the Helm-native crds/ directory
+func NewHelmChart(log *logrus.Entry, chartName string, m *common.Manifests, crds bool, crdFiles []map[string]any, helmSettings *common.HelmSettings) (*chart.Chart, []LintFinding, error) {
 	version := m.Version
 	appVersion := m.AppVersion
 	vals := &m.Values
@@ -311,40 +1138,225 @@ func NewHelmChart(chartName string, m *common.Manifests, crds bool, helmSettings
 		templates = &m.Crds
 		vals = &m.CrdsValues
 	}
+	if helmSettings.FailOnEmptyChart && len(*templates) == 0 && len(crdFiles) == 0 {
+		return nil, nil, fmt.Errorf("%w: %s, check drop rules and the release assets", ErrChartEmpty, chartName)
+	}
 
-	chartPath, err := chartutil.Create(chartName, helmSettings.SrcDir) //overwrites
+	if helmSettings.ValuesOnly {
+		return newValuesOnlyChart(log, chartName, m, crds, vals, helmSettings)
+	}
+
+	// resolved before the chart is created as an in-place build overwrites the committed Chart.yaml
+	var crdsDigest string
+	var err error
+	if crds {
+		switch helmSettings.CrdVersioning {
+		case "", common.CrdVersioningRelease:
+		case common.CrdVersioningContent:
+			crdsDigest, err = crdContentDigest(m.Crds, m.CrdsValues)
+			if err != nil {
+				return nil, nil, err
+			}
+			version, appVersion, err = crdChartVersion(log, helmSettings.SrcDir, chartName, crdsDigest, version, appVersion, helmSettings.VersionSuffix)
+			if err != nil {
+				return nil, nil, err
+			}
+		default:
+			return nil, nil, fmt.Errorf("unknown crdVersioning '%s' for chart %s", helmSettings.CrdVersioning, chartName)
+		}
+	}
+
+	chartPath, err := chartutil.Create(chartName, helmSettings.BuildDir()) //overwrites
 	if err != nil {
-		common.Log.Errorf("Failed to create Helm chart in %s: %v", helmSettings.SrcDir, err)
-		return nil, err
+		log.Errorf("Failed to create Helm chart in %s: %v", helmSettings.BuildDir(), err)
+		return nil, nil, err
 	}
-	common.Log.Infof("Created Helm chart: %s", chartPath)
+	log.Infof("Created Helm chart: %s", chartPath)
 	chartObj, err := loader.Load(chartPath)
 	if err != nil {
-		common.Log.Errorf("Failed to load Helm chart from %s: %v", chartPath, err)
-		return nil, err
+		log.Errorf("Failed to load Helm chart from %s: %v", chartPath, err)
+		return nil, nil, err
 	}
 
-	err = createTemplates(chartObj, templates)
+	chartType := m.ChartType
+	if crds {
+		chartType = common.ChartTypeApplication // CRD charts are always installed
+	}
+	err = updateChartManifest(chartObj, &version, appVersion, chartType)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if crdsDigest != "" {
+		if chartObj.Metadata.Annotations == nil {
+			chartObj.Metadata.Annotations = make(map[string]string)
+		}
+		chartObj.Metadata.Annotations[CrdsDigestAnnotation] = crdsDigest
 	}
 
-	err = updateChartManifest(chartObj, &version, appVersion)
+	err = createTemplates(log, chartObj, templates, helmSettings.TemplateLayout, helmSettings.MissingKind, helmSettings.TemplateDirs)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if !crds {
+		err = addExtraTemplates(log, chartObj, m.ExtraTemplates)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = createCrdFiles(log, chartObj, crdFiles)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	chartObj.Schema = nil
+	err = setValuesSchema(chartObj, m, crds)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	findings, err := finishChart(log, chartPath, chartObj, vals, helmSettings)
+	if err != nil {
+		return nil, findings, err
+	}
+	return chartObj, findings, nil
+}
+
+// crdContentDigest hashes the CRDs and values of a CRD chart independent of the order of the release assets
+func crdContentDigest(crds []map[string]any, values map[string]any) (string, error) {
+	docs := make([]string, 0, len(crds)+1)
+	for _, crd := range crds {
+		data, err := yaml.Marshal(crd)
+		if err != nil {
+			return "", err
+		}
+		docs = append(docs, string(data))
+	}
+	slices.Sort(docs)
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	docs = append(docs, string(data))
+	sum := sha256.Sum256([]byte(strings.Join(docs, "---\n")))
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// crdChartVersion keeps the version and appVersion of the committed CRD chart while its CRDs are unchanged
+// and bumps its patch version otherwise, keeping versionSuffix, a new CRD chart starts at the main chart's version
+func crdChartVersion(log *logrus.Entry, srcDir, chartName, digest string, version semver.Version, appVersion, versionSuffix string) (semver.Version, string, error) {
+	chartPath := filepath.Join(srcDir, chartName)
+	if !fileExists(filepath.Join(chartPath, chartutil.ChartfileName)) {
+		return version, appVersion, nil
+	}
+	committed, err := chartutil.LoadChartfile(filepath.Join(chartPath, chartutil.ChartfileName))
+	if err != nil {
+		log.Errorf("Failed to load CRD chart %s: %v", chartPath, err)
+		return version, "", err
+	}
+	committedVersion, err := semver.NewVersion(committed.Version)
+	if err != nil {
+		return version, "", fmt.Errorf("CRD chart %s has invalid version %s: %w", chartName, committed.Version, err)
+	}
+	if committed.Annotations[CrdsDigestAnnotation] == digest {
+		log.Infof("CRDs of chart %s are unchanged, keeping version %s", chartName, committedVersion)
+		return *committedVersion, committed.AppVersion, nil
+	}
+	bumped, err := common.WithVersionSuffix(committedVersion.IncPatch(), versionSuffix)
+	if err != nil {
+		return version, "", err
+	}
+	return bumped, appVersion, nil
+}
+
+// newValuesOnlyChart regenerates values.yaml (and values.schema.json) of the existing chart in SrcDir,
+// templates and crds/ are written to the build directory unchanged, the patch version is bumped like
+// crdChartVersion does when the values or the schema changed
+func newValuesOnlyChart(log *logrus.Entry, chartName string, m *common.Manifests, crds bool, vals *map[string]any, helmSettings *common.HelmSettings) (*chart.Chart, []LintFinding, error) {
+	srcPath := filepath.Join(helmSettings.SrcDir, chartName)
+	chartObj, err := loader.Load(srcPath)
+	if err != nil {
+		log.Errorf("Values-only regeneration needs the existing chart %s: %v", srcPath, err)
+		return nil, nil, err
+	}
+	chartPath := filepath.Join(helmSettings.BuildDir(), chartName)
+	log.Infof("Regenerating values of Helm chart %s into %s", srcPath, chartPath)
+
+	committedValues, err := os.ReadFile(filepath.Join(srcPath, chartutil.ValuesfileName))
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("Failed to read values of chart %s: %v", srcPath, err)
+		return nil, nil, err
+	}
+	committedSchema := chartObj.Schema
+	err = setValuesSchema(chartObj, m, crds)
+	if err != nil {
+		return nil, nil, err
+	}
+	values, err := valuesFileData(chartObj, *vals)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(committedValues, values) || !bytes.Equal(committedSchema, chartObj.Schema) {
+		committedVersion, err := semver.NewVersion(chartObj.Metadata.Version)
+		if err != nil {
+			return nil, nil, fmt.Errorf("chart %s has invalid version %s: %w", chartName, chartObj.Metadata.Version, err)
+		}
+		version, err := common.WithVersionSuffix(committedVersion.IncPatch(), helmSettings.VersionSuffix)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Infof("Values of chart %s changed, bumping version %s to %s", chartName, committedVersion, &version)
+		chartObj.Metadata.Version = version.String()
 	}
 
-	err = save(chartPath, chartObj, vals)
+	findings, err := finishChart(log, chartPath, chartObj, vals, helmSettings)
+	if err != nil {
+		return nil, findings, err
+	}
+	return chartObj, findings, nil
+}
+
+func setValuesSchema(ch *chart.Chart, m *common.Manifests, crds bool) error {
+	if crds || len(m.ValuesSchema) == 0 {
+		return nil
+	}
+	schema, err := json.MarshalIndent(m.ValuesSchema, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal values schema of %s: %w", ch.Metadata.Name, err)
+	}
+	ch.Schema = schema
+	return nil
+}
+
+// finishChart saves, validates and lints the chart, then runs the post-generate hooks, lint findings are returned
+func finishChart(log *logrus.Entry, chartPath string, chartObj *chart.Chart, vals *map[string]any, helmSettings *common.HelmSettings) ([]LintFinding, error) {
+
+	err := save(log, chartPath, chartObj, vals)
 	if err != nil {
 		return nil, err
 	}
 
-	err = Lint(chartPath, chartObj, helmSettings)
+	err = validateValueReferences(log, chartObj, helmSettings.DanglingValues)
 	if err != nil {
 		return nil, err
 	}
 
-	return chartObj, nil
+	findings, err := Lint(log, chartPath, chartObj, helmSettings)
+	if err != nil {
+		return findings, err
+	}
+
+	return findings, runPostGenerateHooks(log, chartPath, helmSettings.PostGenerateHooks)
+}
+
+// SeedBuildDir copies the committed charts into a scratch build directory,
+// values-only regeneration starts from the existing charts
+func SeedBuildDir(srcDir, buildDir string) error {
+	if err := copyDir(srcDir, buildDir); err != nil {
+		common.Log.Errorf("Failed to copy charts from %s to %s: %v", srcDir, buildDir, err)
+		return err
+	}
+	return nil
 }
 
 func PeekVersions(chartDir, chartName string) (string, string, error) {
@@ -356,3 +1368,100 @@ func PeekVersions(chartDir, chartName string) (string, string, error) {
 	}
 	return chartObj.Metadata.Version, chartObj.AppVersion(), nil
 }
+
+// ResolveBaselineVersions determines the version new chart versions are derived from:
+// a VERSION file in the chart directory wins over the chart's own version, plain manifest output
+// has no Chart.yaml and only VERSION and APP_VERSION files, initialVersion is used when no chart exists yet
+func ResolveBaselineVersions(chartDir, chartName, initialVersion string) (string, string, error) {
+	path := filepath.Join(chartDir, chartName)
+	versionFile := filepath.Join(path, VersionFileName)
+	var version, appVersion string
+	var err error
+	switch {
+	case fileExists(filepath.Join(path, chartutil.ChartfileName)):
+		version, appVersion, err = PeekVersions(chartDir, chartName)
+	case fileExists(versionFile):
+		appVersion, err = readVersionFile(filepath.Join(path, AppVersionFileName))
+	default:
+		if initialVersion == "" {
+			return "", "", fmt.Errorf("chart %s does not exist yet, set initialVersion for release", path)
+		}
+		common.Log.Infof("Chart %s does not exist yet, using initial version %s", path, initialVersion)
+		return initialVersion, "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	if fileExists(versionFile) {
+		version, err = readVersionFile(versionFile)
+		if err != nil {
+			return "", "", err
+		}
+		common.Log.Debugf("Using version %s from %s", version, versionFile)
+	}
+
+	return version, appVersion, nil
+}
+
+// readVersionFile returns the trimmed content of a VERSION or APP_VERSION file, empty when it doesn't exist
+func readVersionFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		common.Log.Errorf("Failed to read %s: %v", path, err)
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		return copyFile(path, target)
+	})
+}
+
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	// written beside dst and renamed so dst is never left half written
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,1186 @@
+package packager
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/krezh/charts/internal/common"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+)
+
+func TestWriteArchive(t *testing.T) {
+	//given
+	dir := t.TempDir()
+	packaged := map[string]string{
+		"kubevirt-1.0.0.tgz":      "main chart",
+		"kubevirt-crds-1.0.0.tgz": "crd chart",
+	}
+	paths := make([]string, 0, len(packaged))
+	for name, content := range packaged {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+		paths = append(paths, path)
+	}
+
+	//when
+	out := new(bytes.Buffer)
+	err := WriteArchive(out, paths)
+
+	//then
+	if err != nil {
+		t.Fatalf("WriteArchive() error = %v", err)
+	}
+	tr := tar.NewReader(out)
+	found := 0
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("WriteArchive() produced invalid tar: %v", err)
+		}
+		data, _ := io.ReadAll(tr)
+		if want, ok := packaged[header.Name]; !ok || string(data) != want {
+			t.Errorf("WriteArchive() entry %s = %q, want %q", header.Name, data, want)
+		}
+		found++
+	}
+	if found != len(packaged) {
+		t.Errorf("WriteArchive() entries = %d, want %d", found, len(packaged))
+	}
+}
+
+func TestExtraTagAliases(t *testing.T) {
+	testCases := map[string]struct {
+		version   string
+		extraTags []string
+		want      []string
+		wantErr   bool
+	}{
+		"none": {
+			version: "1.2.3",
+			want:    nil,
+		},
+		"all": {
+			version:   "1.2.3",
+			extraTags: []string{"latest", "major", "minor"},
+			want:      []string{"latest", "1", "1.2"},
+		},
+		"prerelease": {
+			version:   "1.2.3-rc.1",
+			extraTags: []string{"latest", "minor"},
+			want:      nil,
+		},
+		"unknown": {
+			version:   "1.2.3",
+			extraTags: []string{"patch"},
+			wantErr:   true,
+		},
+		"invalid_version": {
+			version:   "not-semver",
+			extraTags: []string{"latest"},
+			wantErr:   true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//when
+			got, err := extraTagAliases(tc.version, tc.extraTags)
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("extraTagAliases() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("extraTagAliases() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestOciAnnotations(t *testing.T) {
+	testCases := map[string]struct {
+		settings common.OciAnnotations
+		want     map[string]string
+	}{
+		"defaults": {
+			settings: common.OciAnnotations{Source: "https://github.com/krezh/charts"},
+			want: map[string]string{
+				common.OciAnnotationSource:   "https://github.com/krezh/charts",
+				common.OciAnnotationRevision: "1.2.3",
+			},
+		},
+		"configured_revision": {
+			settings: common.OciAnnotations{Revision: "abc123"},
+			want:     map[string]string{common.OciAnnotationRevision: "abc123"},
+		},
+		"disabled": {
+			settings: common.OciAnnotations{Source: "https://github.com/krezh/charts", Disabled: true},
+			want:     nil,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//when
+			got := ociAnnotations(&chart.Metadata{Name: "kubevirt", Version: "1.2.3"}, &tc.settings)
+
+			//then
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("ociAnnotations() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAnnotateChart(t *testing.T) {
+	//given
+	dir := t.TempDir()
+	ch := &chart.Chart{Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "kubevirt", Version: "1.2.3"}}
+	packagedPath := filepath.Join(dir, "kubevirt-1.2.3.tgz")
+	if err := os.WriteFile(packagedPath, []byte("unannotated"), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", packagedPath, err)
+	}
+
+	//when
+	chartData, err := annotateChart(ch, map[string]string{common.OciAnnotationRevision: "1.2.3"}, packagedPath)
+
+	//then
+	if err != nil {
+		t.Fatalf("annotateChart() error = %v", err)
+	}
+	annotated, err := loader.LoadFile(packagedPath)
+	if err != nil {
+		t.Fatalf("annotateChart() wrote an invalid chart: %v", err)
+	}
+	if got := annotated.Metadata.Annotations[common.OciAnnotationRevision]; got != "1.2.3" {
+		t.Errorf("annotateChart() revision annotation = %q, want 1.2.3", got)
+	}
+	written, _ := os.ReadFile(packagedPath)
+	if !bytes.Equal(chartData, written) {
+		t.Errorf("annotateChart() returned data differing from %s", packagedPath)
+	}
+}
+
+func TestHelmizedManifestsUnchanged(t *testing.T) {
+	testCases := map[string]struct {
+		umbrella    *chart.Chart
+		unchanged   []string
+		wantDirs    []string
+		wantCrdsDir string
+	}{
+		"all_changed": {
+			wantDirs:    []string{"kubevirt", "kubevirt-crds"},
+			wantCrdsDir: "kubevirt-crds",
+		},
+		"crds_unchanged": {
+			unchanged: []string{"kubevirt-crds"},
+			wantDirs:  []string{"kubevirt"},
+		},
+		"main_unchanged": {
+			unchanged:   []string{"kubevirt"},
+			wantDirs:    []string{"kubevirt-crds"},
+			wantCrdsDir: "kubevirt-crds",
+		},
+		"umbrella_member": {
+			umbrella:    &chart.Chart{Metadata: &chart.Metadata{Name: "platform"}},
+			wantDirs:    []string{"platform/charts/kubevirt", "platform/charts/kubevirt-crds"},
+			wantCrdsDir: "platform/charts/kubevirt-crds",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			charts := &HelmizedManifests{
+				Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: "kubevirt"}},
+				CrdChart:  &chart.Chart{Metadata: &chart.Metadata{Name: "kubevirt-crds"}},
+				Umbrella:  tc.umbrella,
+				Unchanged: tc.unchanged,
+			}
+
+			//when
+			dirs := charts.ChartDirs()
+			crdsDir := charts.CrdChartDir()
+
+			//then
+			if !reflect.DeepEqual(dirs, tc.wantDirs) {
+				t.Errorf("ChartDirs() = %v, want %v", dirs, tc.wantDirs)
+			}
+			if crdsDir != tc.wantCrdsDir {
+				t.Errorf("CrdChartDir() = %s, want %s", crdsDir, tc.wantCrdsDir)
+			}
+		})
+	}
+}
+
+func TestHelmizedManifestsMoveTo(t *testing.T) {
+	testCases := map[string]struct {
+		replace   bool
+		wantFiles []string
+		wantGone  []string
+	}{
+		"chart": {
+			wantFiles: []string{"Chart.yaml", "README.md", "templates/deployment.yaml", "templates/_helpers.tpl"},
+			wantGone:  []string{"templates/stale.yaml", "crds/stale.yaml", "values.schema.json"},
+		},
+		"replace": {
+			replace:   true,
+			wantFiles: []string{"Chart.yaml", "templates/deployment.yaml"},
+			wantGone:  []string{"README.md", "templates/_helpers.tpl", "templates/stale.yaml"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			buildDir := t.TempDir()
+			srcDir := t.TempDir()
+			writeTestFile(t, filepath.Join(buildDir, "operator", "Chart.yaml"), "name: operator\nversion: 1.1.0\n")
+			writeTestFile(t, filepath.Join(buildDir, "operator", "templates", "deployment.yaml"), "kind: Deployment\n")
+			for _, file := range []string{"Chart.yaml", "README.md", "templates/_helpers.tpl", "templates/stale.yaml", "crds/stale.yaml", "values.schema.json"} {
+				writeTestFile(t, filepath.Join(srcDir, "operator", file), "committed\n")
+			}
+			charts := &HelmizedManifests{
+				Path:    buildDir,
+				Chart:   &chart.Chart{Metadata: &chart.Metadata{Name: "operator"}},
+				Replace: tc.replace,
+			}
+
+			//when
+			err := charts.MoveTo(srcDir)
+
+			//then
+			if err != nil {
+				t.Fatalf("MoveTo() error = %v", err)
+			}
+			for _, file := range tc.wantFiles {
+				if !fileExists(filepath.Join(srcDir, "operator", file)) {
+					t.Errorf("MoveTo() did not install %s", file)
+				}
+			}
+			for _, file := range tc.wantGone {
+				if fileExists(filepath.Join(srcDir, "operator", file)) {
+					t.Errorf("MoveTo() kept %s", file)
+				}
+			}
+			chartfile, _ := os.ReadFile(filepath.Join(srcDir, "operator", "Chart.yaml"))
+			if !strings.Contains(string(chartfile), "1.1.0") {
+				t.Errorf("MoveTo() Chart.yaml = %q, want generated one", chartfile)
+			}
+			entries, _ := os.ReadDir(srcDir)
+			if len(entries) != 1 {
+				t.Errorf("MoveTo() left %d entries in %s, want only the chart", len(entries), srcDir)
+			}
+			if charts.Path != srcDir {
+				t.Errorf("MoveTo() Path = %s, want %s", charts.Path, srcDir)
+			}
+		})
+	}
+}
+
+func TestRemoteFor(t *testing.T) {
+	testCases := map[string]struct {
+		chartName    string
+		crdRemote    string
+		crdChartName string
+		chartRemotes common.ChartRemotes
+		want         string
+	}{
+		"main_chart": {
+			chartName: "kubevirt",
+			crdRemote: "oci://ghcr.io/krezh/crds",
+			want:      "oci://ghcr.io/krezh/charts",
+		},
+		"crd_chart": {
+			chartName: "kubevirt-crds",
+			crdRemote: "oci://ghcr.io/krezh/crds",
+			want:      "oci://ghcr.io/krezh/crds",
+		},
+		"crd_chart_default_remote": {
+			chartName: "kubevirt-crds",
+			want:      "oci://ghcr.io/krezh/charts",
+		},
+		"custom_crd_chart_name": {
+			chartName:    "crds-kubevirt",
+			crdRemote:    "oci://ghcr.io/krezh/crds",
+			crdChartName: "crds-{{.ChartName}}",
+			want:         "oci://ghcr.io/krezh/crds",
+		},
+		"custom_crd_chart_name_default_suffix": {
+			chartName:    "kubevirt-crds",
+			crdRemote:    "oci://ghcr.io/krezh/crds",
+			crdChartName: "crds-{{.ChartName}}",
+			want:         "oci://ghcr.io/krezh/charts",
+		},
+		"chart_remote": {
+			chartName:    "cdi",
+			chartRemotes: common.ChartRemotes{"cdi": "oci://registry.example.com/mirror"},
+			want:         "oci://registry.example.com/mirror",
+		},
+		"chart_remote_over_crd_remote": {
+			chartName:    "kubevirt-crds",
+			crdRemote:    "oci://ghcr.io/krezh/crds",
+			chartRemotes: common.ChartRemotes{"kubevirt-crds": "oci://registry.example.com/mirror", "cdi": "oci://registry.example.com/cdi"},
+			want:         "oci://registry.example.com/mirror",
+		},
+		"other_chart_remote": {
+			chartName:    "kubevirt",
+			chartRemotes: common.ChartRemotes{"cdi": "oci://registry.example.com/mirror"},
+			want:         "oci://ghcr.io/krezh/charts",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			settings := &common.HelmSettings{Remote: "oci://ghcr.io/krezh/charts", CrdRemote: tc.crdRemote, CrdChartName: tc.crdChartName, ChartRemotes: tc.chartRemotes}
+
+			//when
+			got := RemoteFor(tc.chartName, settings)
+
+			//then
+			if got != tc.want {
+				t.Errorf("RemoteFor() = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSkipExistingVersion(t *testing.T) {
+	testCases := map[string]struct {
+		chartName string
+		settings  common.HelmSettings
+		want      bool
+	}{
+		"default":                {chartName: "kubevirt", want: true},
+		"fail_on_exists":         {chartName: "kubevirt-crds", settings: common.HelmSettings{FailOnExists: true}, want: false},
+		"ignored_crd_chart":      {chartName: "kubevirt-crds", settings: common.HelmSettings{FailOnExists: true, IgnoreExistingCrdCharts: true}, want: true},
+		"main_chart_not_ignored": {chartName: "kubevirt", settings: common.HelmSettings{FailOnExists: true, IgnoreExistingCrdCharts: true}, want: false},
+		"custom_crd_chart_name":  {chartName: "crds-kubevirt", settings: common.HelmSettings{FailOnExists: true, IgnoreExistingCrdCharts: true, CrdChartName: "crds-{{.ChartName}}"}, want: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//when
+			got := SkipExistingVersion(tc.chartName, &tc.settings)
+
+			//then
+			if got != tc.want {
+				t.Errorf("SkipExistingVersion() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCrdChartName(t *testing.T) {
+	//given
+	settings := &common.HelmSettings{CrdChartName: "{{.ChartName}}-definitions"}
+
+	//when
+	got, err := CrdChartName("kubevirt", settings)
+
+	//then
+	if err != nil {
+		t.Fatalf("CrdChartName() error = %v", err)
+	}
+	if got != "kubevirt-definitions" {
+		t.Errorf("CrdChartName() = %s, want kubevirt-definitions", got)
+	}
+}
+
+func TestBuildOCIRef(t *testing.T) {
+	testCases := map[string]struct {
+		remote string
+		want   string
+	}{
+		"trailing_name": {
+			remote: "oci://ghcr.io/krezh/charts/kubevirt",
+			want:   "oci://ghcr.io/krezh/charts/kubevirt:1.2.3",
+		},
+		"trailing_name_with_slash": {
+			remote: "oci://ghcr.io/krezh/charts/kubevirt/",
+			want:   "oci://ghcr.io/krezh/charts/kubevirt:1.2.3",
+		},
+		"non_trailing_name": {
+			remote: "oci://ghcr.io/kubevirt/charts",
+			want:   "oci://ghcr.io/kubevirt/charts/kubevirt:1.2.3",
+		},
+		"multi_segment": {
+			remote: "oci://ghcr.io/org/subgroup/charts",
+			want:   "oci://ghcr.io/org/subgroup/charts/kubevirt:1.2.3",
+		},
+		"registry_only": {
+			remote: "oci://registry.local",
+			want:   "oci://registry.local/kubevirt:1.2.3",
+		},
+		"registry_host_equals_name": {
+			remote: "oci://kubevirt",
+			want:   "oci://kubevirt/kubevirt:1.2.3",
+		},
+		"name_prefix_segment": {
+			remote: "oci://ghcr.io/krezh/kubevirt-charts",
+			want:   "oci://ghcr.io/krezh/kubevirt-charts/kubevirt:1.2.3",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//when
+			got := buildOCIRef(tc.remote, "kubevirt", "1.2.3")
+
+			//then
+			if got != tc.want {
+				t.Errorf("buildOCIRef() = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestResolveBaselineVersions(t *testing.T) {
+	testCases := map[string]struct {
+		chartVersion   string
+		versionFile    string
+		initialVersion string
+		wantVersion    string
+		wantErr        bool
+	}{
+		"existing_chart": {
+			chartVersion: "1.2.3",
+			wantVersion:  "1.2.3",
+		},
+		"version_file": {
+			chartVersion: "1.2.3",
+			versionFile:  "2.0.0\n",
+			wantVersion:  "2.0.0",
+		},
+		"new_chart": {
+			initialVersion: "0.1.0",
+			wantVersion:    "0.1.0",
+		},
+		"new_chart_without_initial_version": {
+			wantErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			chartDir := t.TempDir()
+			if tc.chartVersion != "" {
+				chartYaml := "apiVersion: v2\nname: test\nversion: " + tc.chartVersion + "\nappVersion: v1.0.0\n"
+				writeTestFile(t, filepath.Join(chartDir, "test", "Chart.yaml"), chartYaml)
+			}
+			if tc.versionFile != "" {
+				writeTestFile(t, filepath.Join(chartDir, "test", VersionFileName), tc.versionFile)
+			}
+
+			//when
+			version, _, err := ResolveBaselineVersions(chartDir, "test", tc.initialVersion)
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ResolveBaselineVersions() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if version != tc.wantVersion {
+				t.Errorf("ResolveBaselineVersions() version = %s, want %s", version, tc.wantVersion)
+			}
+		})
+	}
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("failed to create %s: %v", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func TestNewHelmChartsCrdMode(t *testing.T) {
+	testCases := map[string]struct {
+		crdMode      string
+		wantCrdChart bool
+		wantCrdFiles int
+		wantErr      bool
+	}{
+		"separate": {
+			crdMode:      common.CrdModeSeparate,
+			wantCrdChart: true,
+		},
+		"inline": {
+			crdMode: common.CrdModeInline,
+		},
+		"crds_dir": {
+			crdMode:      common.CrdModeCrdsDir,
+			wantCrdFiles: 2,
+		},
+		"drop": {
+			crdMode: common.CrdModeDrop,
+		},
+		"unknown": {
+			crdMode: "bundled",
+			wantErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			testManifests, _ := common.NewManifests(readTestData(t), mustSemver("0.0.1"), "0.0.1", new(map[string]any), new(map[string]any))
+			settings := &common.HelmSettings{SrcDir: t.TempDir(), LintK8s: "1.30.0", DanglingValues: common.DanglingValuesIgnore}
+
+			//when
+			charts, err := NewHelmCharts(testLog(), settings, "test", tc.crdMode, testManifests)
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("NewHelmCharts() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if (charts.CrdChart != nil) != tc.wantCrdChart {
+				t.Errorf("NewHelmCharts() CRD chart = %v, want %v", charts.CrdChart != nil, tc.wantCrdChart)
+			}
+			crdFiles, _ := filepath.Glob(filepath.Join(settings.SrcDir, "test", CrdsDir, "*.yaml"))
+			if len(crdFiles) != tc.wantCrdFiles {
+				t.Errorf("NewHelmCharts() crds/ files = %d, want %d", len(crdFiles), tc.wantCrdFiles)
+			}
+			crdTemplate := fileExists(filepath.Join(settings.SrcDir, "test", "templates", "customresourcedefinition.yaml"))
+			if crdTemplate != (tc.crdMode == common.CrdModeInline) {
+				t.Errorf("NewHelmCharts() CRD template present = %v", crdTemplate)
+			}
+		})
+	}
+}
+
+func TestNewHelmChartsValuesOnly(t *testing.T) {
+	//given
+	testManifests, _ := common.NewManifests(readTestData(t), mustSemver("0.0.1"), "0.0.1", new(map[string]any), new(map[string]any))
+	settings := &common.HelmSettings{SrcDir: t.TempDir(), LintK8s: "1.30.0", DanglingValues: common.DanglingValuesIgnore}
+	if _, err := NewHelmCharts(testLog(), settings, "test", common.CrdModeDrop, testManifests); err != nil {
+		t.Fatalf("NewHelmCharts() error = %v", err)
+	}
+	templatePath := filepath.Join(settings.SrcDir, "test", "templates", "deployment.yaml")
+	writeTestFile(t, templatePath, "# hand edited\n")
+	valuesOnly := *settings
+	valuesOnly.ValuesOnly = true
+	newManifests, _ := common.NewManifests(readTestData(t), mustSemver("0.0.2"), "0.0.2", &map[string]any{"replicas": 3}, new(map[string]any))
+
+	//when
+	charts, err := NewHelmCharts(testLog(), &valuesOnly, "test", common.CrdModeDrop, newManifests)
+
+	//then
+	if err != nil {
+		t.Fatalf("NewHelmCharts() values-only error = %v", err)
+	}
+	template, _ := os.ReadFile(templatePath)
+	if string(template) != "# hand edited\n" {
+		t.Errorf("NewHelmCharts() values-only rewrote template:\n%s", template)
+	}
+	values, _ := os.ReadFile(filepath.Join(settings.SrcDir, "test", "values.yaml"))
+	if !strings.Contains(string(values), "replicas: 3") {
+		t.Errorf("NewHelmCharts() values-only values.yaml:\n%s", values)
+	}
+	if charts.Chart.Metadata.Version != "0.0.2" {
+		t.Errorf("NewHelmCharts() values-only version = %s, want 0.0.2", charts.Chart.Metadata.Version)
+	}
+	unchanged, err := NewHelmCharts(testLog(), &valuesOnly, "test", common.CrdModeDrop, newManifests)
+	if err != nil {
+		t.Fatalf("NewHelmCharts() values-only rerun error = %v", err)
+	}
+	if unchanged.Chart.Metadata.Version != "0.0.2" {
+		t.Errorf("NewHelmCharts() values-only rerun version = %s, want 0.0.2", unchanged.Chart.Metadata.Version)
+	}
+}
+
+func TestNewHelmChartsOutputDir(t *testing.T) {
+	//given
+	testManifests, _ := common.NewManifests(readTestData(t), mustSemver("0.0.1"), "0.0.1", new(map[string]any), new(map[string]any))
+	settings := &common.HelmSettings{SrcDir: t.TempDir(), OutputDir: t.TempDir(), LintK8s: "1.30.0", DanglingValues: common.DanglingValuesIgnore}
+
+	//when
+	charts, err := NewHelmCharts(testLog(), settings, "test", common.CrdModeSeparate, testManifests)
+
+	//then
+	if err != nil {
+		t.Fatalf("NewHelmCharts() error = %v", err)
+	}
+	if charts.Path != settings.OutputDir {
+		t.Errorf("NewHelmCharts() path = %s, want %s", charts.Path, settings.OutputDir)
+	}
+	if !fileExists(filepath.Join(settings.OutputDir, "test", "Chart.yaml")) {
+		t.Errorf("NewHelmCharts() did not write chart into output dir")
+	}
+	if entries, _ := os.ReadDir(settings.SrcDir); len(entries) != 0 {
+		t.Errorf("NewHelmCharts() wrote %d entries into src dir", len(entries))
+	}
+}
+
+func TestNewHelmChartsExtraTemplates(t *testing.T) {
+	testCases := map[string]struct {
+		fileName string
+		wantErr  bool
+	}{
+		"added":     {fileName: "networkpolicy.yaml"},
+		"collision": {fileName: "deployment.yaml", wantErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			extra := filepath.Join(t.TempDir(), tc.fileName)
+			content := "{{- if .Values.networkPolicy }}\nkind: NetworkPolicy\n{{- end }}\n"
+			writeTestFile(t, extra, content)
+			settings := &common.HelmSettings{SrcDir: t.TempDir(), LintK8s: "1.30.0", DanglingValues: common.DanglingValuesIgnore}
+
+			for _, version := range []string{"0.0.1", "0.0.2"} {
+				testManifests, _ := common.NewManifests(readTestData(t), mustSemver(version), version, new(map[string]any), new(map[string]any))
+				testManifests.ExtraTemplates = []string{extra}
+
+				//when
+				charts, err := NewHelmCharts(testLog(), settings, "test", common.CrdModeSeparate, testManifests)
+
+				//then
+				if (err != nil) != tc.wantErr {
+					t.Fatalf("NewHelmCharts() error = %v, wantErr %v", err, tc.wantErr)
+				}
+				if err != nil {
+					return
+				}
+				data, _ := os.ReadFile(filepath.Join(settings.SrcDir, "test", "templates", tc.fileName))
+				if string(data) != content {
+					t.Errorf("NewHelmCharts() %s extra template = %q, want it copied verbatim", version, data)
+				}
+				if fileExists(filepath.Join(settings.SrcDir, charts.CrdChartDir(), "templates", tc.fileName)) {
+					t.Errorf("NewHelmCharts() copied the extra template into the CRD chart")
+				}
+			}
+		})
+	}
+}
+
+func TestNewHelmChartsCrdVersioningContent(t *testing.T) {
+	//given
+	crd := func(group string) map[string]any {
+		return map[string]any{
+			"apiVersion": "apiextensions.k8s.io/v1",
+			"kind":       "CustomResourceDefinition",
+			"metadata":   map[string]any{"name": "widgets." + group},
+			"spec": map[string]any{
+				"group":    group,
+				"names":    map[string]any{"kind": "Widget", "plural": "widgets"},
+				"scope":    "Namespaced",
+				"versions": []any{map[string]any{"name": "v1", "served": true, "storage": true, "schema": map[string]any{"openAPIV3Schema": map[string]any{"type": "object"}}}},
+			},
+		}
+	}
+	deployment := map[string]any{"apiVersion": "v1", "kind": "ConfigMap", "metadata": map[string]any{"name": "operator"}}
+	settings := &common.HelmSettings{SrcDir: t.TempDir(), LintK8s: "1.30.0", DanglingValues: common.DanglingValuesIgnore, CrdVersioning: common.CrdVersioningContent}
+	generations := []struct {
+		version        string
+		suffix         string
+		group          string
+		wantCrdVersion string
+		wantAppVersion string
+	}{
+		{version: "1.0.0", group: "example.com", wantCrdVersion: "1.0.0", wantAppVersion: "1.0.0"},
+		{version: "1.1.0", group: "example.com", wantCrdVersion: "1.0.0", wantAppVersion: "1.0.0"},
+		{version: "1.2.0", group: "example.org", wantCrdVersion: "1.0.1", wantAppVersion: "1.2.0"},
+		{version: "1.3.0-dev.1", suffix: "-dev.1", group: "example.net", wantCrdVersion: "1.0.2-dev.1", wantAppVersion: "1.3.0-dev.1"},
+	}
+	for _, generation := range generations {
+		settings.VersionSuffix = generation.suffix
+		m := &common.Manifests{
+			Manifests:  []map[string]any{deployment},
+			Crds:       []map[string]any{crd(generation.group)},
+			Version:    *mustSemver(generation.version),
+			AppVersion: generation.version,
+		}
+
+		//when
+		charts, err := NewHelmCharts(testLog(), settings, "operator", common.CrdModeSeparate, m)
+
+		//then
+		if err != nil {
+			t.Fatalf("NewHelmCharts() %s error = %v", generation.version, err)
+		}
+		if charts.Chart.Metadata.Version != generation.version {
+			t.Errorf("NewHelmCharts() %s main chart version = %s", generation.version, charts.Chart.Metadata.Version)
+		}
+		crdChart := charts.CrdChart.Metadata
+		if crdChart.Version != generation.wantCrdVersion || crdChart.AppVersion != generation.wantAppVersion {
+			t.Errorf("NewHelmCharts() %s CRD chart = %s/%s, want %s/%s", generation.version, crdChart.Version, crdChart.AppVersion, generation.wantCrdVersion, generation.wantAppVersion)
+		}
+		if crdChart.Annotations[CrdsDigestAnnotation] == "" {
+			t.Errorf("NewHelmCharts() %s CRD chart has no content digest", generation.version)
+		}
+	}
+}
+
+func TestNewHelmChartsLibrary(t *testing.T) {
+	//given
+	testManifests, _ := common.NewManifests(readTestData(t), mustSemver("0.0.1"), "0.0.1", new(map[string]any), new(map[string]any))
+	testManifests.ChartType = common.ChartTypeLibrary
+	settings := &common.HelmSettings{SrcDir: t.TempDir(), LintK8s: "1.30.0", LintReleaseName: "library", DanglingValues: common.DanglingValuesIgnore}
+
+	//when
+	charts, err := NewHelmCharts(testLog(), settings, "test", common.CrdModeSeparate, testManifests)
+
+	//then
+	if err != nil {
+		t.Fatalf("NewHelmCharts() error = %v", err)
+	}
+	if charts.Chart.Metadata.Type != common.ChartTypeLibrary {
+		t.Errorf("NewHelmCharts() type = %s, want library", charts.Chart.Metadata.Type)
+	}
+	if charts.CrdChart.Metadata.Type != common.ChartTypeApplication {
+		t.Errorf("NewHelmCharts() CRD chart type = %s, want application", charts.CrdChart.Metadata.Type)
+	}
+	for _, template := range charts.Chart.Templates {
+		if !strings.HasPrefix(template.Name, "templates/_") || !strings.HasPrefix(string(template.Data), generatedPartialHeader+`{{- define "test.`) {
+			t.Errorf("NewHelmCharts() library template %s is not a named template", template.Name)
+		}
+	}
+}
+
+func TestNewHelmChartsUnknownChartType(t *testing.T) {
+	//given
+	testManifests, _ := common.NewManifests(readTestData(t), mustSemver("0.0.1"), "0.0.1", new(map[string]any), new(map[string]any))
+	testManifests.ChartType = "plugin"
+	settings := &common.HelmSettings{SrcDir: t.TempDir(), LintK8s: "1.30.0", DanglingValues: common.DanglingValuesIgnore}
+
+	//when
+	_, err := NewHelmCharts(testLog(), settings, "test", common.CrdModeDrop, testManifests)
+
+	//then
+	if err == nil {
+		t.Errorf("NewHelmCharts() expected error for unknown chartType")
+	}
+}
+
+func TestNewHelmChartsLintFindings(t *testing.T) {
+	//given
+	testManifests, _ := common.NewManifests(readTestData(t), mustSemver("0.0.1"), "0.0.1", new(map[string]any), new(map[string]any))
+	settings := &common.HelmSettings{SrcDir: t.TempDir(), LintK8s: "1.30.0", DanglingValues: common.DanglingValuesIgnore}
+
+	//when
+	charts, err := NewHelmCharts(testLog(), settings, "test", common.CrdModeSeparate, testManifests)
+
+	//then
+	if err != nil {
+		t.Fatalf("NewHelmCharts() error = %v", err)
+	}
+	if len(charts.LintFindings) == 0 {
+		t.Fatalf("NewHelmCharts() returned no lint findings")
+	}
+	for _, finding := range charts.LintFindings {
+		if finding.Path == "" || finding.Message == "" {
+			t.Errorf("NewHelmCharts() incomplete lint finding %+v", finding)
+		}
+	}
+	if report := charts.LintReport(); !strings.Contains(report, "- [INFO] ") {
+		t.Errorf("LintReport() = %s", report)
+	}
+}
+
+func TestLintReleaseNameAndNamespace(t *testing.T) {
+	chartDir := filepath.Join(t.TempDir(), "test")
+	writeTestFile(t, filepath.Join(chartDir, "Chart.yaml"), "apiVersion: v2\nname: test\nversion: 0.1.0\n")
+	writeTestFile(t, filepath.Join(chartDir, "values.yaml"), "{}\n")
+	writeTestFile(t, filepath.Join(chartDir, "templates", "configmap.yaml"), `{{- if or (eq .Release.Name "prod") (eq .Release.Namespace "kube-system") }}{{ fail "unsupported release" }}{{ end }}
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Release.Name }}
+`)
+	testCases := map[string]struct {
+		settings common.HelmSettings
+		wantErr  bool
+	}{
+		"defaults":           {settings: common.HelmSettings{}},
+		"release_name":       {settings: common.HelmSettings{LintReleaseName: "prod"}, wantErr: true},
+		"namespace":          {settings: common.HelmSettings{LintNamespace: "kube-system"}, wantErr: true},
+		"other_release_name": {settings: common.HelmSettings{LintReleaseName: "staging", LintNamespace: "apps"}},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			tc.settings.LintK8s = "1.30.0"
+			ch, err := loader.Load(chartDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			//when
+			_, err = Lint(testLog(), chartDir, ch, &tc.settings)
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Lint() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewHelmChartFailOnEmptyChart(t *testing.T) {
+	testCases := map[string]struct {
+		failOnEmpty bool
+		crdFiles    []map[string]any
+		wantErr     bool
+	}{
+		"disabled":       {failOnEmpty: false},
+		"enabled":        {failOnEmpty: true, wantErr: true},
+		"crds_dir_chart": {failOnEmpty: true, crdFiles: []map[string]any{{"kind": "CustomResourceDefinition", "metadata": map[string]any{"name": "tests.example.com"}}}},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			emptyManifests, _ := common.NewManifests(&map[string][]byte{}, mustSemver("0.0.1"), "0.0.1", new(map[string]any), new(map[string]any))
+			settings := &common.HelmSettings{SrcDir: t.TempDir(), LintK8s: "1.30.0", DanglingValues: common.DanglingValuesIgnore, FailOnEmptyChart: tc.failOnEmpty}
+
+			//when
+			_, _, err := NewHelmChart(testLog(), "test", emptyManifests, false, tc.crdFiles, settings)
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Errorf("NewHelmChart() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr && !errors.Is(err, ErrChartEmpty) {
+				t.Errorf("NewHelmChart() error = %v, want ErrChartEmpty", err)
+			}
+		})
+	}
+}
+
+func TestCreateTemplatesLayout(t *testing.T) {
+	manifests := []map[string]any{
+		{"kind": "Deployment", "metadata": map[string]any{"name": "operator"}},
+		{"kind": "Service", "metadata": map[string]any{"name": "{{ .Release.Name }}-api"}},
+		{"kind": "Deployment", "metadata": map[string]any{"name": "Web_UI"}},
+		{"kind": "ConfigMap"},
+	}
+	testCases := map[string]struct {
+		layout  string
+		want    []string
+		wantErr bool
+	}{
+		"default": {want: []string{"templates/deployment.yaml", "templates/service.yaml", "templates/configmap.yaml"}},
+		"per_resource": {
+			layout: common.TemplateLayoutPerResource,
+			want:   []string{"templates/deployment-operator.yaml", "templates/service-release.name-api.yaml", "templates/deployment-web-ui.yaml", "templates/configmap-3.yaml"},
+		},
+		"single":  {layout: common.TemplateLayoutSingle, want: []string{"templates/manifests.yaml"}},
+		"unknown": {layout: "per-team", wantErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			ch := &chart.Chart{Metadata: &chart.Metadata{Name: "test"}}
+
+			//when
+			err := createTemplates(testLog(), ch, &manifests, tc.layout, "", nil)
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("createTemplates() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			got := make([]string, 0, len(ch.Templates))
+			for _, tmpl := range ch.Templates {
+				got = append(got, tmpl.Name)
+			}
+			if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("createTemplates() files = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCreateTemplatesDirs(t *testing.T) {
+	manifests := []map[string]any{
+		{"kind": "Deployment", "metadata": map[string]any{"name": "operator"}},
+		{"kind": "ClusterRole", "metadata": map[string]any{"name": "operator"}},
+		{"kind": "ConfigMap"},
+	}
+	testCases := map[string]struct {
+		layout      string
+		chartType   string
+		dirs        common.TemplateDirs
+		want        []string
+		wantDefines []string
+		wantErr     bool
+	}{
+		"per_kind": {
+			dirs: common.TemplateDirs{"clusterrole": "rbac", "Deployment": "workloads/operator/"},
+			want: []string{"templates/workloads/operator/deployment.yaml", "templates/rbac/clusterrole.yaml", "templates/configmap.yaml"},
+		},
+		"single": {
+			layout: common.TemplateLayoutSingle,
+			dirs:   common.TemplateDirs{"ClusterRole": "rbac"},
+			want:   []string{"templates/manifests.yaml", "templates/rbac/manifests.yaml"},
+		},
+		"library": {
+			chartType: common.ChartTypeLibrary,
+			dirs:      common.TemplateDirs{"ClusterRole": "rbac"},
+			want:      []string{"templates/_deployment.tpl", "templates/rbac/_clusterrole.tpl", "templates/_configmap.tpl"},
+		},
+		"library_single": {
+			layout:      common.TemplateLayoutSingle,
+			chartType:   common.ChartTypeLibrary,
+			dirs:        common.TemplateDirs{"ClusterRole": "rbac"},
+			want:        []string{"templates/_manifests.tpl", "templates/rbac/_manifests.tpl"},
+			wantDefines: []string{"test.manifests", "test.rbac.manifests"},
+		},
+		"outside_templates": {dirs: common.TemplateDirs{"ClusterRole": "../rbac"}, wantErr: true},
+		"templates_itself":  {dirs: common.TemplateDirs{"ClusterRole": "./"}, wantErr: true},
+		"duplicate_kind":    {dirs: common.TemplateDirs{"ClusterRole": "rbac", "clusterrole": "roles"}, wantErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			ch := &chart.Chart{Metadata: &chart.Metadata{Name: "test", Type: tc.chartType}}
+
+			//when
+			err := createTemplates(testLog(), ch, &manifests, tc.layout, "", tc.dirs)
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("createTemplates() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			got := make([]string, 0, len(ch.Templates))
+			for _, tmpl := range ch.Templates {
+				got = append(got, tmpl.Name)
+			}
+			if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("createTemplates() files = %v, want %v", got, tc.want)
+			}
+			for i, define := range tc.wantDefines {
+				if !strings.Contains(string(ch.Templates[i].Data), `{{- define "`+define+`" }}`) {
+					t.Errorf("createTemplates() %s doesn't define %s:\n%s", ch.Templates[i].Name, define, ch.Templates[i].Data)
+				}
+			}
+		})
+	}
+}
+
+func TestClearTemplates(t *testing.T) {
+	//given
+	chartPath := filepath.Join(t.TempDir(), "test")
+	files := map[string]string{
+		"templates/deployment.yaml":        "kind: Deployment\n",
+		"templates/rbac/clusterrole.yaml":  "kind: ClusterRole\n",
+		"templates/rbac/_generated.tpl":    generatedPartialHeader,
+		"templates/_configmap.tpl":         "{{- define \"test.configmap\" }}\nkind: ConfigMap\n{{- end }}\n",
+		"templates/rbac/_role.tpl":         "{{- define \"test.role\" }}\nkind: Role\n{{- end }}\n",
+		"templates/_helpers.tpl":           "{{- define \"test.name\" }}test{{- end }}\n",
+		"templates/workloads/_helpers.tpl": "{{- define \"test.labels\" }}{{- end }}\n",
+	}
+	for path, content := range files {
+		writeTestFile(t, filepath.Join(chartPath, path), content)
+	}
+
+	//when
+	err := clearTemplates(chartPath)
+
+	//then
+	if err != nil {
+		t.Fatalf("clearTemplates() error = %v", err)
+	}
+	for path := range files {
+		kept := strings.HasSuffix(path, "_helpers.tpl")
+		if fileExists(filepath.Join(chartPath, path)) != kept {
+			t.Errorf("clearTemplates() %s kept = %v, want %v", path, !kept, kept)
+		}
+	}
+	if fileExists(filepath.Join(chartPath, "templates/rbac")) {
+		t.Errorf("clearTemplates() kept the emptied templates/rbac")
+	}
+}
+
+func TestCreateTemplatesMissingKind(t *testing.T) {
+	manifests := []map[string]any{
+		{"kind": "Deployment", "metadata": map[string]any{"name": "operator"}},
+		{"metadata": map[string]any{"name": "junk"}},
+	}
+	testCases := map[string]struct {
+		missingKind string
+		wantErr     bool
+	}{
+		"default": {wantErr: true},
+		"fail":    {missingKind: common.MissingKindFail, wantErr: true},
+		"skip":    {missingKind: common.MissingKindSkip},
+		"warn":    {missingKind: common.MissingKindWarn},
+		"unknown": {missingKind: "ignore", wantErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			ch := &chart.Chart{Metadata: &chart.Metadata{Name: "test"}}
+
+			//when
+			err := createTemplates(testLog(), ch, &manifests, common.TemplateLayoutSingle, tc.missingKind, nil)
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("createTemplates() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if len(ch.Templates) != 1 || strings.Contains(string(ch.Templates[0].Data), "junk") {
+				t.Errorf("createTemplates() templates = %v, want only the Deployment", ch.Templates)
+			}
+		})
+	}
+}
+
+func TestMarshalManifestKeyOrder(t *testing.T) {
+	//given
+	manifest := map[string]any{
+		"status":     map[string]any{},
+		"spec":       map[string]any{"replicas": 1},
+		"data":       map[string]any{"b": "2", "a": "1"},
+		"metadata":   map[string]any{"name": "operator"},
+		"kind":       "Deployment",
+		"apiVersion": "apps/v1",
+	}
+
+	//when
+	data, err := marshalManifest(manifest)
+
+	//then
+	if err != nil {
+		t.Fatalf("marshalManifest() error = %v", err)
+	}
+	keys := make([]string, 0)
+	for _, line := range strings.Split(string(data), "\n") {
+		if key, _, found := strings.Cut(line, ":"); found && !strings.HasPrefix(line, " ") {
+			keys = append(keys, key)
+		}
+	}
+	want := []string{"apiVersion", "kind", "metadata", "spec", "data", "status"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("marshalManifest() keys = %v, want %v\n%s", keys, want, data)
+	}
+}
+
+func TestNewHelmChartsPreserveCrds(t *testing.T) {
+	crd := func(name, annotations string) string {
+		return "apiVersion: apiextensions.k8s.io/v1\nkind: CustomResourceDefinition\nmetadata:\n  name: " + name + ".example.com\n" + annotations +
+			"spec:\n  group: example.com\n  names:\n    kind: " + name + "\n    plural: " + name + "\n  scope: Namespaced\n" +
+			"  versions:\n    - name: v1\n      served: true\n      storage: true\n      schema:\n        openAPIV3Schema:\n          type: object\n"
+	}
+	testCases := map[string]struct {
+		preserveCrds string
+		wantCrds     []string
+		wantErr      bool
+	}{
+		"none": {
+			preserveCrds: common.PreserveCrdsNone,
+			wantCrds:     []string{"widgets.example.com"},
+		},
+		"all": {
+			preserveCrds: common.PreserveCrdsAll,
+			wantCrds:     []string{"gadgets.example.com", "things.example.com", "widgets.example.com"},
+		},
+		"annotated": {
+			preserveCrds: common.PreserveCrdsAnnotated,
+			wantCrds:     []string{"things.example.com", "widgets.example.com"},
+		},
+		"unknown": {
+			preserveCrds: "some",
+			wantErr:      true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			settings := &common.HelmSettings{SrcDir: t.TempDir(), OutputDir: t.TempDir(), LintK8s: "1.30.0", DanglingValues: common.DanglingValuesIgnore}
+			committed := filepath.Join(settings.SrcDir, "operator-crds", "templates")
+			writeTestFile(t, filepath.Join(committed, "customresourcedefinition.yaml"),
+				crd("widgets", "  labels:\n    committed: \"true\"\n")+"---\n"+crd("gadgets", "")+"---\n"+crd("things", "  annotations:\n    "+PreserveCrdAnnotation+": \"true\"\n"))
+			writeTestFile(t, filepath.Join(committed, "notes.yaml"), "{{- if .Values.enabled }}\nkind: ConfigMap\n{{- end }}\n")
+			upstream, _ := common.ExtractYamls([]byte(crd("widgets", "")))
+			m := &common.Manifests{
+				Crds:         *upstream,
+				Version:      *mustSemver("1.0.0"),
+				AppVersion:   "1.0.0",
+				PreserveCrds: tc.preserveCrds,
+			}
+
+			//when
+			charts, err := NewHelmCharts(testLog(), settings, "operator", common.CrdModeSeparate, m)
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("NewHelmCharts() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			generated, err := committedCrds(testLog(), filepath.Join(settings.OutputDir, charts.CrdChartDir()))
+			if err != nil {
+				t.Fatalf("committedCrds() error = %v", err)
+			}
+			names := make([]string, 0, len(generated))
+			for _, crd := range generated {
+				names = append(names, crdName(crd))
+				if crdName(crd) == "widgets.example.com" && crd["metadata"].(map[string]any)["labels"] != nil {
+					t.Errorf("NewHelmCharts() kept the committed CRD widgets.example.com instead of the upstream one")
+				}
+			}
+			slices.Sort(names)
+			if !slices.Equal(names, tc.wantCrds) {
+				t.Errorf("NewHelmCharts() CRDs = %v, want %v", names, tc.wantCrds)
+			}
+		})
+	}
+}
+
+func TestCommittedCrdsTemplated(t *testing.T) {
+	testCases := map[string]struct {
+		content         string
+		wantAnnotations any
+		wantErr         bool
+	}{
+		"templated": {
+			content: "{{- if .Values.enabled }}\napiVersion: apiextensions.k8s.io/v1\nkind: CustomResourceDefinition\nmetadata:\n" +
+				"  name: widgets.example.com\n  annotations: {{ .Values.annotations | toYaml | nindent 4 }}\n{{- end }}\n",
+			wantAnnotations: "{{ .Values.annotations | toYaml | nindent 4 }}",
+		},
+		"no_yaml": {
+			content: "apiVersion: apiextensions.k8s.io/v1\nkind: CustomResourceDefinition\nmetadata:\n" +
+				"  name: widgets.example.com\n  labels:\n{{ include \"labels\" . | indent 4 }} extra\n  - broken\n",
+			wantErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			chartPath := t.TempDir()
+			writeTestFile(t, filepath.Join(chartPath, "templates", "customresourcedefinition.yaml"), tc.content)
+
+			//when
+			crds, err := committedCrds(testLog(), chartPath)
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("committedCrds() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if len(crds) != 1 || crdName(crds[0]) != "widgets.example.com" {
+				t.Fatalf("committedCrds() = %v, want widgets.example.com", crds)
+			}
+			if annotations := crds[0]["metadata"].(map[string]any)["annotations"]; annotations != tc.wantAnnotations {
+				t.Errorf("committedCrds() annotations = %v, want %v", annotations, tc.wantAnnotations)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package packager
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/krezh/charts/internal/common"
+)
+
+const (
+	hookAnnotation             = "helm.sh/hook"
+	hookWeightAnnotation       = "helm.sh/hook-weight"
+	hookDeletePolicyAnnotation = "helm.sh/hook-delete-policy"
+)
+
+// helmHookModifications derives a modification per configured hook, annotating the matching resources
+func helmHookModifications(hooks []common.HelmHook) ([]common.Modification, error) {
+	mods := make([]common.Modification, 0, len(hooks))
+	for _, hook := range hooks {
+		if hook.Kind == "" || hook.Hook == "" {
+			return nil, fmt.Errorf("helm hook needs kind and hook, got kind '%s' and hook '%s'", hook.Kind, hook.Hook)
+		}
+		assignments := []string{
+			fmt.Sprintf(".metadata.annotations[%q] = %q", hookAnnotation, hook.Hook),
+			fmt.Sprintf(".metadata.annotations[%q] = %q", hookWeightAnnotation, fmt.Sprint(hook.Weight)),
+		}
+		if hook.DeletePolicy != "" {
+			assignments = append(assignments, fmt.Sprintf(".metadata.annotations[%q] = %q", hookDeletePolicyAnnotation, hook.DeletePolicy))
+		}
+		mod := common.Modification{
+			Expression: strings.Join(assignments, " | "),
+			Kind:       "^" + regexp.QuoteMeta(hook.Kind) + "$",
+		}
+		if hook.Name != "" {
+			mod.When = fmt.Sprintf(".metadata.name == %q", hook.Name)
+		}
+		mods = append(mods, mod)
+	}
+	return mods, nil
+}
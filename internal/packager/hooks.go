@@ -0,0 +1,35 @@
+package packager
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/krezh/charts/internal/common"
+	"github.com/sirupsen/logrus"
+)
+
+// runPostGenerateHooks executes configured hooks against a generated chart,
+// hook output is streamed to the logger, first failing hook aborts,
+// the chart path is passed as positional parameter so no path is ever parsed by the shell
+func runPostGenerateHooks(log *logrus.Entry, chartPath string, hooks []string) error {
+	for _, hook := range hooks {
+		command := strings.ReplaceAll(hook, common.ChartPathPlaceholder, `"$1"`)
+		log.Infof("Running post-generate hook: %s", strings.ReplaceAll(hook, common.ChartPathPlaceholder, chartPath))
+
+		stdout := log.WriterLevel(logrus.InfoLevel)
+		stderr := log.WriterLevel(logrus.WarnLevel)
+		cmd := exec.Command("sh", "-c", command, "sh", chartPath)
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		err := cmd.Run()
+		_ = stdout.Close()
+		_ = stderr.Close()
+		if err != nil {
+			log.Errorf("Post-generate hook '%s' failed for chart %s: %v", hook, chartPath, err)
+			return fmt.Errorf("post-generate hook '%s' failed for chart %s: %w", hook, chartPath, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package packager
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunPostGenerateHooks(t *testing.T) {
+	testCases := map[string]struct {
+		hooks   []string
+		wantErr bool
+	}{
+		"none": {
+			hooks: []string{},
+		},
+		"succeeding": {
+			hooks: []string{"test -d {chartPath}", "echo validated {chartPath}"},
+		},
+		"failing": {
+			hooks:   []string{"true", "exit 3", "true"},
+			wantErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//when
+			err := runPostGenerateHooks(testLog(), t.TempDir(), tc.hooks)
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Errorf("runPostGenerateHooks() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunPostGenerateHooksSubstitutesChartPath(t *testing.T) {
+	//given
+	chartPath := t.TempDir()
+
+	//when
+	err := runPostGenerateHooks(testLog(), chartPath, []string{"touch {chartPath}/hooked"})
+
+	//then
+	if err != nil {
+		t.Fatalf("runPostGenerateHooks() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(chartPath, "hooked")); err != nil {
+		t.Errorf("runPostGenerateHooks() did not run against chart path: %v", err)
+	}
+}
+
+func TestRunPostGenerateHooksQuotesChartPath(t *testing.T) {
+	//given
+	chartPath := filepath.Join(t.TempDir(), "my chart $(touch injected)")
+	if err := os.Mkdir(chartPath, 0755); err != nil {
+		t.Fatalf("failed to create chart dir: %v", err)
+	}
+
+	//when
+	err := runPostGenerateHooks(testLog(), chartPath, []string{"touch {chartPath}/hooked"})
+
+	//then
+	if err != nil {
+		t.Fatalf("runPostGenerateHooks() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(chartPath, "hooked")); err != nil {
+		t.Errorf("runPostGenerateHooks() did not run against the chart path with spaces: %v", err)
+	}
+	if _, err := os.Stat("injected"); err == nil {
+		_ = os.Remove("injected")
+		t.Errorf("runPostGenerateHooks() let the shell evaluate the chart path")
+	}
+}
+
+func TestRunPostGenerateHooksReportsHook(t *testing.T) {
+	//given
+	chartPath := t.TempDir()
+	hook := "test -f {chartPath}/missing"
+
+	//when
+	err := runPostGenerateHooks(testLog(), chartPath, []string{hook})
+
+	//then
+	if err == nil {
+		t.Fatalf("runPostGenerateHooks() expected error for failing hook")
+	}
+	if !strings.Contains(err.Error(), hook) || !strings.Contains(err.Error(), chartPath) {
+		t.Errorf("runPostGenerateHooks() error = %v, want hook %s and chart %s", err, hook, chartPath)
+	}
+}
@@ -0,0 +1,241 @@
+package packager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/krezh/charts/internal/common"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultRegistry = "docker.io"
+	defaultTag      = "latest"
+	// a digest pins the image, a tag alongside it is informational
+	imageTemplate      = "{{ .Values.image.registry }}/{{ .Values.image.repository }}{{ with .Values.image.tag }}:{{ . }}{{ end }}{{ with .Values.image.digest }}@{{ . }}{{ end }}"
+	pullPolicyTemplate = "{{ .Values.image.pullPolicy }}"
+	// the per-container counterparts of imageTemplate and pullPolicyTemplate, formatted with the index keys below images
+	containerImageTemplate      = "{{ with index .Values.images %s }}{{ .registry }}/{{ .repository }}{{ with .tag }}:{{ . }}{{ end }}{{ with .digest }}@{{ . }}{{ end }}{{ end }}"
+	containerPullPolicyTemplate = "{{ (index .Values.images %s).pullPolicy }}"
+	ImagesFileName              = "images.txt"
+)
+
+var (
+	// podSpecPaths lists where workload kinds keep their pod spec
+	podSpecPaths = map[string][]string{
+		"Deployment":  {"spec", "template", "spec"},
+		"DaemonSet":   {"spec", "template", "spec"},
+		"StatefulSet": {"spec", "template", "spec"},
+		"ReplicaSet":  {"spec", "template", "spec"},
+		"Job":         {"spec", "template", "spec"},
+		"CronJob":     {"spec", "jobTemplate", "spec", "template", "spec"},
+	}
+)
+
+type imageRef struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// parseImage splits an image reference into registry, repository, tag and digest,
+// a first segment without '.' or ':' (and not localhost) belongs to the repository,
+// digest-pinned images keep the tag they carry but never get the default tag
+func parseImage(image string) (*imageRef, error) {
+	ref := &imageRef{Registry: defaultRegistry, Tag: defaultTag}
+	rest := image
+	if name, digest, found := strings.Cut(image, "@"); found {
+		algorithm, hash, _ := strings.Cut(digest, ":")
+		if algorithm == "" || hash == "" {
+			return nil, fmt.Errorf("image %s has an invalid digest", image)
+		}
+		ref.Digest = digest
+		ref.Tag = ""
+		rest = name
+	}
+	image = rest
+	if first, remainder, found := strings.Cut(image, "/"); found &&
+		(strings.ContainsAny(first, ".:") || first == "localhost") {
+		ref.Registry = first
+		rest = remainder
+	}
+	if i := strings.LastIndex(rest, ":"); i > strings.LastIndex(rest, "/") {
+		ref.Tag = rest[i+1:]
+		rest = rest[:i]
+	}
+	if rest == "" {
+		return nil, fmt.Errorf("image %s has no repository", image)
+	}
+	ref.Repository = rest
+	return ref, nil
+}
+
+// ParametrizeImages replaces the image of every container in workload manifests with
+// image.registry/image.repository:image.tag@image.digest values and the imagePullPolicy of containers setting one
+// with the image.pullPolicy value, the upstream image and pull policy become the default values, containers
+// without a pull policy keep the Kubernetes default, charts with more than one image key the values by container
+// as images.<container>, nested by workload like resources when same-named containers differ
+func (m *modifier) ParametrizeImages(manifests *common.Manifests) (*common.Manifests, error) {
+	found := make([]containerValue, 0)
+	for _, manifest := range manifests.Manifests {
+		metadata, _ := manifest["metadata"].(map[string]any)
+		workload, _ := metadata["name"].(string)
+		for _, container := range workloadContainers(manifest) {
+			image, ok := container["image"].(string)
+			if !ok || strings.Contains(image, "{{") {
+				continue // already templated by a modification
+			}
+			ref, err := parseImage(image)
+			if err != nil {
+				return nil, err
+			}
+			values := map[string]any{
+				"registry":   ref.Registry,
+				"repository": ref.Repository,
+				"tag":        ref.Tag,
+				"digest":     ref.Digest,
+			}
+			if policy, ok := container["imagePullPolicy"].(string); ok && policy != "" {
+				values["pullPolicy"] = policy
+			}
+			name, _ := container["name"].(string)
+			found = append(found, containerValue{kind: manifest[common.Kind], workload: workload, name: name, container: container, value: values})
+		}
+	}
+	if len(found) == 0 {
+		m.logger().Infof("No workload images found to parametrize")
+		return manifests, nil
+	}
+
+	var imageValues map[string]any
+	if image, ok := sharedImage(found); ok {
+		imageValues = map[string]any{"image": image}
+		for _, c := range found {
+			setImageTemplates(c.container, imageTemplate, pullPolicyTemplate)
+			m.logger().Debugf("Parametrized image of %v container %s", c.kind, c.name)
+		}
+	} else {
+		extracted, paths, err := keyContainerValues(found)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parametrize images, %w", err)
+		}
+		imageValues = map[string]any{"images": extracted}
+		for i, c := range found {
+			keys := indexKeys(paths[i])
+			setImageTemplates(c.container, fmt.Sprintf(containerImageTemplate, keys), fmt.Sprintf(containerPullPolicyTemplate, keys))
+			m.logger().Debugf("Parametrized image of %v container %s as images.%s", c.kind, c.name, strings.Join(paths[i], "."))
+		}
+	}
+
+	return &common.Manifests{
+		Crds:         manifests.Crds,
+		Manifests:    manifests.Manifests,
+		Version:      manifests.Version,
+		AppVersion:   manifests.AppVersion,
+		Values:       *common.DeepMerge(&manifests.Values, &imageValues),
+		CrdsValues:   manifests.CrdsValues,
+		ValuesSchema: manifests.ValuesSchema,
+		ChartType:    manifests.ChartType,
+	}, nil
+}
+
+// sharedImage returns the image values of found when all containers run the same image and those setting a pull
+// policy agree on it
+func sharedImage(found []containerValue) (map[string]any, bool) {
+	shared := make(map[string]any)
+	for _, c := range found {
+		for key, value := range c.value.(map[string]any) {
+			if existing, ok := shared[key]; ok && existing != value {
+				return nil, false
+			}
+			shared[key] = value
+		}
+	}
+	return shared, true
+}
+
+func setImageTemplates(container map[string]any, image, pullPolicy string) {
+	container["image"] = image
+	if _, ok := container["imagePullPolicy"]; ok {
+		container["imagePullPolicy"] = pullPolicy
+	}
+}
+
+// workloadContainers returns containers and initContainers of a workload manifest
+func workloadContainers(manifest map[string]any) []map[string]any {
+	kind, _ := manifest[common.Kind].(string)
+	path, ok := podSpecPaths[kind]
+	if !ok {
+		return nil
+	}
+	var node any = manifest
+	for _, key := range path {
+		obj, ok := node.(map[string]any)
+		if !ok {
+			return nil
+		}
+		node = obj[key]
+	}
+	podSpec, ok := node.(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	containers := make([]map[string]any, 0)
+	for _, key := range []string{"initContainers", "containers"} {
+		list, _ := podSpec[key].([]any)
+		for _, c := range list {
+			if container, ok := c.(map[string]any); ok {
+				containers = append(containers, container)
+			}
+		}
+	}
+	return containers
+}
+
+// ReferencedImages collects the distinct values of every image field in manifests, sorted,
+// templated references are recorded as-is
+func ReferencedImages(manifests []map[string]any) []string {
+	images := make([]string, 0)
+	for _, manifest := range manifests {
+		images = appendImages(images, manifest)
+	}
+	slices.Sort(images)
+	return slices.Compact(images)
+}
+
+func appendImages(images []string, node any) []string {
+	switch n := node.(type) {
+	case map[string]any:
+		for key, value := range n {
+			if image, ok := value.(string); ok && key == "image" && image != "" {
+				images = append(images, image)
+				continue
+			}
+			images = appendImages(images, value)
+		}
+	case []any:
+		for _, item := range n {
+			images = appendImages(images, item)
+		}
+	}
+	return images
+}
+
+// writeImageList writes one image per line to ImagesFileName in the chart directory
+func writeImageList(log *logrus.Entry, chartPath string, images []string) error {
+	path := filepath.Join(chartPath, ImagesFileName)
+	content := ""
+	if len(images) > 0 {
+		content = strings.Join(images, "\n") + "\n"
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		log.Errorf("Failed to write image list %s: %v", path, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package packager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/krezh/charts/internal/common"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+)
+
+func TestParseImage(t *testing.T) {
+	testCases := map[string]struct {
+		image   string
+		want    imageRef
+		wantErr bool
+	}{
+		"full": {
+			image: "quay.io/kubevirt/virt-operator:v1.5.2",
+			want:  imageRef{Registry: "quay.io", Repository: "kubevirt/virt-operator", Tag: "v1.5.2"},
+		},
+		"registry_with_port": {
+			image: "registry.local:5000/operator:1.0",
+			want:  imageRef{Registry: "registry.local:5000", Repository: "operator", Tag: "1.0"},
+		},
+		"docker_hub": {
+			image: "library/nginx:1.27",
+			want:  imageRef{Registry: "docker.io", Repository: "library/nginx", Tag: "1.27"},
+		},
+		"no_tag": {
+			image: "nginx",
+			want:  imageRef{Registry: "docker.io", Repository: "nginx", Tag: "latest"},
+		},
+		"digest": {
+			image: "quay.io/kubevirt/virt-operator@sha256:abcd",
+			want:  imageRef{Registry: "quay.io", Repository: "kubevirt/virt-operator", Digest: "sha256:abcd"},
+		},
+		"tag_and_digest": {
+			image: "localhost/operator:1.0@sha256:abcd",
+			want:  imageRef{Registry: "localhost", Repository: "operator", Tag: "1.0", Digest: "sha256:abcd"},
+		},
+		"invalid_digest": {
+			image:   "quay.io/kubevirt/virt-operator@abcd",
+			wantErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//when
+			got, err := parseImage(tc.image)
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseImage() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if err == nil && *got != tc.want {
+				t.Errorf("parseImage() = %+v, want %+v", *got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParametrizeImages(t *testing.T) {
+	//given
+	assets := readTestData(t)
+	delete(*assets, "cdi-operator.yaml")
+	delete(*assets, "cdi-cr.yaml")
+	testManifests, _ := common.NewManifests(assets, mustSemver("0.0.1"), "0.0.1", new(map[string]any), new(map[string]any))
+
+	//when
+	modified, err := ChartModifier.ParametrizeImages(testManifests)
+
+	//then
+	if err != nil {
+		t.Fatalf("ParametrizeImages() error = %v", err)
+	}
+	expectedValues := map[string]any{
+		"image": map[string]any{
+			"registry":   "quay.io",
+			"repository": "kubevirt/virt-operator",
+			"tag":        "v1.5.2",
+			"digest":     "",
+			"pullPolicy": "IfNotPresent",
+		},
+	}
+	if !mapContains(&modified.Values, &expectedValues, true) {
+		t.Errorf("ParametrizeImages() values = %v, want %v", modified.Values, expectedValues)
+	}
+	for _, m := range modified.Manifests {
+		for _, container := range workloadContainers(m) {
+			if container["image"] != imageTemplate {
+				t.Errorf("ParametrizeImages() image = %v, want %v", container["image"], imageTemplate)
+			}
+			if container["imagePullPolicy"] != pullPolicyTemplate {
+				t.Errorf("ParametrizeImages() imagePullPolicy = %v, want %v", container["imagePullPolicy"], pullPolicyTemplate)
+			}
+		}
+	}
+}
+
+func TestParametrizeImagesWithoutPullPolicy(t *testing.T) {
+	//given
+	manifests := &common.Manifests{Manifests: []map[string]any{
+		{"kind": "Deployment", "spec": map[string]any{"template": map[string]any{"spec": map[string]any{
+			"containers": []any{map[string]any{"name": "operator", "image": "quay.io/kubevirt/virt-operator:v1.5.2"}},
+		}}}},
+	}}
+
+	//when
+	modified, err := ChartModifier.ParametrizeImages(manifests)
+
+	//then
+	if err != nil {
+		t.Fatalf("ParametrizeImages() error = %v", err)
+	}
+	container := workloadContainers(modified.Manifests[0])[0]
+	if _, ok := container["imagePullPolicy"]; ok {
+		t.Errorf("ParametrizeImages() imagePullPolicy = %v, want none like upstream", container["imagePullPolicy"])
+	}
+	if _, ok := modified.Values["image"].(map[string]any)["pullPolicy"]; ok {
+		t.Errorf("ParametrizeImages() values = %v, want no pullPolicy", modified.Values)
+	}
+}
+
+func TestParametrizeImagesPerContainer(t *testing.T) {
+	//given
+	testManifests, _ := common.NewManifests(readTestData(t), mustSemver("0.0.1"), "0.0.1", new(map[string]any), new(map[string]any))
+	settings := &common.HelmSettings{SrcDir: t.TempDir(), LintK8s: "1.30.0", DanglingValues: common.DanglingValuesFail}
+
+	//when
+	modified, err := ChartModifier.ParametrizeImages(testManifests)
+	if err != nil {
+		t.Fatalf("ParametrizeImages() error = %v", err)
+	}
+	charts, err := NewHelmCharts(testLog(), settings, "test", common.CrdModeSeparate, modified)
+
+	//then
+	if err != nil {
+		t.Fatalf("NewHelmCharts() error = %v", err)
+	}
+	values, _ := chartutil.ToRenderValues(charts.Chart, map[string]any{}, chartutil.ReleaseOptions{}, nil)
+	rendered, err := engine.Render(charts.Chart, values)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	renderedImages := make([]string, 0)
+	for _, manifest := range rendered {
+		for _, line := range strings.Split(manifest, "\n") {
+			if image, ok := strings.CutPrefix(strings.TrimSpace(line), "image: "); ok {
+				renderedImages = append(renderedImages, image)
+			}
+		}
+	}
+	slices.Sort(renderedImages)
+	if want := []string{"quay.io/kubevirt/cdi-operator:v1.63.0", "quay.io/kubevirt/virt-operator:v1.5.2"}; !reflect.DeepEqual(renderedImages, want) {
+		t.Errorf("Render() images = %v, want %v", renderedImages, want)
+	}
+	expectedValues := map[string]any{
+		"images": map[string]any{
+			"virt-operator": map[string]any{
+				"registry": "quay.io", "repository": "kubevirt/virt-operator", "tag": "v1.5.2", "digest": "", "pullPolicy": "IfNotPresent",
+			},
+			"cdi-operator": map[string]any{
+				"registry": "quay.io", "repository": "kubevirt/cdi-operator", "tag": "v1.63.0", "digest": "", "pullPolicy": "IfNotPresent",
+			},
+		},
+	}
+	if !mapContains(&modified.Values, &expectedValues, true) {
+		t.Errorf("ParametrizeImages() values = %v, want %v", modified.Values["images"], expectedValues)
+	}
+	if _, ok := modified.Values["image"]; ok {
+		t.Errorf("ParametrizeImages() values = %v, want no shared image", modified.Values["image"])
+	}
+	for _, m := range modified.Manifests {
+		for _, container := range workloadContainers(m) {
+			keys := indexKeys([]string{container["name"].(string)})
+			if want := fmt.Sprintf(containerImageTemplate, keys); container["image"] != want {
+				t.Errorf("ParametrizeImages() image = %v, want %v", container["image"], want)
+			}
+			if want := fmt.Sprintf(containerPullPolicyTemplate, keys); container["imagePullPolicy"] != want {
+				t.Errorf("ParametrizeImages() imagePullPolicy = %v, want %v", container["imagePullPolicy"], want)
+			}
+		}
+	}
+}
+
+func TestReferencedImages(t *testing.T) {
+	//given
+	manifests := []map[string]any{
+		{"kind": "Deployment", "spec": map[string]any{"template": map[string]any{"spec": map[string]any{
+			"initContainers": []any{map[string]any{"name": "init", "image": "quay.io/kubevirt/init:v1"}},
+			"containers":     []any{map[string]any{"name": "operator", "image": imageTemplate}},
+		}}}},
+		{"kind": "KubeVirt", "spec": map[string]any{"image": "quay.io/kubevirt/init:v1", "config": map[string]any{"image": map[string]any{"tag": "v1"}}}},
+		{"kind": "ConfigMap", "data": map[string]any{"key": "value"}},
+	}
+
+	//when
+	images := ReferencedImages(manifests)
+
+	//then
+	want := []string{"quay.io/kubevirt/init:v1", imageTemplate}
+	if !reflect.DeepEqual(images, want) {
+		t.Errorf("ReferencedImages() = %v, want %v", images, want)
+	}
+}
+
+func TestNewHelmChartsWritesImageList(t *testing.T) {
+	//given
+	testManifests, _ := common.NewManifests(readTestData(t), mustSemver("0.0.1"), "0.0.1", new(map[string]any), new(map[string]any))
+	settings := &common.HelmSettings{SrcDir: t.TempDir(), LintK8s: "1.30.0", DanglingValues: common.DanglingValuesIgnore, WriteImageList: true}
+
+	//when
+	charts, err := NewHelmCharts(testLog(), settings, "test", common.CrdModeDrop, testManifests)
+
+	//then
+	if err != nil {
+		t.Fatalf("NewHelmCharts() error = %v", err)
+	}
+	if len(charts.Images) == 0 {
+		t.Fatalf("NewHelmCharts() collected no images")
+	}
+	content, err := os.ReadFile(filepath.Join(settings.SrcDir, "test", ImagesFileName))
+	if err != nil {
+		t.Fatalf("NewHelmCharts() image list: %v", err)
+	}
+	if got := strings.Split(strings.TrimSpace(string(content)), "\n"); !reflect.DeepEqual(got, charts.Images) {
+		t.Errorf("NewHelmCharts() image list = %v, want %v", got, charts.Images)
+	}
+}
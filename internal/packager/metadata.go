@@ -0,0 +1,134 @@
+package packager
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/krezh/charts/internal/common"
+)
+
+// NormalizeMetadata cleans up labels and annotations of every resource and of workload pod templates:
+// keys and label values are trimmed, non-string values become strings, keys equal after trimming collapse
+// into one and annotations whose key fully matches a stripAnnotations regex are removed, templated
+// annotations added by modifications are kept, keys are written sorted like every map of a manifest
+func (m *modifier) NormalizeMetadata(manifests *common.Manifests, stripAnnotations []string) (*common.Manifests, error) {
+	stripped := make([]*regexp.Regexp, 0, len(stripAnnotations))
+	for _, pattern := range stripAnnotations {
+		rc, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			m.logger().Errorf("Failed to compile annotation regex '%s': %v", pattern, err)
+			return nil, err
+		}
+		stripped = append(stripped, rc)
+	}
+
+	for _, manifest := range slices.Concat(manifests.Manifests, manifests.Crds) {
+		for _, metadata := range metadataOf(manifest) {
+			normalizeLabels(metadata, "labels", true, nil)
+			normalizeLabels(metadata, "annotations", false, func(key string, value string) bool {
+				if strings.Contains(value, "{{") {
+					return false // added by a modification
+				}
+				for _, rc := range stripped {
+					if rc.MatchString(key) {
+						m.logger().Debugf("Stripping annotation %s of %v %v", key, manifest[common.Kind], metadata["name"])
+						return true
+					}
+				}
+				return false
+			})
+		}
+	}
+	return manifests, nil
+}
+
+// metadataOf returns the metadata of a manifest and, for workloads, of its pod template
+func metadataOf(manifest map[string]any) []map[string]any {
+	metadatas := make([]map[string]any, 0, 2)
+	if metadata, ok := manifest["metadata"].(map[string]any); ok {
+		metadatas = append(metadatas, metadata)
+	}
+	kind, _ := manifest[common.Kind].(string)
+	path, ok := podSpecPaths[kind]
+	if !ok {
+		return metadatas
+	}
+	// the pod template holds the pod spec and its metadata
+	var node any = manifest
+	for _, key := range path[:len(path)-1] {
+		obj, ok := node.(map[string]any)
+		if !ok {
+			return metadatas
+		}
+		node = obj[key]
+	}
+	if template, ok := node.(map[string]any); ok {
+		if metadata, ok := template["metadata"].(map[string]any); ok {
+			metadatas = append(metadatas, metadata)
+		}
+	}
+	return metadatas
+}
+
+// normalizeLabels rewrites the labels or annotations map under key of metadata, values are trimmed with trimValues,
+// entries for which strip returns true are dropped, a map left empty is removed
+func normalizeLabels(metadata map[string]any, key string, trimValues bool, strip func(key, value string) bool) {
+	entries, ok := metadata[key].(map[string]any)
+	if !ok {
+		return
+	}
+	// keys already trimmed win over those collapsing into them
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		if trimmedA, trimmedB := a == strings.TrimSpace(a), b == strings.TrimSpace(b); trimmedA != trimmedB {
+			if trimmedA {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+
+	normalized := make(map[string]any, len(entries))
+	for _, k := range keys {
+		name := strings.TrimSpace(k)
+		if _, exists := normalized[name]; exists || name == "" {
+			continue
+		}
+		switch entries[k].(type) {
+		case map[string]any, []any:
+			normalized[name] = entries[k] // not a valid label or annotation, left for lint to report
+			continue
+		}
+		value := stringValue(entries[k])
+		if trimValues {
+			value = strings.TrimSpace(value)
+		}
+		if strip != nil && strip(name, value) {
+			continue
+		}
+		normalized[name] = value
+	}
+	if len(normalized) == 0 {
+		delete(metadata, key)
+		return
+	}
+	metadata[key] = normalized
+}
+
+// stringValue returns the value as the string Kubernetes expects, e.g. an unquoted version: 1.0 parses as a number
+func stringValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,78 @@
+package packager
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/krezh/charts/internal/common"
+)
+
+func TestNormalizeMetadata(t *testing.T) {
+	//given
+	manifests := &common.Manifests{
+		Manifests: []map[string]any{
+			{
+				"kind": "Deployment",
+				"metadata": map[string]any{
+					"name":   "operator",
+					"labels": map[string]any{"version": 1.5, " app ": "operator ", "app": "operator", "enabled": true},
+					"annotations": map[string]any{
+						"kubectl.kubernetes.io/last-applied-configuration": "{}\n",
+						"deployment.kubernetes.io/revision":                "3",
+						"description":                                      "  the operator\n",
+					},
+				},
+				"spec": map[string]any{
+					"template": map[string]any{
+						"metadata": map[string]any{
+							"annotations": map[string]any{
+								"checksum/config": "abc",
+								"checksum/secret": "{{ include \"secret\" . | sha256sum }}",
+							},
+						},
+						"spec": map[string]any{},
+					},
+				},
+			},
+			{
+				"kind":     "Service",
+				"metadata": map[string]any{"name": "operator", "annotations": map[string]any{"checksum/config": "abc"}},
+			},
+		},
+	}
+
+	//when
+	normalized, err := ChartModifier.NormalizeMetadata(manifests, common.DefaultStripAnnotations)
+
+	//then
+	if err != nil {
+		t.Fatalf("NormalizeMetadata() error = %v", err)
+	}
+	metadata := normalized.Manifests[0]["metadata"].(map[string]any)
+	wantLabels := map[string]any{"app": "operator", "version": "1.5", "enabled": "true"}
+	if !reflect.DeepEqual(metadata["labels"], wantLabels) {
+		t.Errorf("NormalizeMetadata() labels = %v, want %v", metadata["labels"], wantLabels)
+	}
+	wantAnnotations := map[string]any{"description": "  the operator\n"}
+	if !reflect.DeepEqual(metadata["annotations"], wantAnnotations) {
+		t.Errorf("NormalizeMetadata() annotations = %v, want %v", metadata["annotations"], wantAnnotations)
+	}
+	template := normalized.Manifests[0]["spec"].(map[string]any)["template"].(map[string]any)["metadata"].(map[string]any)
+	wantTemplateAnnotations := map[string]any{"checksum/secret": "{{ include \"secret\" . | sha256sum }}"}
+	if !reflect.DeepEqual(template["annotations"], wantTemplateAnnotations) {
+		t.Errorf("NormalizeMetadata() pod template annotations = %v, want %v", template["annotations"], wantTemplateAnnotations)
+	}
+	if _, ok := normalized.Manifests[1]["metadata"].(map[string]any)["annotations"]; ok {
+		t.Errorf("NormalizeMetadata() kept the annotations of the Service emptied by stripping")
+	}
+}
+
+func TestNormalizeMetadataInvalidRegex(t *testing.T) {
+	//when
+	_, err := ChartModifier.NormalizeMetadata(&common.Manifests{}, []string{"checksum/("})
+
+	//then
+	if err == nil {
+		t.Errorf("NormalizeMetadata() expected error for an invalid stripAnnotations regex")
+	}
+}
@@ -0,0 +1,56 @@
+package packager
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/krezh/charts/internal/common"
+)
+
+// clusterScopedKinds are the built-in Kubernetes kinds without a namespace
+var clusterScopedKinds = []string{
+	"APIService",
+	"CertificateSigningRequest",
+	"ClusterRole",
+	"ClusterRoleBinding",
+	"ComponentStatus",
+	"CSIDriver",
+	"CSINode",
+	"CustomResourceDefinition",
+	"FlowSchema",
+	"IngressClass",
+	"MutatingWebhookConfiguration",
+	"Namespace",
+	"Node",
+	"PersistentVolume",
+	"PodSecurityPolicy",
+	"PriorityClass",
+	"PriorityLevelConfiguration",
+	"RuntimeClass",
+	"StorageClass",
+	"ValidatingAdmissionPolicy",
+	"ValidatingAdmissionPolicyBinding",
+	"ValidatingWebhookConfiguration",
+	"VolumeAttachment",
+}
+
+// namespaceModification templates .metadata.namespace of every namespaced resource as the release namespace,
+// custom resources are cluster-scoped when the release ships their CRD with scope Cluster
+func namespaceModification(crds []map[string]any) common.Modification {
+	kinds := slices.Clone(clusterScopedKinds)
+	for _, crd := range crds {
+		spec, _ := crd["spec"].(map[string]any)
+		names, _ := spec["names"].(map[string]any)
+		if kind, ok := names["kind"].(string); ok && spec["scope"] == "Cluster" && !slices.Contains(kinds, kind) {
+			kinds = append(kinds, kind)
+		}
+	}
+	for i, kind := range kinds {
+		kinds[i] = regexp.QuoteMeta(kind)
+	}
+	return common.Modification{
+		Expression: `.metadata.namespace |= "{{ .Release.Namespace }}"`,
+		Reject:     "^(" + strings.Join(kinds, "|") + ")$",
+	}
+}
@@ -2,15 +2,20 @@ package packager
 
 import (
 	"bytes"
+	"cmp"
 	"container/list"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/krezh/charts/internal/common"
 	ghup "github.com/krezh/charts/internal/updater/github"
 	"github.com/mikefarah/yq/v4/pkg/yqlib"
+	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
 )
 
@@ -22,6 +27,7 @@ type modifier struct {
 	encoder   yqlib.Encoder
 	decoder   yqlib.Decoder
 	evaluator yqlib.Evaluator
+	log       *logrus.Entry // release specific logger, common.Log when unset
 }
 
 func newModifier() *modifier {
@@ -36,33 +42,84 @@ func newModifier() *modifier {
 	}
 }
 
-func (m *modifier) FilterManifests(manifests *common.Manifests, denyKindFilter []string) *common.Manifests {
-	filteredManifests := make([]map[string]any, 0)
+// withLog returns a copy of the modifier logging to log
+func (m *modifier) withLog(log *logrus.Entry) *modifier {
+	withLog := *m
+	withLog.log = log
+	return &withLog
+}
+
+func (m *modifier) logger() *logrus.Entry {
+	if m.log == nil {
+		return logrus.NewEntry(common.Log)
+	}
+	return m.log
+}
+
+// FilterManifests drops manifests whose kind is listed in denyKindFilter
+// or whose metadata.name matches any regex in denyNameFilter
+func (m *modifier) FilterManifests(manifests *common.Manifests, denyKindFilter []string, denyNameFilter []string) (*common.Manifests, error) {
 	deniedKinds := make(map[string]bool)
 	for _, filter := range denyKindFilter {
 		deniedKinds[strings.ToLower(filter)] = true
 	}
+	deniedNames := make([]*regexp.Regexp, 0, len(denyNameFilter))
+	for _, filter := range denyNameFilter {
+		rc, err := regexp.Compile(filter)
+		if err != nil {
+			m.logger().Errorf("Failed to compile name regex '%s': %v", filter, err)
+			return nil, err
+		}
+		deniedNames = append(deniedNames, rc)
+	}
 
-	for _, m := range (*manifests).Manifests {
-		if kind, ok := m["kind"].(string); ok && deniedKinds[strings.ToLower(kind)] {
+	filteredManifests := make([]map[string]any, 0)
+	for _, manifest := range (*manifests).Manifests {
+		if kind, ok := manifest[common.Kind].(string); ok && deniedKinds[strings.ToLower(kind)] {
+			continue
+		}
+		if m.nameDenied(manifest, deniedNames) {
 			continue
 		}
-		filteredManifests = append(filteredManifests, m)
+		filteredManifests = append(filteredManifests, manifest)
+	}
+	filteredCrds := make([]map[string]any, 0)
+	for _, crd := range manifests.Crds {
+		if m.nameDenied(crd, deniedNames) {
+			continue
+		}
+		filteredCrds = append(filteredCrds, crd)
 	}
 
 	return &common.Manifests{
-		Crds:       manifests.Crds,
+		Crds:       filteredCrds,
 		Manifests:  filteredManifests,
 		Version:    manifests.Version,
 		AppVersion: manifests.AppVersion,
 		Values:     manifests.Values,
 		CrdsValues: manifests.CrdsValues,
+	}, nil
+}
+
+func (m *modifier) nameDenied(manifest map[string]any, deniedNames []*regexp.Regexp) bool {
+	metadata, _ := manifest["metadata"].(map[string]any)
+	name, ok := metadata["name"].(string)
+	if !ok {
+		return false
+	}
+	for _, rc := range deniedNames {
+		if rc.MatchString(name) {
+			m.logger().Infof("Excluding %v '%s' matching name rule '%s'", manifest[common.Kind], name, rc)
+			return true
+		}
 	}
+	return false
 }
 
-// ParametrizeManifests applies modifications to manifests
+// ParametrizeManifests applies modifications to manifests in ascending priority,
 // returns modified manifests and extracted values
 func (m *modifier) ParametrizeManifests(manifests *common.Manifests, mods *[]common.Modification) (*common.Manifests, error) {
+	mods = byPriority(mods)
 	modifiedManifests := make([]map[string]any, 0)
 	modifiedCrds := make([]map[string]any, 0)
 	extractedValues := manifests.Values
@@ -96,26 +153,48 @@ func (m *modifier) ParametrizeManifests(manifests *common.Manifests, mods *[]com
 	}, nil
 }
 
+// PreviewParametrization applies mods to manifests without generating a chart or touching git,
+// the returned manifests carry the modified resources and the extracted Values and CrdsValues.
+// The input manifests are left unchanged, tooling can call it repeatedly to preview modifications.
+func PreviewParametrization(manifests *common.Manifests, mods *[]common.Modification) (*common.Manifests, error) {
+	if manifests == nil {
+		return nil, fmt.Errorf("no manifests to parametrize")
+	}
+	if mods == nil {
+		mods = &[]common.Modification{}
+	}
+	return ChartModifier.ParametrizeManifests(manifests, mods)
+}
+
+// byPriority returns the modifications stably sorted by ascending priority, the input is left untouched
+func byPriority(mods *[]common.Modification) *[]common.Modification {
+	sorted := slices.Clone(*mods)
+	slices.SortStableFunc(sorted, func(a, b common.Modification) int {
+		return cmp.Compare(a.Priority, b.Priority)
+	})
+	return &sorted
+}
+
 func (m *modifier) applyModifications(manifest *map[string]any, mods *[]common.Modification) (*map[string]any, *map[string]any, error) {
-	common.Log.Debugf("Applying %d modifications to manifest of kind: %v", len(*mods), (*manifest)[common.Kind])
-	common.Log.Tracef("Original manifest:\n%+v", manifest)
+	m.logger().Debugf("Applying %d modifications to manifest of kind: %v", len(*mods), (*manifest)[common.Kind])
+	m.logger().Tracef("Original manifest:\n%+v", manifest)
 
 	modifiedManifest := *manifest
 	extractedValues := make(map[string]any)
 
 	yamlBytes, err := yaml.Marshal(manifest)
 	if err != nil {
-		common.Log.Errorf("Failed to marshal manifest to YAML during applying modifications: %v", err)
+		m.logger().Errorf("Failed to marshal manifest to YAML during applying modifications: %v", err)
 		return nil, nil, err
 	}
 	err = m.decoder.Init(bytes.NewReader(yamlBytes))
 	if err != nil {
-		common.Log.Errorf("Failed to initialize decoder for manifest: %v", err)
+		m.logger().Errorf("Failed to initialize decoder for manifest: %v", err)
 		return nil, nil, err
 	}
 	candidNode, err := m.decoder.Decode()
 	if err != nil {
-		common.Log.Errorf("Failed to decode manifest to yaml node: %v", err)
+		m.logger().Errorf("Failed to decode manifest to yaml node: %v", err)
 		return nil, nil, err
 	}
 
@@ -123,7 +202,7 @@ func (m *modifier) applyModifications(manifest *map[string]any, mods *[]common.M
 		if mod.Kind != "" {
 			rc, err := regexp.Compile(mod.Kind)
 			if err != nil {
-				common.Log.Errorf("Failed to compile kind regex '%s': %v", mod.Kind, err)
+				m.logger().Errorf("Failed to compile kind regex '%s': %v", mod.Kind, err)
 				return nil, nil, err
 			}
 			kind, ok := (*manifest)[common.Kind].(string)
@@ -136,21 +215,33 @@ func (m *modifier) applyModifications(manifest *map[string]any, mods *[]common.M
 			kind, ok := (*manifest)[common.Kind].(string)
 			rc, err := regexp.Compile(mod.Reject)
 			if err != nil {
-				common.Log.Errorf("Failed to compile reject regex '%s': %v", mod.Kind, err)
+				m.logger().Errorf("Failed to compile reject regex '%s': %v", mod.Kind, err)
 				return nil, nil, err
 			}
 			if ok && rc.MatchString(kind) {
-				common.Log.Debugf("Omitting manifest of kind '%s' due to reject rule", kind)
+				m.logger().Debugf("Omitting manifest of kind '%s' due to reject rule", kind)
 				continue
 			}
 		}
 
+		if mod.When != "" {
+			applies, err := m.evaluateCondition(mod.When, candidNode)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !applies {
+				m.logger().Debugf("Omitting modification on manifest of kind '%v', condition '%s' is not met", (*manifest)[common.Kind], mod.When)
+				continue
+			}
+		}
+
+		expression := mod.Expression
 		if mod.ValuesSelector != nil {
 			matches := common.ValuesRegexCompiled.FindAllStringSubmatch(mod.Expression, -1)
 			for i, sel := range mod.ValuesSelector {
 				vals, err := m.evaluator.EvaluateNodes(sel, candidNode)
 				if err != nil {
-					common.Log.Errorf("Failed to apply values selector '%s' on manifest: %v", mod.ValuesSelector, err)
+					m.logger().Errorf("Failed to apply values selector '%s' on manifest: %v", mod.ValuesSelector, err)
 					return nil, nil, err
 				}
 
@@ -160,6 +251,12 @@ func (m *modifier) applyModifications(manifest *map[string]any, mods *[]common.M
 						return nil, nil, err
 					}
 					extractedValues = *common.DeepMerge(&extractedValues, valuesMap)
+					if mod.WithDefault {
+						expression, err = m.withDefault(expression, vals, matches[i][1])
+						if err != nil {
+							return nil, nil, err
+						}
+					}
 				} else {
 					err = fmt.Errorf("no value path found in expression '%s'", mod.Expression)
 					return nil, nil, err
@@ -167,9 +264,9 @@ func (m *modifier) applyModifications(manifest *map[string]any, mods *[]common.M
 			}
 		}
 
-		result, err := m.evaluator.EvaluateNodes(mod.Expression, candidNode)
+		result, err := m.evaluator.EvaluateNodes(expression, candidNode)
 		if err != nil {
-			common.Log.Errorf("Failed to apply expression '%s' on manifest: %v", mod.Expression, err)
+			m.logger().Errorf("Failed to apply expression '%s' on manifest: %v", expression, err)
 			return nil, nil, err
 		}
 
@@ -179,11 +276,30 @@ func (m *modifier) applyModifications(manifest *map[string]any, mods *[]common.M
 		}
 		modifiedManifest = *resultManifest
 	}
-	common.Log.Tracef("Modified manifest:\n%+v", modifiedManifest)
-	common.Log.Tracef("Extracted values:\n%+v", extractedValues)
+	m.logger().Tracef("Modified manifest:\n%+v", modifiedManifest)
+	m.logger().Tracef("Extracted values:\n%+v", extractedValues)
 	return &modifiedManifest, &extractedValues, nil
 }
 
+// evaluateCondition tells whether the yq condition yields results that are all neither false nor null
+func (m *modifier) evaluateCondition(condition string, candidNode *yqlib.CandidateNode) (bool, error) {
+	result, err := m.evaluator.EvaluateNodes(condition, candidNode)
+	if err != nil {
+		m.logger().Errorf("Failed to evaluate condition '%s' on manifest: %v", condition, err)
+		return false, err
+	}
+	if result.Len() == 0 {
+		return false, nil
+	}
+	for e := result.Front(); e != nil; e = e.Next() {
+		node := e.Value.(*yqlib.CandidateNode)
+		if node.Tag == "!!null" || (node.Tag == "!!bool" && node.Value == "false") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
 func (m *modifier) wrapResult(result *list.List, underPath string) (*map[string]any, error) {
 	if result.Len() != 1 {
 		return nil, fmt.Errorf("yq result does not contain exactly one element")
@@ -192,7 +308,7 @@ func (m *modifier) wrapResult(result *list.List, underPath string) (*map[string]
 	// Decode the (single) result node into a Go value
 	v, err := m.resultToAny(result)
 	if err != nil {
-		common.Log.Errorf("Cannot decode valuesSelector result: %v", err)
+		m.logger().Errorf("Cannot decode valuesSelector result: %v", err)
 		return nil, err
 	}
 
@@ -214,6 +330,49 @@ func (m *modifier) wrapResult(result *list.List, underPath string) (*map[string]
 	return &mapVal, nil
 }
 
+// withDefault rewrites references to .Values.<valuePath> in expression into
+// ((.Values.<valuePath>) | default <extracted value>), so templates render without supplied values.
+// Literals use raw strings, structured values are embedded as base64 JSON to survive yq and YAML quoting
+func (m *modifier) withDefault(expression string, result *list.List, valuePath string) (string, error) {
+	v, err := m.resultToAny(result)
+	if err != nil {
+		m.logger().Errorf("Cannot decode valuesSelector result: %v", err)
+		return "", err
+	}
+
+	var literal string
+	switch value := v.(type) {
+	case nil:
+		return expression, nil // nothing to default to
+	case bool, int, int64, float64:
+		literal = fmt.Sprintf("%v", value)
+	case string:
+		if !strings.ContainsAny(value, "`\n") && !strings.Contains(value, "}}") {
+			literal = fmt.Sprintf("`%s`", value)
+			break
+		}
+		literal = fmt.Sprintf("(b64dec `%s`)", base64.StdEncoding.EncodeToString([]byte(value)))
+	default:
+		data, err := json.Marshal(value)
+		if err != nil {
+			return "", fmt.Errorf("cannot encode default for %s: %w", valuePath, err)
+		}
+		decoder := "fromJson"
+		if _, ok := value.([]any); ok {
+			decoder = "fromJsonArray"
+		}
+		literal = fmt.Sprintf("(b64dec `%s` | %s)", base64.StdEncoding.EncodeToString(data), decoder)
+	}
+
+	// ((.Values.a).b) tolerates missing parents, .Values.a.b fails when a is unset
+	nilSafeRef := ".Values"
+	for _, segment := range strings.Split(valuePath, ".") {
+		nilSafeRef = fmt.Sprintf("(%s.%s)", nilSafeRef, segment)
+	}
+	ref := regexp.MustCompile(`\.Values\.` + regexp.QuoteMeta(valuePath) + `([^\w.]|$)`)
+	return ref.ReplaceAllString(expression, fmt.Sprintf("(%s | default %s)$1", nilSafeRef, literal)), nil
+}
+
 // helper: generic unmarshal of a single yq result element into interface{}
 func (m *modifier) resultToAny(result *list.List) (any, error) {
 	return decodeResult[any](m, result)
@@ -223,39 +382,207 @@ func (m *modifier) resultToMap(result *list.List) (*map[string]any, error) {
 	return decodeResult[*map[string]any](m, result)
 }
 
-func ProcessManifests(ctx context.Context, releaseConfig *common.GithubRelease, helmSettings *common.HelmSettings) (*common.Manifests, error) {
-	common.Log.Infof("Updating release: %s", releaseConfig.Repo)
+// ProcessStatus tells what ProcessManifests did with a release
+type ProcessStatus int
+
+const (
+	StatusUpdated  ProcessStatus = iota // newer upstream release, Manifests are set
+	StatusUpToDate                      // chart already tracks the latest upstream release
+	StatusSkipped                       // newer upstream release without any manifests left to package
+)
+
+func (s ProcessStatus) String() string {
+	switch s {
+	case StatusUpdated:
+		return "updated"
+	case StatusUpToDate:
+		return "up-to-date"
+	case StatusSkipped:
+		return "skipped"
+	default:
+		return fmt.Sprintf("ProcessStatus(%d)", int(s))
+	}
+}
+
+// ProcessResult is the outcome of ProcessManifests, versions are upstream app versions
+type ProcessResult struct {
+	Manifests  *common.Manifests
+	Status     ProcessStatus
+	OldVersion string
+	NewVersion string
+}
+
+// ProcessManifests fetches the latest release and runs the DefaultPipeline on its manifests, globalMods run before
+// the release's own modifications so those can override them, log carries the release's fields as releases
+// are processed concurrently, githubToken authenticates release requests when set
+func ProcessManifests(ctx context.Context, log *logrus.Entry, releaseConfig *common.GithubRelease, globalMods []common.Modification, helmSettings *common.HelmSettings, githubToken string) (*ProcessResult, error) {
+	return ProcessManifestsWith(ctx, log, releaseConfig, helmSettings, githubToken, DefaultPipeline(log, releaseConfig, globalMods, helmSettings))
+}
 
-	currentVersion, currentAppVersion, err := PeekVersions(helmSettings.SrcDir, releaseConfig.ChartName)
+// ProcessManifestsWith fetches the latest release and runs pipeline on its manifests, the release is skipped
+// when a step leaves nothing to package
+func ProcessManifestsWith(ctx context.Context, log *logrus.Entry, releaseConfig *common.GithubRelease, helmSettings *common.HelmSettings, githubToken string, pipeline Pipeline) (*ProcessResult, error) {
+	log.Infof("Updating release: %s", releaseConfig.Repo)
+
+	currentVersion, currentAppVersion, err := ResolveBaselineVersions(helmSettings.SrcDir, releaseConfig.ChartName, releaseConfig.InitialVersion)
 	if err != nil {
-		common.Log.Errorf("Failed to get app version from Helm chart %s: %v", releaseConfig.ChartName, err)
+		log.Errorf("Failed to get app version from Helm chart %s: %v", releaseConfig.ChartName, err)
 		return nil, err
 	}
-	manifests, err := ghup.FetchManifests(ctx, releaseConfig, currentVersion, currentAppVersion)
+	knownAppVersion := currentAppVersion
+	if helmSettings.ValuesOnly {
+		knownAppVersion = "" // values are regenerated from the release the chart already tracks
+	}
+	var manifests *common.Manifests
+	if helmSettings.Regenerate {
+		manifests, err = ghup.CachedManifests(log, releaseConfig, helmSettings.CacheDir, currentVersion, helmSettings.VersionSuffix)
+	} else {
+		manifests, err = ghup.FetchManifests(ctx, log, releaseConfig, githubToken, currentVersion, knownAppVersion, helmSettings.CacheDir, helmSettings.VersionSuffix)
+	}
 	if err != nil {
 		return nil, err
 	}
+	if manifests != nil && helmSettings.ValuesOnly && manifests.AppVersion != currentAppVersion {
+		log.Warnf("Release %s has a newer version %s, values-only regeneration needs an up-to-date chart, skipping", releaseConfig.Repo, manifests.AppVersion)
+		return &ProcessResult{Status: StatusSkipped, OldVersion: currentAppVersion, NewVersion: manifests.AppVersion}, nil
+	}
 	if manifests == nil {
-		common.Log.Infof("No updates for release %s, skipping", releaseConfig.Repo)
-		return nil, nil
+		log.Infof("No updates for release %s, skipping", releaseConfig.Repo)
+		return &ProcessResult{Status: StatusUpToDate, OldVersion: currentAppVersion, NewVersion: currentAppVersion}, nil
 	}
 
-	common.Log.Infof("Creating or updating Helm chart %s with %d manifests", releaseConfig.ChartName, len(manifests.Manifests))
+	log.Infof("Creating or updating Helm chart %s with %d manifests", releaseConfig.ChartName, len(manifests.Manifests))
+
+	result := &ProcessResult{Status: StatusSkipped, OldVersion: currentAppVersion, NewVersion: manifests.AppVersion}
+	result.Manifests, err = pipeline.Run(ctx, manifests)
+	if err != nil {
+		return nil, err
+	}
+	if result.Manifests == nil {
+		log.Warnf("All manifests of release %s were dropped, skipping", releaseConfig.Repo)
+		return result, nil
+	}
+	result.Status = StatusUpdated
+	return result, nil
+}
+
+// parametrize applies the global and the release's modifications, image parametrization and overrideValues,
+// CRDs destined for crds/ bypass both as Helm never templates that directory
+func parametrize(chartModifier *modifier, manifests *common.Manifests, releaseConfig *common.GithubRelease, globalMods []common.Modification, helmSettings *common.HelmSettings) (*common.Manifests, error) {
+	// CRDs left out of crds-dir parametrization still tell cluster-scoped kinds and custom resource schemas
+	crds := manifests.Crds
+	var rawCrds []map[string]any
+	if releaseConfig.ResolvedCrdMode(helmSettings) == common.CrdModeCrdsDir {
+		rawCrds = manifests.Crds
+		manifests = &common.Manifests{
+			Manifests:  manifests.Manifests,
+			Version:    manifests.Version,
+			AppVersion: manifests.AppVersion,
+			Values:     manifests.Values,
+			CrdsValues: manifests.CrdsValues,
+		}
+	}
+
+	mods := make([]common.Modification, 0, len(globalMods)+len(releaseConfig.Modifications)+1)
+	// runs first so modifications can still override the namespace of single resources
+	if releaseConfig.TemplateNamespace {
+		mods = append(mods, namespaceModification(crds))
+	}
+	mods = append(append(mods, globalMods...), releaseConfig.Modifications...)
+	// run after modifications and hooks of the same priority, which keep matching the upstream names
+	renameMods, err := renameModifications(releaseConfig.Renames)
+	if err != nil {
+		return nil, err
+	}
+	if helmSettings.RawOutput() {
+		mods = append(rawModifications(chartModifier.logger(), mods), renameMods...)
+		modifiedManifests, err := chartModifier.ParametrizeManifests(manifests, &mods)
+		if err == nil && releaseConfig.NormalizeMetadata {
+			modifiedManifests, err = chartModifier.NormalizeMetadata(modifiedManifests, releaseConfig.StrippedAnnotations())
+		}
+		if err != nil {
+			return nil, err
+		}
+		if rawCrds != nil {
+			modifiedManifests.Crds = rawCrds
+		}
+		return modifiedManifests, nil
+	}
+	hookMods, err := helmHookModifications(releaseConfig.HelmHooks)
+	if err != nil {
+		return nil, err
+	}
+	mods = append(mods, hookMods...)
+	var valuesSchema map[string]any
+	if len(releaseConfig.CustomResourceValues) > 0 {
+		crMods, schema, err := customResourceValues(chartModifier.logger(), manifests, crds, releaseConfig.CustomResourceValues)
+		if err != nil {
+			return nil, err
+		}
+		mods = append(mods, crMods...)
+		valuesSchema = schema
+	}
+	mods = append(mods, renameMods...)
 
-	modifiedManifests, err := ChartModifier.ParametrizeManifests(
-		ChartModifier.FilterManifests(
-			manifests,
-			releaseConfig.Drop,
-		),
-		&releaseConfig.Modifications,
+	modifiedManifests, err := chartModifier.ParametrizeManifests(
+		manifests,
+		&mods,
 	)
 	if err != nil {
 		return nil, err
 	}
+	modifiedManifests.ValuesSchema = valuesSchema
 
+	if releaseConfig.NormalizeMetadata {
+		modifiedManifests, err = chartModifier.NormalizeMetadata(modifiedManifests, releaseConfig.StrippedAnnotations())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if releaseConfig.ParametrizeImages {
+		modifiedManifests, err = chartModifier.ParametrizeImages(modifiedManifests)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if releaseConfig.ParametrizeResources {
+		modifiedManifests, err = chartModifier.ParametrizeResources(modifiedManifests)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	modifiedManifests.ChartType = releaseConfig.ChartType
+	modifiedManifests.ExtraTemplates = releaseConfig.ExtraTemplates
+	modifiedManifests.PreserveCrds = releaseConfig.PreserveCrds
+
+	// precedence: addValues < extracted values < overrideValues
+	if len(releaseConfig.OverrideValues) > 0 {
+		modifiedManifests.Values = *common.DeepMerge(&modifiedManifests.Values, &releaseConfig.OverrideValues)
+	}
+
+	if rawCrds != nil {
+		modifiedManifests.Crds = rawCrds
+	}
 	return modifiedManifests, nil
 }
 
+// rawModifications drops modifications extracting values or templating with Helm, neither makes sense
+// for plain manifests
+func rawModifications(log *logrus.Entry, mods []common.Modification) []common.Modification {
+	raw := make([]common.Modification, 0, len(mods))
+	for _, mod := range mods {
+		if len(mod.ValuesSelector) > 0 || strings.Contains(mod.Expression, "{{") {
+			log.Debugf("Skipping modification '%s' for plain manifest output", mod.Expression)
+			continue
+		}
+		raw = append(raw, mod)
+	}
+	return raw
+}
+
 // generic decoder
 func decodeResult[T any](m *modifier, result *list.List) (T, error) {
 	var zero T
@@ -4,11 +4,16 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/Masterminds/semver/v3"
 	"github.com/krezh/charts/internal/common"
+	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
 )
 
 func TestMain(m *testing.M) {
@@ -37,6 +42,43 @@ func TestParseAssets(t *testing.T) {
 	}
 }
 
+func TestFilterManifests(t *testing.T) {
+	//given
+	testManifests, _ := common.NewManifests(readTestData(t), mustSemver("0.0.1"), "0.0.1", new(map[string]any), new(map[string]any))
+
+	//when
+	filtered, err := ChartModifier.FilterManifests(testManifests, []string{"namespace"}, []string{"^kubevirt$", `^cdis\.`})
+
+	//then
+	if err != nil {
+		t.Fatalf("FilterManifests() error = %v", err)
+	}
+	if len(filtered.Manifests) != 15 {
+		t.Errorf("FilterManifests() manifests = %v, want 15", len(filtered.Manifests))
+	}
+	if len(filtered.Crds) != 1 {
+		t.Errorf("FilterManifests() crds = %v, want 1", len(filtered.Crds))
+	}
+	for _, m := range filtered.Manifests {
+		if m[common.Kind] == "Namespace" || m[common.Kind] == "KubeVirt" {
+			t.Errorf("FilterManifests() kept %v manifest", m[common.Kind])
+		}
+	}
+}
+
+func TestFilterManifestsInvalidNameRegex(t *testing.T) {
+	//given
+	testManifests, _ := common.NewManifests(readTestData(t), mustSemver("0.0.1"), "0.0.1", new(map[string]any), new(map[string]any))
+
+	//when
+	_, err := ChartModifier.FilterManifests(testManifests, nil, []string{"("})
+
+	//then
+	if err == nil {
+		t.Errorf("FilterManifests() expected error for invalid regex")
+	}
+}
+
 func TestParametrizeExtractsValues(t *testing.T) {
 	testManifests, _ := common.NewManifests(readTestData(t), mustSemver("0.0.1"), "0.0.1", new(map[string]any), new(map[string]any))
 	testCases := map[string]struct {
@@ -169,6 +211,412 @@ func TestParametrizeListElement(t *testing.T) {
 	t.Errorf("ParametrizeManifests() did not find a matching RoleBinding manifest or did not match expected changes")
 }
 
+func TestParametrizeWithDefault(t *testing.T) {
+	//given
+	testManifests, _ := common.NewManifests(readTestData(t), mustSemver("0.0.1"), "0.0.1", new(map[string]any), new(map[string]any))
+	mods := []common.Modification{
+		{
+			Expression:     ".spec.imagePullPolicy |= \"{{ .Values.kubevirt.imagePullPolicy }}\"",
+			ValuesSelector: []string{".spec.imagePullPolicy"},
+			Kind:           "KubeVirt",
+			WithDefault:    true,
+		},
+		{
+			Expression:     ".spec.configuration |= \"{{ .Values.kubevirt.configuration | toYaml | nindent 8 }}\"",
+			ValuesSelector: []string{".spec.configuration"},
+			Kind:           "KubeVirt",
+			WithDefault:    true,
+		},
+	}
+
+	//when
+	modifiedManifests, err := ChartModifier.ParametrizeManifests(testManifests, &mods)
+
+	//then
+	if err != nil {
+		t.Fatalf("ParametrizeManifests() error = %v", err)
+	}
+	for _, m := range modifiedManifests.Manifests {
+		if m[common.Kind] != "KubeVirt" {
+			continue
+		}
+		ch := &chart.Chart{Metadata: &chart.Metadata{Name: "test", Version: "0.0.1", APIVersion: chart.APIVersionV2}}
+		if err := createTemplates(testLog(), ch, &[]map[string]any{m}, common.TemplateLayoutPerKind, "", nil); err != nil {
+			t.Fatalf("createTemplates() error = %v", err)
+		}
+		values, _ := chartutil.ToRenderValues(ch, map[string]any{}, chartutil.ReleaseOptions{}, nil)
+		rendered, err := engine.Render(ch, values)
+		if err != nil {
+			t.Fatalf("Render() error = %v\n%s", err, ch.Templates[0].Data)
+		}
+		var renderedManifest map[string]any
+		if err := yaml.Unmarshal([]byte(rendered["test/templates/kubevirt.yaml"]), &renderedManifest); err != nil {
+			t.Fatalf("rendered manifest is not valid YAML: %v", err)
+		}
+		expected := map[string]any{
+			"spec": map[string]any{
+				"imagePullPolicy": "IfNotPresent",
+				"configuration": map[string]any{
+					"developerConfiguration": map[string]any{
+						"featureGates": []any{},
+					},
+				},
+			},
+		}
+		if !mapContains(&renderedManifest, &expected, true) {
+			t.Errorf("rendered manifest:\n%v, but wanted:\n%v", mustYaml(renderedManifest), mustYaml(expected))
+		}
+		return
+	}
+	t.Errorf("ParametrizeManifests() did not return a KubeVirt manifest")
+}
+
+func TestParametrizeSkipsCrdsDirCrds(t *testing.T) {
+	//given
+	testManifests, _ := common.NewManifests(readTestData(t), mustSemver("0.0.1"), "0.0.1", new(map[string]any), new(map[string]any))
+	release := &common.GithubRelease{
+		Modifications: []common.Modification{
+			*common.NewYqModification(".metadata.labels.templated |= \"{{ .Release.Name }}\""),
+		},
+	}
+	settings := &common.HelmSettings{CrdMode: common.CrdModeCrdsDir}
+
+	//when
+	modifiedManifests, err := parametrize(ChartModifier, testManifests, release, nil, settings)
+
+	//then
+	if err != nil {
+		t.Fatalf("parametrize() error = %v", err)
+	}
+	if len(modifiedManifests.Crds) != 2 {
+		t.Fatalf("parametrize() crds = %d, want 2", len(modifiedManifests.Crds))
+	}
+	templated := map[string]any{"metadata": map[string]any{"labels": map[string]any{"templated": "{{ .Release.Name }}"}}}
+	for _, crd := range modifiedManifests.Crds {
+		if mapContains(&crd, &templated, true) {
+			t.Errorf("parametrize() modified CRD destined for crds/:\n%v", mustYaml(crd))
+		}
+	}
+	for _, m := range modifiedManifests.Manifests {
+		if !mapContains(&m, &templated, true) {
+			t.Errorf("parametrize() did not modify manifest:\n%v", mustYaml(m))
+			return
+		}
+	}
+}
+
+func TestParametrizeWhen(t *testing.T) {
+	testManifests := &common.Manifests{Manifests: []map[string]any{
+		{"kind": "Deployment", "metadata": map[string]any{"name": "single"}, "spec": map[string]any{"replicas": 1}},
+		{"kind": "Deployment", "metadata": map[string]any{"name": "scaled", "annotations": map[string]any{"scale": "true"}}, "spec": map[string]any{"replicas": 3}},
+	}}
+	testCases := map[string]struct {
+		when string
+		want []string
+	}{
+		"empty_applies_always": {when: "", want: []string{"single", "scaled"}},
+		"comparison":           {when: ".spec.replicas > 1", want: []string{"scaled"}},
+		"annotation_present":   {when: ".metadata.annotations.scale", want: []string{"scaled"}},
+		"never":                {when: ".spec.replicas > 5", want: []string{}},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			mods := []common.Modification{{Expression: ".metadata.labels.matched = \"yes\"", When: tc.when}}
+
+			//when
+			modified, err := ChartModifier.ParametrizeManifests(testManifests, &mods)
+
+			//then
+			if err != nil {
+				t.Fatalf("ParametrizeManifests() error = %v", err)
+			}
+			matched := make([]string, 0)
+			for _, m := range modified.Manifests {
+				metadata := m["metadata"].(map[string]any)
+				if labels, ok := metadata["labels"].(map[string]any); ok && labels["matched"] == "yes" {
+					matched = append(matched, metadata["name"].(string))
+				}
+			}
+			if !reflect.DeepEqual(matched, tc.want) {
+				t.Errorf("ParametrizeManifests() modified %v, want %v", matched, tc.want)
+			}
+		})
+	}
+}
+
+func TestParametrizePriority(t *testing.T) {
+	//given
+	testManifests := &common.Manifests{Manifests: []map[string]any{
+		{"kind": "Deployment", "metadata": map[string]any{"name": "operator"}},
+	}}
+	mods := []common.Modification{
+		{Expression: ".metadata.labels.tier = \"late\"", Priority: 10},
+		{Expression: ".metadata.labels.tier = \"early\"", Priority: -1},
+		{Expression: ".metadata.labels.order = \"first\""},
+		{Expression: ".metadata.labels.order = \"second\""},
+	}
+
+	//when
+	modified, err := ChartModifier.ParametrizeManifests(testManifests, &mods)
+
+	//then
+	if err != nil {
+		t.Fatalf("ParametrizeManifests() error = %v", err)
+	}
+	labels := modified.Manifests[0]["metadata"].(map[string]any)["labels"].(map[string]any)
+	if labels["tier"] != "late" || labels["order"] != "second" {
+		t.Errorf("ParametrizeManifests() labels = %v, want tier late and order second", labels)
+	}
+	if mods[0].Priority != 10 {
+		t.Errorf("ParametrizeManifests() reordered the input modifications")
+	}
+}
+
+func TestParametrizeValuesPrecedence(t *testing.T) {
+	//given
+	addValues := map[string]any{"operator": map[string]any{"replicas": 5, "logLevel": "info"}}
+	testManifests := &common.Manifests{
+		Manifests: []map[string]any{{"kind": "Deployment", "spec": map[string]any{"replicas": 2}}},
+		Values:    addValues,
+	}
+	release := &common.GithubRelease{
+		Modifications: []common.Modification{{
+			Expression:     ".spec.replicas |= \"{{ .Values.operator.replicas }}\"",
+			ValuesSelector: []string{".spec.replicas"},
+		}},
+		OverrideValues: map[string]any{"operator": map[string]any{"replicas": 1}},
+	}
+
+	//when
+	modifiedManifests, err := parametrize(ChartModifier, testManifests, release, nil, &common.HelmSettings{})
+
+	//then
+	if err != nil {
+		t.Fatalf("parametrize() error = %v", err)
+	}
+	want := map[string]any{"operator": map[string]any{"replicas": 1, "logLevel": "info"}}
+	if !reflect.DeepEqual(modifiedManifests.Values, want) {
+		t.Errorf("parametrize() values = %v, want %v", modifiedManifests.Values, want)
+	}
+
+	//when
+	release.OverrideValues = nil
+	modifiedManifests, _ = parametrize(ChartModifier, testManifests, release, nil, &common.HelmSettings{})
+
+	//then
+	want = map[string]any{"operator": map[string]any{"replicas": 2, "logLevel": "info"}}
+	if !reflect.DeepEqual(modifiedManifests.Values, want) {
+		t.Errorf("parametrize() values without overrides = %v, want %v", modifiedManifests.Values, want)
+	}
+}
+
+func TestParametrizeGlobalModifications(t *testing.T) {
+	//given
+	testManifests, _ := common.NewManifests(readTestData(t), mustSemver("0.0.1"), "0.0.1", new(map[string]any), new(map[string]any))
+	globalMods := []common.Modification{
+		*common.NewYqModification(".metadata.labels.team |= \"global\""),
+		{Expression: ".metadata.labels.scope |= \"global\"", Kind: "^Deployment$"},
+	}
+	release := &common.GithubRelease{
+		Modifications: []common.Modification{
+			{Expression: ".metadata.labels.team |= \"release\"", Kind: "^Deployment$"},
+		},
+	}
+
+	//when
+	modifiedManifests, err := parametrize(ChartModifier, testManifests, release, globalMods, &common.HelmSettings{})
+
+	//then
+	if err != nil {
+		t.Fatalf("parametrize() error = %v", err)
+	}
+	for _, m := range modifiedManifests.Manifests {
+		metadata, _ := m["metadata"].(map[string]any)
+		labels, _ := metadata["labels"].(map[string]any)
+		wantTeam, wantScope := "global", any(nil)
+		if m[common.Kind] == "Deployment" {
+			wantTeam, wantScope = "release", "global"
+		}
+		if labels["team"] != wantTeam || labels["scope"] != wantScope {
+			t.Errorf("parametrize() %v labels = %v, want team %v and scope %v", m[common.Kind], labels, wantTeam, wantScope)
+		}
+	}
+}
+
+func TestParametrizeHelmHooks(t *testing.T) {
+	//given
+	testManifests, _ := common.NewManifests(readTestData(t), mustSemver("0.0.1"), "0.0.1", new(map[string]any), new(map[string]any))
+	release := &common.GithubRelease{
+		HelmHooks: []common.HelmHook{{Kind: "Deployment", Name: "cdi-operator", Hook: "pre-install,pre-upgrade", Weight: -5, DeletePolicy: "before-hook-creation"}},
+	}
+
+	//when
+	modifiedManifests, err := parametrize(ChartModifier, testManifests, release, nil, &common.HelmSettings{})
+
+	//then
+	if err != nil {
+		t.Fatalf("parametrize() error = %v", err)
+	}
+	found := false
+	for _, m := range modifiedManifests.Manifests {
+		if m[common.Kind] != "Deployment" {
+			continue
+		}
+		metadata, _ := m["metadata"].(map[string]any)
+		annotations, _ := metadata["annotations"].(map[string]any)
+		hooked := metadata["name"] == "cdi-operator"
+		found = found || hooked
+		if (annotations[hookAnnotation] == "pre-install,pre-upgrade") != hooked || (annotations[hookWeightAnnotation] == "-5") != hooked {
+			t.Errorf("parametrize() %v annotations = %v, hook expected %v", metadata["name"], annotations, hooked)
+		}
+		if !hooked {
+			continue
+		}
+		ch := &chart.Chart{Metadata: &chart.Metadata{Name: "test"}}
+		if err := createTemplates(testLog(), ch, &[]map[string]any{m}, common.TemplateLayoutPerKind, "", nil); err != nil {
+			t.Fatalf("createTemplates() error = %v", err)
+		}
+		if !strings.Contains(string(ch.Templates[0].Data), "helm.sh/hook-delete-policy: before-hook-creation") {
+			t.Errorf("createTemplates() lost hook annotations:\n%s", ch.Templates[0].Data)
+		}
+	}
+	if !found {
+		t.Errorf("parametrize() dropped Deployment cdi-operator")
+	}
+}
+
+func TestParametrizeTemplateNamespace(t *testing.T) {
+	testCases := map[string]*common.HelmSettings{
+		"separate": {},
+		"crds_dir": {CrdMode: common.CrdModeCrdsDir},
+	}
+	for name, settings := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			testManifests, _ := common.NewManifests(readTestData(t), mustSemver("0.0.1"), "0.0.1", new(map[string]any), new(map[string]any))
+			release := &common.GithubRelease{TemplateNamespace: true}
+
+			//when
+			modifiedManifests, err := parametrize(ChartModifier, testManifests, release, nil, settings)
+
+			//then
+			if err != nil {
+				t.Fatalf("parametrize() error = %v", err)
+			}
+			namespaced := map[string]bool{
+				"Deployment": true, "ServiceAccount": true, "Role": true, "RoleBinding": true, "KubeVirt": true,
+				"ClusterRole": false, "ClusterRoleBinding": false, "Namespace": false, "PriorityClass": false, "CDI": false,
+			}
+			for _, m := range modifiedManifests.Manifests {
+				kind, _ := m[common.Kind].(string)
+				metadata, _ := m["metadata"].(map[string]any)
+				templated := metadata["namespace"] == "{{ .Release.Namespace }}"
+				if templated != namespaced[kind] {
+					t.Errorf("parametrize() %s %v namespace = %v, templated expected %v", kind, metadata["name"], metadata["namespace"], namespaced[kind])
+				}
+			}
+			for _, crd := range modifiedManifests.Crds {
+				metadata, _ := crd["metadata"].(map[string]any)
+				if _, ok := metadata["namespace"]; ok {
+					t.Errorf("parametrize() set namespace of CRD %v", metadata["name"])
+				}
+			}
+		})
+	}
+}
+
+func TestParametrizeRawOutputKeepsCrdsDirCrds(t *testing.T) {
+	//given
+	testManifests, _ := common.NewManifests(readTestData(t), mustSemver("0.0.1"), "0.0.1", new(map[string]any), new(map[string]any))
+	settings := &common.HelmSettings{CrdMode: common.CrdModeCrdsDir, OutputFormat: common.OutputFormatManifests}
+
+	//when
+	modifiedManifests, err := parametrize(ChartModifier, testManifests, &common.GithubRelease{}, nil, settings)
+
+	//then
+	if err != nil {
+		t.Fatalf("parametrize() error = %v", err)
+	}
+	if len(modifiedManifests.Crds) != 2 {
+		t.Errorf("parametrize() crds = %d, want 2", len(modifiedManifests.Crds))
+	}
+}
+
+func TestHelmHookModificationsInvalid(t *testing.T) {
+	//when
+	_, err := helmHookModifications([]common.HelmHook{{Kind: "Job"}})
+
+	//then
+	if err == nil {
+		t.Errorf("helmHookModifications() expected error for hook without helm.sh/hook value")
+	}
+}
+
+func TestCustomResourceValues(t *testing.T) {
+	testCases := map[string]string{
+		"separate": common.CrdModeSeparate,
+		"crds_dir": common.CrdModeCrdsDir,
+	}
+	for name, crdMode := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			testManifests, _ := common.NewManifests(readTestData(t), mustSemver("0.0.1"), "0.0.1", new(map[string]any), new(map[string]any))
+			release := &common.GithubRelease{
+				Drop:                 []string{"namespace"},
+				CustomResourceValues: []common.CustomResourceValues{{Kind: "KubeVirt", ValuesKey: "kubevirt"}},
+			}
+			settings := &common.HelmSettings{SrcDir: t.TempDir(), LintK8s: "1.30.0", DanglingValues: common.DanglingValuesFail, CrdMode: crdMode}
+
+			//when
+			modifiedManifests, err := parametrize(ChartModifier, testManifests, release, nil, settings)
+			if err != nil {
+				t.Fatalf("parametrize() error = %v", err)
+			}
+			charts, err := NewHelmCharts(testLog(), settings, "test", crdMode, modifiedManifests)
+
+			//then
+			if err != nil {
+				t.Fatalf("NewHelmCharts() error = %v", err)
+			}
+			if _, ok := modifiedManifests.Values["kubevirt"].(map[string]any)["imagePullPolicy"]; !ok {
+				t.Errorf("parametrize() values = %v, want KubeVirt spec under kubevirt", mustYaml(modifiedManifests.Values))
+			}
+			if charts.Chart.Schema == nil || !fileExists(filepath.Join(settings.SrcDir, "test", chartutil.SchemafileName)) {
+				t.Errorf("NewHelmCharts() did not write %s", chartutil.SchemafileName)
+			}
+		})
+	}
+}
+
+func TestPreviewParametrization(t *testing.T) {
+	//given
+	testManifests, _ := common.NewManifests(readTestData(t), mustSemver("0.0.1"), "0.0.1", new(map[string]any), new(map[string]any))
+	mods := []common.Modification{{
+		Expression:     ".spec.configuration |= \"{{ .Values.kubevirt.configuration }}\"",
+		ValuesSelector: []string{".spec.configuration"},
+		Kind:           "KubeVirt",
+	}}
+
+	//when
+	preview, err := PreviewParametrization(testManifests, &mods)
+
+	//then
+	if err != nil {
+		t.Fatalf("PreviewParametrization() error = %v", err)
+	}
+	kubevirt, _ := preview.Values["kubevirt"].(map[string]any)
+	if _, found := kubevirt["configuration"]; !found {
+		t.Errorf("PreviewParametrization() values = %v", preview.Values)
+	}
+	if len(testManifests.Values) != 0 {
+		t.Errorf("PreviewParametrization() modified input values: %v", testManifests.Values)
+	}
+	if _, err := PreviewParametrization(nil, &mods); err == nil {
+		t.Errorf("PreviewParametrization() expected error for nil manifests")
+	}
+}
+
 func mapContains(mainMap *map[string]any, subMap *map[string]any, mustExist bool) bool {
 	for k, subVal := range *subMap {
 		mainVal, exists := (*mainMap)[k]
@@ -226,3 +674,7 @@ func mustSemver(v string) *semver.Version {
 	}
 	return s
 }
+
+func testLog() *logrus.Entry {
+	return common.Log.WithField("release", "test")
+}
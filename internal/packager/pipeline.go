@@ -0,0 +1,83 @@
+package packager
+
+import (
+	"context"
+
+	"github.com/krezh/charts/internal/common"
+	"github.com/sirupsen/logrus"
+)
+
+// Step transforms the manifests of a release, e.g. to validate or rewrite them before charts are generated
+type Step interface {
+	Apply(ctx context.Context, manifests *common.Manifests) (*common.Manifests, error)
+}
+
+// StepFunc adapts a plain function to a Step
+type StepFunc func(ctx context.Context, manifests *common.Manifests) (*common.Manifests, error)
+
+func (f StepFunc) Apply(ctx context.Context, manifests *common.Manifests) (*common.Manifests, error) {
+	return f(ctx, manifests)
+}
+
+// Pipeline is an ordered list of steps, each step gets the manifests returned by the previous one
+type Pipeline []Step
+
+// Run applies the steps in order, it stops early and returns nil when a step leaves neither manifests nor CRDs
+func (p Pipeline) Run(ctx context.Context, manifests *common.Manifests) (*common.Manifests, error) {
+	for _, step := range p {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var err error
+		manifests, err = step.Apply(ctx, manifests)
+		if err != nil {
+			return nil, err
+		}
+		if manifests == nil || (len(manifests.Manifests) == 0 && !manifests.ContainsCrds()) {
+			return nil, nil
+		}
+	}
+	return manifests, nil
+}
+
+// DefaultPipeline filters the release's manifests by drop and dropNames, then parametrizes them and,
+// with validateCrds, validates the resulting CRDs, custom steps can be inserted anywhere before passing it
+// to ProcessManifestsWith
+func DefaultPipeline(log *logrus.Entry, releaseConfig *common.GithubRelease, globalMods []common.Modification, helmSettings *common.HelmSettings) Pipeline {
+	pipeline := Pipeline{
+		NewFilterStep(log, releaseConfig),
+		NewParametrizeStep(log, releaseConfig, globalMods, helmSettings),
+	}
+	if helmSettings.ValidateCrds && releaseConfig.ResolvedCrdMode(helmSettings) != common.CrdModeDrop {
+		pipeline = append(pipeline, NewValidateCrdsStep(log))
+	}
+	return pipeline
+}
+
+// NewFilterStep drops manifests whose kind matches the release's drop or dropNamespaces or whose name matches its dropNames
+func NewFilterStep(log *logrus.Entry, releaseConfig *common.GithubRelease) Step {
+	chartModifier := ChartModifier.withLog(log)
+	return StepFunc(func(_ context.Context, manifests *common.Manifests) (*common.Manifests, error) {
+		return chartModifier.FilterManifests(manifests, releaseConfig.DroppedKinds(), releaseConfig.DropNames)
+	})
+}
+
+// NewParametrizeStep applies globalMods and the release's modifications, hooks, custom resource values,
+// metadata normalization, image and resources parametrization and overrideValues
+func NewParametrizeStep(log *logrus.Entry, releaseConfig *common.GithubRelease, globalMods []common.Modification, helmSettings *common.HelmSettings) Step {
+	chartModifier := ChartModifier.withLog(log)
+	return StepFunc(func(_ context.Context, manifests *common.Manifests) (*common.Manifests, error) {
+		return parametrize(chartModifier, manifests, releaseConfig, globalMods, helmSettings)
+	})
+}
+
+// NewValidateCrdsStep fails the release when a CRD violates the Kubernetes structural schema rules,
+// which Helm lint doesn't check and kubectl apply would only report on install
+func NewValidateCrdsStep(log *logrus.Entry) Step {
+	return StepFunc(func(_ context.Context, manifests *common.Manifests) (*common.Manifests, error) {
+		if err := validateCrds(log, manifests.Crds); err != nil {
+			return nil, err
+		}
+		return manifests, nil
+	})
+}
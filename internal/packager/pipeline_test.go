@@ -0,0 +1,124 @@
+package packager
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/krezh/charts/internal/common"
+)
+
+func TestPipelineRun(t *testing.T) {
+	errStep := errors.New("step failed")
+	record := func(name string, calls *[]string, result func(*common.Manifests) (*common.Manifests, error)) Step {
+		return StepFunc(func(_ context.Context, m *common.Manifests) (*common.Manifests, error) {
+			*calls = append(*calls, name)
+			return result(m)
+		})
+	}
+	keep := func(m *common.Manifests) (*common.Manifests, error) { return m, nil }
+	drop := func(m *common.Manifests) (*common.Manifests, error) { return &common.Manifests{}, nil }
+	fail := func(m *common.Manifests) (*common.Manifests, error) { return nil, errStep }
+	testCases := map[string]struct {
+		steps     []func(*common.Manifests) (*common.Manifests, error)
+		wantCalls []string
+		wantNil   bool
+		wantErr   error
+	}{
+		"in_order":     {steps: []func(*common.Manifests) (*common.Manifests, error){keep, keep}, wantCalls: []string{"0", "1"}},
+		"dropped_all":  {steps: []func(*common.Manifests) (*common.Manifests, error){drop, keep}, wantCalls: []string{"0"}, wantNil: true},
+		"failing_step": {steps: []func(*common.Manifests) (*common.Manifests, error){keep, fail, keep}, wantCalls: []string{"0", "1"}, wantErr: errStep},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			calls := make([]string, 0)
+			pipeline := make(Pipeline, 0, len(tc.steps))
+			for i, result := range tc.steps {
+				pipeline = append(pipeline, record(strconv.Itoa(i), &calls, result))
+			}
+			manifests := &common.Manifests{Manifests: []map[string]any{{common.Kind: "Deployment"}}}
+
+			//when
+			result, err := pipeline.Run(context.Background(), manifests)
+
+			//then
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Run() error = %v, want %v", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(calls, tc.wantCalls) {
+				t.Errorf("Run() called %v, want %v", calls, tc.wantCalls)
+			}
+			if tc.wantErr == nil && (result == nil) != tc.wantNil {
+				t.Errorf("Run() = %v, want nil %v", result, tc.wantNil)
+			}
+		})
+	}
+}
+
+func TestDefaultPipeline(t *testing.T) {
+	//given
+	testManifests, _ := common.NewManifests(readTestData(t), mustSemver("0.0.1"), "0.0.1", new(map[string]any), new(map[string]any))
+	release := &common.GithubRelease{Drop: []string{"Deployment"}}
+	custom := StepFunc(func(_ context.Context, m *common.Manifests) (*common.Manifests, error) {
+		for _, manifest := range m.Manifests {
+			if manifest[common.Kind] == "Deployment" {
+				return nil, errors.New("custom step saw a dropped Deployment")
+			}
+		}
+		return m, nil
+	})
+	pipeline := DefaultPipeline(testLog(), release, nil, &common.HelmSettings{})
+	pipeline = append(pipeline[:1], append(Pipeline{custom}, pipeline[1:]...)...)
+
+	//when
+	result, err := pipeline.Run(context.Background(), testManifests)
+
+	//then
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result == nil || len(result.Manifests) == 0 {
+		t.Fatalf("Run() = %v, want the remaining manifests", result)
+	}
+}
+
+func TestFilterStepDropNamespaces(t *testing.T) {
+	testCases := map[string]struct {
+		release       common.GithubRelease
+		wantNamespace bool
+	}{
+		"kept":            {release: common.GithubRelease{}, wantNamespace: true},
+		"dropNamespaces":  {release: common.GithubRelease{DropNamespaces: true}},
+		"with_drop_kinds": {release: common.GithubRelease{DropNamespaces: true, Drop: []string{"Deployment"}}},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			testManifests, _ := common.NewManifests(readTestData(t), mustSemver("0.0.1"), "0.0.1", new(map[string]any), new(map[string]any))
+
+			//when
+			filtered, err := NewFilterStep(testLog(), &tc.release).Apply(context.Background(), testManifests)
+
+			//then
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			kinds := make([]string, 0)
+			for _, m := range filtered.Manifests {
+				kinds = append(kinds, m[common.Kind].(string))
+			}
+			if slices.Contains(kinds, "Namespace") != tc.wantNamespace {
+				t.Errorf("Apply() kinds = %v, want Namespace %v", kinds, tc.wantNamespace)
+			}
+			for _, kind := range tc.release.Drop {
+				if slices.Contains(kinds, kind) {
+					t.Errorf("Apply() kept dropped kind %s", kind)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package packager
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/krezh/charts/internal/common"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// PreserveCrdAnnotation marks a committed CRD to be kept by preserveCrds: annotated when upstream doesn't ship it
+const PreserveCrdAnnotation = "krezh-charts/preserve"
+
+// withPreservedCrds merges the upstream CRDs with the CRDs of the committed chart missing upstream, matched by
+// metadata.name, according to the release's preserveCrds policy, so hand-added or deprecated CRDs survive
+// regeneration, the committed chart is the CRD chart, or the main chart for the inline and crds-dir modes
+func withPreservedCrds(log *logrus.Entry, helmSettings *common.HelmSettings, chartName, crdMode string, m *common.Manifests) (*common.Manifests, error) {
+	switch m.PreserveCrds {
+	case "", common.PreserveCrdsNone:
+		return m, nil
+	case common.PreserveCrdsAll, common.PreserveCrdsAnnotated:
+	default:
+		return nil, fmt.Errorf("unknown preserveCrds '%s' for chart %s", m.PreserveCrds, chartName)
+	}
+	committedChart := chartName
+	switch crdMode {
+	case "", common.CrdModeSeparate:
+		var err error
+		committedChart, err = CrdChartName(chartName, helmSettings)
+		if err != nil {
+			return nil, err
+		}
+	case common.CrdModeDrop:
+		return m, nil
+	}
+
+	committed, err := committedCrds(log, filepath.Join(helmSettings.SrcDir, committedChart))
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(m.Crds))
+	for _, crd := range m.Crds {
+		names[crdName(crd)] = true
+	}
+	crds := slices.Clone(m.Crds)
+	for _, crd := range committed {
+		name := crdName(crd)
+		if name == "" || names[name] {
+			continue
+		}
+		if m.PreserveCrds == common.PreserveCrdsAnnotated && !annotatedForPreservation(crd) {
+			log.Infof("Dropping CRD %s of chart %s, it is no longer shipped upstream", name, committedChart)
+			continue
+		}
+		log.Infof("Preserving CRD %s of chart %s missing upstream", name, committedChart)
+		crds = append(crds, crd)
+		names[name] = true
+	}
+	if len(crds) == len(m.Crds) {
+		return m, nil
+	}
+	merged := *m
+	merged.Crds = crds
+	return &merged, nil
+}
+
+// templateLineRegex matches lines holding nothing but template actions, e.g. {{- if .Values.crds.enabled }}
+var templateLineRegex = regexp.MustCompile(`(?m)^[ \t]*\{\{[^\n]*\}\}[ \t]*$`)
+
+// crdKindRegex tells files holding a CRD apart from other templates
+var crdKindRegex = regexp.MustCompile(`(?m)^kind:[ \t]*["']?CustomResourceDefinition`)
+
+// committedCrds reads the CRDs in templates/ and crds/ of the committed chart at chartPath, none when it doesn't
+// exist yet, template expressions within values are kept while lines of template actions are dropped, templates
+// Helm syntax still makes invalid YAML are skipped with a warning unless they hold a CRD
+func committedCrds(log *logrus.Entry, chartPath string) ([]map[string]any, error) {
+	crds := make([]map[string]any, 0)
+	for _, dir := range []string{"templates", CrdsDir} {
+		root := filepath.Join(chartPath, dir)
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) && path == root {
+					return fs.SkipDir
+				}
+				return err
+			}
+			if d.IsDir() || (filepath.Ext(path) != ".yaml" && filepath.Ext(path) != ".yml") {
+				return nil
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			stripped, actions, dropped := stripTemplateActions(data)
+			decoder := yaml.NewDecoder(bytes.NewReader(stripped))
+			found := make([]map[string]any, 0)
+			for {
+				var doc map[string]any
+				err := decoder.Decode(&doc)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					if crdKindRegex.Match(data) {
+						return fmt.Errorf("committed CRDs in %s can't be preserved, their templating gives no YAML: %w", path, err)
+					}
+					log.Warnf("Skipping %s when looking for CRDs to preserve, it is no plain YAML: %v", path, err)
+					return nil
+				}
+				if doc[common.Kind] == "CustomResourceDefinition" {
+					found = append(found, restoreTemplateActions(doc, actions).(map[string]any))
+				}
+			}
+			if len(found) > 0 && dropped {
+				log.Warnf("Preserved CRDs of %s lose the template actions on lines of their own, e.g. conditions", path)
+			}
+			crds = append(crds, found...)
+			return nil
+		})
+		if err != nil {
+			log.Errorf("Failed to read committed CRDs of %s: %v", chartPath, err)
+			return nil, err
+		}
+	}
+	return crds, nil
+}
+
+// stripTemplateActions blanks lines of template actions and replaces the remaining actions by placeholders
+// restoreTemplateActions puts back, it tells whether lines were blanked
+func stripTemplateActions(data []byte) ([]byte, []string, bool) {
+	dropped := templateLineRegex.Match(data)
+	data = templateLineRegex.ReplaceAll(data, nil)
+	actions := make([]string, 0)
+	data = templateActionRegex.ReplaceAllFunc(data, func(action []byte) []byte {
+		actions = append(actions, string(action))
+		return []byte(templatePlaceholder(len(actions) - 1))
+	})
+	return data, actions, dropped
+}
+
+func templatePlaceholder(index int) string {
+	return fmt.Sprintf("__krezh_charts_template_%d__", index)
+}
+
+// restoreTemplateActions replaces the placeholders of stripTemplateActions in keys and strings of value
+func restoreTemplateActions(value any, actions []string) any {
+	switch v := value.(type) {
+	case string:
+		for i, action := range actions {
+			v = strings.ReplaceAll(v, templatePlaceholder(i), action)
+		}
+		return v
+	case map[string]any:
+		restored := make(map[string]any, len(v))
+		for key, item := range v {
+			restored[restoreTemplateActions(key, actions).(string)] = restoreTemplateActions(item, actions)
+		}
+		return restored
+	case []any:
+		restored := make([]any, len(v))
+		for i, item := range v {
+			restored[i] = restoreTemplateActions(item, actions)
+		}
+		return restored
+	default:
+		return value
+	}
+}
+
+func crdName(crd map[string]any) string {
+	metadata, _ := crd["metadata"].(map[string]any)
+	name, _ := metadata["name"].(string)
+	return name
+}
+
+func annotatedForPreservation(crd map[string]any) bool {
+	metadata, _ := crd["metadata"].(map[string]any)
+	annotations, _ := metadata["annotations"].(map[string]any)
+	return annotations[PreserveCrdAnnotation] == "true"
+}
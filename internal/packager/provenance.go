@@ -0,0 +1,55 @@
+package packager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/krezh/charts/internal/common"
+)
+
+const (
+	PublishManifestFileName = "publish-manifest.json"
+)
+
+// PublishRecord describes a chart pushed to an OCI registry
+type PublishRecord struct {
+	Chart     string    `json:"chart"`
+	Version   string    `json:"version"`
+	Ref       string    `json:"ref"`
+	Digest    string    `json:"digest"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// writePublishRecord stores a single record as <chart>-<version>.publish.json in targetDir
+func writePublishRecord(targetDir string, record *PublishRecord) error {
+	path := filepath.Join(targetDir, fmt.Sprintf("%s-%s.publish.json", record.Chart, record.Version))
+	return writeJSON(path, record)
+}
+
+// WritePublishManifest stores all records published during a run in targetDir
+func WritePublishManifest(targetDir string, records []*PublishRecord) (string, error) {
+	path := filepath.Join(targetDir, PublishManifestFileName)
+	if err := writeJSON(path, records); err != nil {
+		return "", err
+	}
+	common.Log.Infof("Wrote publish manifest with %d records to %s", len(records), path)
+	return path, nil
+}
+
+func writeJSON(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", path, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		common.Log.Errorf("failed to write %s: %v", path, err)
+		return err
+	}
+	return nil
+}
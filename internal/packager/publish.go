@@ -0,0 +1,71 @@
+package packager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/krezh/charts/internal/common"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// PublishChartDirs returns the chart directories to publish in order: the entries of
+// helm.publishCharts, chart directories or globs relative to SrcDir, or every chart directory in SrcDir when unset,
+// directories without Chart.yaml are skipped then but charts failing to load are an error
+func PublishChartDirs(settings *common.HelmSettings) ([]string, error) {
+	if len(settings.PublishCharts) == 0 {
+		files, err := os.ReadDir(settings.SrcDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read charts directory: %w", err)
+		}
+		chartDirs := make([]string, 0, len(files))
+		for _, file := range files {
+			if !file.IsDir() {
+				continue
+			}
+			chartDir := filepath.Join(settings.SrcDir, file.Name())
+			if !fileExists(filepath.Join(chartDir, chartutil.ChartfileName)) {
+				common.Log.Debugf("Skipping %s, not a chart: no %s", chartDir, chartutil.ChartfileName)
+				continue
+			}
+			if _, err := loader.LoadDir(chartDir); err != nil {
+				return nil, fmt.Errorf("failed to load chart %s: %w", chartDir, err)
+			}
+			chartDirs = append(chartDirs, chartDir)
+		}
+		return chartDirs, nil
+	}
+
+	chartDirs := make([]string, 0, len(settings.PublishCharts))
+	seen := make(map[string]bool)
+	for _, entry := range settings.PublishCharts {
+		matches, err := filepath.Glob(filepath.Join(settings.SrcDir, entry))
+		if err != nil {
+			return nil, fmt.Errorf("invalid publishCharts entry %s: %w", entry, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("publishCharts entry %s matches no chart in %s", entry, settings.SrcDir)
+		}
+		for _, chartDir := range matches {
+			if seen[chartDir] {
+				continue
+			}
+			if err := loadableChart(chartDir); err != nil {
+				return nil, fmt.Errorf("publishCharts entry %s: %s is not a chart: %w", entry, chartDir, err)
+			}
+			seen[chartDir] = true
+			chartDirs = append(chartDirs, chartDir)
+		}
+	}
+	return chartDirs, nil
+}
+
+// loadableChart tells why dir is not a chart Helm can load, nil for charts
+func loadableChart(dir string) error {
+	if !fileExists(filepath.Join(dir, chartutil.ChartfileName)) {
+		return fmt.Errorf("no %s", chartutil.ChartfileName)
+	}
+	_, err := loader.LoadDir(dir)
+	return err
+}
@@ -0,0 +1,60 @@
+package packager
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/krezh/charts/internal/common"
+)
+
+func TestPublishChartDirs(t *testing.T) {
+	testCases := map[string]struct {
+		publishCharts []string
+		brokenChart   bool
+		want          []string
+		wantErr       bool
+	}{
+		"all_charts":   {want: []string{"cdi", "kubevirt", "kubevirt-crds"}},
+		"broken_chart": {brokenChart: true, wantErr: true},
+		"listed_order": {publishCharts: []string{"kubevirt", "cdi"}, want: []string{"kubevirt", "cdi"}},
+		"glob":         {publishCharts: []string{"kubevirt*", "kubevirt"}, want: []string{"kubevirt", "kubevirt-crds"}},
+		"not_a_chart":  {publishCharts: []string{"docs"}, wantErr: true},
+		"no_match":     {publishCharts: []string{"missing"}, wantErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			srcDir := t.TempDir()
+			for _, name := range []string{"kubevirt", "kubevirt-crds", "cdi"} {
+				writeTestFile(t, filepath.Join(srcDir, name, "Chart.yaml"), "apiVersion: v2\nname: "+name+"\nversion: 1.0.0\n")
+			}
+			if tc.brokenChart {
+				writeTestFile(t, filepath.Join(srcDir, "broken", "Chart.yaml"), "name: [broken\n")
+			}
+			if err := os.MkdirAll(filepath.Join(srcDir, "docs"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			settings := &common.HelmSettings{SrcDir: srcDir, PublishCharts: tc.publishCharts}
+
+			//when
+			got, err := PublishChartDirs(settings)
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("PublishChartDirs() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			want := make([]string, 0, len(tc.want))
+			for _, dir := range tc.want {
+				want = append(want, filepath.Join(srcDir, dir))
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("PublishChartDirs() = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package packager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/krezh/charts/internal/common"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+const (
+	RawManifestsFileName  = "manifests.yaml"
+	KustomizationFileName = "kustomization.yaml"
+
+	kustomizeAPIVersion = "kustomize.config.k8s.io/v1beta1"
+)
+
+// NewRawManifests writes the manifests of a release as plain YAML or a kustomize base into <BuildDir>/<chartName>,
+// CRDs come first, the versions are kept in VERSION and APP_VERSION files for the next run, the returned Chart
+// only carries the versions for branch names and commit messages
+func NewRawManifests(log *logrus.Entry, helmSettings *common.HelmSettings, chartName string, m *common.Manifests) (*HelmizedManifests, error) {
+	dir := filepath.Join(helmSettings.BuildDir(), chartName)
+	// the directory is fully generated, resources dropped upstream must not survive
+	if err := os.RemoveAll(dir); err != nil {
+		log.Errorf("Failed to clear %s: %v", dir, err)
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Errorf("Failed to create %s: %v", dir, err)
+		return nil, err
+	}
+
+	manifests := append(append(make([]map[string]any, 0, len(m.Crds)+len(m.Manifests)), m.Crds...), m.Manifests...)
+	var err error
+	switch helmSettings.OutputFormat {
+	case common.OutputFormatManifests:
+		err = writeManifestsFile(dir, manifests)
+	case common.OutputFormatKustomize:
+		err = writeKustomizeBase(log, dir, chartName, manifests, helmSettings.MissingKind)
+	default:
+		err = fmt.Errorf("outputFormat '%s' is not a plain manifest format", helmSettings.OutputFormat)
+	}
+	if err == nil {
+		err = writeVersionFiles(dir, m.Version.String(), m.AppVersion)
+	}
+	if err != nil {
+		log.Errorf("Failed to write manifests of %s: %v", chartName, err)
+		return nil, err
+	}
+	log.Infof("Wrote %d manifests of %s as %s to %s", len(manifests), chartName, helmSettings.OutputFormat, dir)
+
+	return &HelmizedManifests{
+		Path: helmSettings.BuildDir(),
+		Chart: &chart.Chart{Metadata: &chart.Metadata{
+			Name:       chartName,
+			Version:    m.Version.String(),
+			AppVersion: m.AppVersion,
+		}},
+		Images:  ReferencedImages(m.Manifests),
+		Replace: true,
+	}, nil
+}
+
+// writeVersionFiles records the versions of the output as ResolveBaselineVersions reads them without a Chart.yaml
+func writeVersionFiles(dir, version, appVersion string) error {
+	if err := os.WriteFile(filepath.Join(dir, VersionFileName), []byte(version+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, AppVersionFileName), []byte(appVersion+"\n"), 0644)
+}
+
+// writeManifestsFile writes all manifests as a single multi-document YAML file
+func writeManifestsFile(dir string, manifests []map[string]any) error {
+	data := make([]byte, 0)
+	for i, manifest := range manifests {
+		manifestYAML, err := marshalManifest(manifest)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			data = append(data, []byte("---\n")...)
+		}
+		data = append(data, manifestYAML...)
+	}
+	return os.WriteFile(filepath.Join(dir, RawManifestsFileName), data, 0644)
+}
+
+// writeKustomizeBase writes one file per resource, named like per-resource templates, and a kustomization
+// listing them in order, manifests without kind fail or are dropped according to missingKind
+func writeKustomizeBase(log *logrus.Entry, dir, chartName string, manifests []map[string]any, missingKind string) error {
+	switch missingKind {
+	case "", common.MissingKindFail, common.MissingKindSkip, common.MissingKindWarn:
+	default:
+		return fmt.Errorf("unknown missingKind '%s'", missingKind)
+	}
+	files := make(map[string][]byte, len(manifests))
+	resources := make([]string, 0, len(manifests))
+	for i, manifest := range manifests {
+		manifestYAML, err := marshalManifest(manifest)
+		if err != nil {
+			return err
+		}
+		kind, ok := manifest[common.Kind].(string)
+		if !ok {
+			if err := dropWithoutKind(log, missingKind, chartName, i, manifestYAML); err != nil {
+				return err
+			}
+			continue
+		}
+		name, err := templateFileName(common.TemplateLayoutPerResource, kind, manifest, i)
+		if err != nil {
+			return err
+		}
+		fileName := name + ".yaml"
+		if existing, exists := files[fileName]; exists {
+			files[fileName] = append(append(existing, []byte("---\n")...), manifestYAML...)
+			continue
+		}
+		files[fileName] = manifestYAML
+		resources = append(resources, fileName)
+	}
+
+	for _, fileName := range resources {
+		if err := os.WriteFile(filepath.Join(dir, fileName), files[fileName], 0644); err != nil {
+			return err
+		}
+	}
+	kustomization, err := yaml.Marshal(map[string]any{
+		"apiVersion": kustomizeAPIVersion,
+		"kind":       "Kustomization",
+		"resources":  resources,
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, KustomizationFileName), kustomization, 0644)
+}
@@ -0,0 +1,173 @@
+package packager
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/krezh/charts/internal/common"
+	"gopkg.in/yaml.v3"
+)
+
+func TestNewRawManifests(t *testing.T) {
+	//given
+	testManifests, _ := common.NewManifests(readTestData(t), mustSemver("1.2.3"), "v1.0.0", new(map[string]any), new(map[string]any))
+	total := len(testManifests.Crds) + len(testManifests.Manifests)
+	settings := &common.HelmSettings{SrcDir: t.TempDir(), OutputFormat: common.OutputFormatManifests}
+
+	//when
+	charts, err := NewRawManifests(testLog(), settings, "test", testManifests)
+
+	//then
+	if err != nil {
+		t.Fatalf("NewRawManifests() error = %v", err)
+	}
+	if charts.Chart.Metadata.Version != "1.2.3" || charts.AppVersion() != "v1.0.0" {
+		t.Errorf("NewRawManifests() versions = %s, %s", charts.Chart.Metadata.Version, charts.AppVersion())
+	}
+	data, err := os.ReadFile(filepath.Join(settings.SrcDir, "test", RawManifestsFileName))
+	if err != nil {
+		t.Fatalf("NewRawManifests() did not write %s: %v", RawManifestsFileName, err)
+	}
+	docs := 0
+	for decoder := yaml.NewDecoder(bytes.NewReader(data)); ; docs++ {
+		var doc map[string]any
+		if err := decoder.Decode(&doc); err != nil {
+			break
+		}
+	}
+	if docs != total {
+		t.Errorf("NewRawManifests() wrote %d documents, want %d", docs, total)
+	}
+	if strings.Contains(string(data), "{{") {
+		t.Errorf("NewRawManifests() wrote Helm templates")
+	}
+}
+
+func TestNewRawManifestsBaselineVersions(t *testing.T) {
+	//given
+	testManifests, _ := common.NewManifests(readTestData(t), mustSemver("1.2.3"), "v1.0.0", new(map[string]any), new(map[string]any))
+	settings := &common.HelmSettings{SrcDir: t.TempDir(), OutputFormat: common.OutputFormatKustomize}
+
+	for run := 1; run <= 2; run++ {
+		//when
+		if _, err := NewRawManifests(testLog(), settings, "test", testManifests); err != nil {
+			t.Fatalf("NewRawManifests() run %d error = %v", run, err)
+		}
+		version, appVersion, err := ResolveBaselineVersions(settings.SrcDir, "test", "")
+
+		//then
+		if err != nil {
+			t.Fatalf("ResolveBaselineVersions() run %d error = %v", run, err)
+		}
+		// the release tracked by the output is recognized, the next run finds it up to date
+		if version != "1.2.3" || appVersion != "v1.0.0" {
+			t.Errorf("ResolveBaselineVersions() run %d = %s, %s, want 1.2.3, v1.0.0", run, version, appVersion)
+		}
+	}
+}
+
+func TestNewRawManifestsKustomize(t *testing.T) {
+	//given
+	testManifests, _ := common.NewManifests(readTestData(t), mustSemver("1.2.3"), "v1.0.0", new(map[string]any), new(map[string]any))
+	settings := &common.HelmSettings{SrcDir: t.TempDir(), OutputFormat: common.OutputFormatKustomize}
+	dir := filepath.Join(settings.SrcDir, "test")
+	writeTestFile(t, filepath.Join(dir, "stale.yaml"), "kind: Stale\n")
+
+	//when
+	_, err := NewRawManifests(testLog(), settings, "test", testManifests)
+
+	//then
+	if err != nil {
+		t.Fatalf("NewRawManifests() error = %v", err)
+	}
+	var kustomization struct {
+		Kind      string   `yaml:"kind"`
+		Resources []string `yaml:"resources"`
+	}
+	data, err := os.ReadFile(filepath.Join(dir, KustomizationFileName))
+	if err != nil {
+		t.Fatalf("NewRawManifests() did not write %s: %v", KustomizationFileName, err)
+	}
+	if err := yaml.Unmarshal(data, &kustomization); err != nil {
+		t.Fatalf("NewRawManifests() wrote invalid kustomization: %v", err)
+	}
+	if kustomization.Kind != "Kustomization" || len(kustomization.Resources) == 0 {
+		t.Fatalf("NewRawManifests() kustomization = %+v", kustomization)
+	}
+	if !strings.HasPrefix(kustomization.Resources[0], "customresourcedefinition-") {
+		t.Errorf("NewRawManifests() first resource = %s, want a CRD", kustomization.Resources[0])
+	}
+	for _, resource := range kustomization.Resources {
+		if !fileExists(filepath.Join(dir, resource)) {
+			t.Errorf("NewRawManifests() listed missing resource %s", resource)
+		}
+	}
+	if fileExists(filepath.Join(dir, "stale.yaml")) {
+		t.Errorf("NewRawManifests() kept stale resource file")
+	}
+}
+
+func TestNewRawManifestsKustomizeMissingKind(t *testing.T) {
+	testCases := map[string]struct {
+		missingKind   string
+		wantResources []string
+		wantErr       bool
+	}{
+		"fail":    {wantErr: true},
+		"skip":    {missingKind: common.MissingKindSkip, wantResources: []string{"configmap-operator.yaml"}},
+		"warn":    {missingKind: common.MissingKindWarn, wantResources: []string{"configmap-operator.yaml"}},
+		"unknown": {missingKind: "ignore", wantErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			m := &common.Manifests{Manifests: []map[string]any{
+				{"metadata": map[string]any{"name": "broken"}},
+				{"kind": "ConfigMap", "metadata": map[string]any{"name": "operator"}},
+			}}
+			settings := &common.HelmSettings{SrcDir: t.TempDir(), OutputFormat: common.OutputFormatKustomize, MissingKind: tc.missingKind}
+
+			//when
+			_, err := NewRawManifests(testLog(), settings, "test", m)
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("NewRawManifests() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			var kustomization struct {
+				Resources []string `yaml:"resources"`
+			}
+			data, _ := os.ReadFile(filepath.Join(settings.SrcDir, "test", KustomizationFileName))
+			if err := yaml.Unmarshal(data, &kustomization); err != nil {
+				t.Fatalf("NewRawManifests() wrote invalid kustomization: %v", err)
+			}
+			if !reflect.DeepEqual(kustomization.Resources, tc.wantResources) {
+				t.Errorf("NewRawManifests() resources = %v, want %v", kustomization.Resources, tc.wantResources)
+			}
+		})
+	}
+}
+
+func TestRawModifications(t *testing.T) {
+	//given
+	mods := []common.Modification{
+		{Expression: `.metadata.labels.team = "platform"`},
+		{Expression: `.metadata.namespace |= "{{ .Release.Namespace }}"`},
+		{Expression: `.spec.replicas |= "{{ .Values.replicas }}"`, ValuesSelector: []string{".spec.replicas"}},
+	}
+
+	//when
+	raw := rawModifications(testLog(), mods)
+
+	//then
+	if !reflect.DeepEqual(raw, mods[:1]) {
+		t.Errorf("rawModifications() = %v, want %v", raw, mods[:1])
+	}
+}
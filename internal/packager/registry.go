@@ -0,0 +1,108 @@
+package packager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/krezh/charts/internal/common"
+	"helm.sh/helm/v3/pkg/registry"
+	"oras.land/oras-go/v2/errdef"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/credentials"
+	"oras.land/oras-go/v2/registry/remote/retry"
+)
+
+// ErrVersionNotFound is returned by Delete when the chart version is not in the registry
+var ErrVersionNotFound = errors.New("chart version not found")
+
+// ListVersions returns every version of chartName published to remote, sorted by semver,
+// a chart that was never published has no versions
+func ListVersions(ctx context.Context, remote, chartName string, settings *common.HelmSettings) ([]string, error) {
+	if !strings.HasPrefix(remote, "oci://") {
+		return nil, fmt.Errorf("remote must start with oci://, got: %s", remote)
+	}
+	rc, err := registry.NewClient(registryClientOptions(ctx, &settings.RemoteAuth)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create registry client: %w", err)
+	}
+	return listVersions(rc, chartRepositoryRef(remote, chartName))
+}
+
+func listVersions(rc *registry.Client, ref string) ([]string, error) {
+	tags, err := registryTags(rc, ref)
+	if err != nil {
+		return nil, err
+	}
+	// the registry client only returns semver tags
+	slices.SortFunc(tags, func(a, b string) int {
+		return semver.MustParse(a).Compare(semver.MustParse(b))
+	})
+	return tags, nil
+}
+
+// Delete removes the chart version ref, e.g. oci://ghcr.io/krezh/charts/kubevirt:1.2.3, from the OCI registry,
+// registries without manifest deletion reject it, other tags of the same manifest are removed along with it
+func Delete(ctx context.Context, ref string, settings *common.HelmSettings) error {
+	if !strings.HasPrefix(ref, "oci://") {
+		return fmt.Errorf("ref must start with oci://, got: %s", ref)
+	}
+	// Helm stores + of semver build metadata as _ in tags
+	repo, err := remote.NewRepository(strings.ReplaceAll(strings.TrimPrefix(ref, "oci://"), "+", "_"))
+	if err != nil {
+		return fmt.Errorf("invalid ref %s: %w", ref, err)
+	}
+	if repo.Reference.Reference == "" {
+		return fmt.Errorf("ref %s has no version", ref)
+	}
+	client, err := newOrasClient(repo.Reference.Registry, &settings.RemoteAuth)
+	if err != nil {
+		return err
+	}
+	repo.Client = client
+	return deleteVersion(ctx, repo)
+}
+
+// deleteVersion resolves the tag of repo's reference to its manifest and deletes the manifest
+func deleteVersion(ctx context.Context, repo *remote.Repository) error {
+	tag := repo.Reference.Reference
+	ref := fmt.Sprintf("%s:%s", repo.Reference.Repository, tag)
+	desc, err := repo.Resolve(ctx, tag)
+	if errors.Is(err, errdef.ErrNotFound) {
+		return fmt.Errorf("%w: %s", ErrVersionNotFound, ref)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", ref, err)
+	}
+	common.Log.Warnf("Deleting %s (%s) from the registry %s", ref, desc.Digest, repo.Reference.Registry)
+	if err := repo.Delete(ctx, desc); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", ref, err)
+	}
+	return nil
+}
+
+// newOrasClient authenticates with the same credentials as the Helm registry client,
+// Helm's client doesn't support deleting manifests
+func newOrasClient(host string, remoteAuth *common.RemoteAuth) (*auth.Client, error) {
+	client := &auth.Client{
+		Client: &http.Client{Transport: retry.NewTransport(common.HTTPClient.Transport)},
+		Cache:  auth.NewCache(),
+	}
+	switch {
+	case remoteAuth.Username != "":
+		client.Credential = auth.StaticCredential(host, auth.Credential{Username: remoteAuth.Username, Password: remoteAuth.Password})
+	case remoteAuth.CredentialsFile != "":
+		store, err := credentials.NewStore(remoteAuth.CredentialsFile, credentials.StoreOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read credentials file %s: %w", remoteAuth.CredentialsFile, err)
+		}
+		client.Credential = credentials.Credential(store)
+	}
+	client.SetUserAgent(common.UserAgent())
+	return client, nil
+}
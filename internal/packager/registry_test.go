@@ -0,0 +1,202 @@
+package packager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/krezh/charts/internal/common"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/registry"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+func TestListVersions(t *testing.T) {
+	testCases := map[string]struct {
+		status int
+		tags   []string
+		want   []string
+	}{
+		"semver_order":   {status: http.StatusOK, tags: []string{"1.10.0", "1.2.0", "latest", "1.10.0-rc.1"}, want: []string{"1.2.0", "1.10.0-rc.1", "1.10.0"}},
+		"not_pushed_yet": {status: http.StatusNotFound, want: []string{}},
+		"build_metadata": {status: http.StatusOK, tags: []string{"1.0.0_build.2", "0.9.0"}, want: []string{"0.9.0", "1.0.0+build.2"}},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v2/charts/kubevirt/tags/list" || tc.status != http.StatusOK {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(map[string]any{"name": "charts/kubevirt", "tags": tc.tags})
+			}))
+			defer server.Close()
+			rc, err := registry.NewClient(registry.ClientOptPlainHTTP())
+			if err != nil {
+				t.Fatal(err)
+			}
+			remote := "oci://" + strings.TrimPrefix(server.URL, "http://") + "/charts"
+
+			//when
+			versions, err := listVersions(rc, chartRepositoryRef(remote, "kubevirt"))
+
+			//then
+			if err != nil {
+				t.Fatalf("listVersions() error = %v", err)
+			}
+			if !reflect.DeepEqual(versions, tc.want) {
+				t.Errorf("listVersions() = %v, want %v", versions, tc.want)
+			}
+		})
+	}
+}
+
+func TestDeleteVersion(t *testing.T) {
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(manifest))
+	testCases := map[string]struct {
+		version     string
+		wantDeleted bool
+		wantErr     error
+	}{
+		"existing": {version: "1.0.0", wantDeleted: true},
+		"missing":  {version: "2.0.0", wantErr: ErrVersionNotFound},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			deleted := false
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/v2/charts/kubevirt/manifests/1.0.0", "/v2/charts/kubevirt/manifests/" + digest:
+					if r.Method == http.MethodDelete {
+						deleted = true
+						w.WriteHeader(http.StatusAccepted)
+						return
+					}
+					w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+					w.Header().Set("Docker-Content-Digest", digest)
+					w.Header().Set("Content-Length", strconv.Itoa(len(manifest)))
+					w.Write(manifest)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+			repo, err := remote.NewRepository(strings.TrimPrefix(server.URL, "https://") + "/charts/kubevirt:" + tc.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			repo.Client = server.Client()
+
+			//when
+			err = deleteVersion(context.Background(), repo)
+
+			//then
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("deleteVersion() error = %v, want %v", err, tc.wantErr)
+			}
+			if deleted != tc.wantDeleted {
+				t.Errorf("deleteVersion() deleted = %v, want %v", deleted, tc.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestDeleteRejectsInvalidRefs(t *testing.T) {
+	testCases := map[string]string{
+		"no_scheme":  "ghcr.io/krezh/charts/kubevirt:1.0.0",
+		"no_version": "oci://ghcr.io/krezh/charts/kubevirt",
+	}
+	for name, ref := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//when
+			err := Delete(context.Background(), ref, &common.HelmSettings{})
+
+			//then
+			if err == nil {
+				t.Errorf("Delete() expected error for %s", ref)
+			}
+		})
+	}
+}
+
+func TestVerifyPushed(t *testing.T) {
+	//given
+	chartPath, err := chartutil.Create("test", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := Package(context.Background(), chartPath, &common.HelmSettings{TargetDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chartData, _ := os.ReadFile(archive)
+	configData := []byte(`{"apiVersion":"v2","name":"test","version":"0.1.0"}`)
+	blobs := map[string][]byte{digestOf(chartData): chartData, digestOf(configData): configData}
+	manifest, _ := json.Marshal(map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+		"config":        map[string]any{"mediaType": registry.ConfigMediaType, "digest": digestOf(configData), "size": len(configData)},
+		"layers":        []any{map[string]any{"mediaType": registry.ChartLayerMediaType, "digest": digestOf(chartData), "size": len(chartData)}},
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, mediaType := []byte(nil), "application/octet-stream"
+		if r.URL.Path == "/v2/charts/test/manifests/0.1.0" || r.URL.Path == "/v2/charts/test/manifests/"+digestOf(manifest) {
+			content, mediaType = manifest, "application/vnd.oci.image.manifest.v1+json"
+		} else if digest, ok := strings.CutPrefix(r.URL.Path, "/v2/charts/test/blobs/"); ok {
+			content = blobs[digest]
+		}
+		if content == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", mediaType)
+		w.Header().Set("Docker-Content-Digest", digestOf(content))
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		if r.Method != http.MethodHead {
+			w.Write(content)
+		}
+	}))
+	defer server.Close()
+	rc, err := registry.NewClient(registry.ClientOptPlainHTTP())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := "oci://" + strings.TrimPrefix(server.URL, "http://") + "/charts/test:0.1.0"
+	testCases := map[string]struct {
+		version     string
+		chartDigest string
+		wantErr     error
+	}{
+		"verified":      {version: "0.1.0", chartDigest: digestOf(chartData)},
+		"stale_content": {version: "0.1.0", chartDigest: digestOf([]byte("pushed")), wantErr: ErrPushNotVerified},
+		"other_version": {version: "0.2.0", chartDigest: digestOf(chartData), wantErr: ErrPushNotVerified},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//when
+			err := verifyPushed(rc, ref, tc.version, tc.chartDigest)
+
+			//then
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("verifyPushed() error = %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func digestOf(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
@@ -0,0 +1,84 @@
+package packager
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/krezh/charts/internal/common"
+)
+
+const bindingKinds = "^(RoleBinding|ClusterRoleBinding)$"
+
+// renameModifications derives the modifications renaming the resources of each rule and, best-effort, the common
+// references to them: RBAC subjects and roleRefs, serviceAccountNames of workloads and ownerReferences
+func renameModifications(renames []common.ResourceRename) ([]common.Modification, error) {
+	mods := make([]common.Modification, 0, len(renames))
+	for _, rename := range renames {
+		if rename.Kind == "" || rename.From == "" || rename.To == "" {
+			return nil, fmt.Errorf("rename needs kind, from and to, got kind '%s', from '%s' and to '%s'", rename.Kind, rename.From, rename.To)
+		}
+		pattern := "^(?:" + rename.From + ")$"
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid rename regex '%s': %w", rename.From, err)
+		}
+		matches := fmt.Sprintf("test(%q)", pattern)
+		renamed := fmt.Sprintf("sub(%q; %q)", pattern, rename.To)
+
+		mods = append(mods,
+			common.Modification{
+				Expression: ".metadata.name |= " + renamed,
+				Kind:       "^" + regexp.QuoteMeta(rename.Kind) + "$",
+				When:       hasPath("metadata", "name") + " and (.metadata.name | " + matches + ")",
+			},
+			referenceModification([]string{"metadata", "ownerReferences"}, "", rename.Kind, matches, renamed),
+		)
+		switch rename.Kind {
+		case "ServiceAccount":
+			mods = append(mods, referenceModification([]string{"subjects"}, bindingKinds, rename.Kind, matches, renamed))
+			for _, kind := range slices.Sorted(maps.Keys(podSpecPaths)) {
+				path := append(slices.Clone(podSpecPaths[kind]), "serviceAccountName")
+				field := "." + strings.Join(path, ".")
+				mods = append(mods, common.Modification{
+					Expression: field + " |= " + renamed,
+					Kind:       "^" + kind + "$",
+					When:       hasPath(path...) + " and (" + field + " | " + matches + ")",
+				})
+			}
+		case "Role", "ClusterRole":
+			mods = append(mods, common.Modification{
+				Expression: ".roleRef.name |= " + renamed,
+				Kind:       bindingKinds,
+				When:       fmt.Sprintf("%s and .roleRef.kind == %q and (.roleRef.name | %s)", hasPath("roleRef", "name"), rename.Kind, matches),
+			})
+		}
+	}
+	return mods, nil
+}
+
+// referenceModification renames the references to kind in the list at path, e.g. subjects of a RoleBinding
+func referenceModification(path []string, manifestKind, kind, matches, renamed string) common.Modification {
+	selected := fmt.Sprintf(".%s[] | select(.kind == %q and (.name | %s))", strings.Join(path, "."), kind, matches)
+	return common.Modification{
+		Expression: fmt.Sprintf("(%s | .name) |= %s", selected, renamed),
+		Kind:       manifestKind,
+		When:       fmt.Sprintf("%s and ([%s] | length > 0)", hasPath(path...), selected),
+	}
+}
+
+// hasPath returns the yq condition that the nested keys exist, conditions traversing missing keys would add them
+// to the manifest as null
+func hasPath(keys ...string) string {
+	conditions := make([]string, 0, len(keys))
+	for i, key := range keys {
+		parent := "." + strings.Join(keys[:i], ".")
+		if i == 0 {
+			conditions = append(conditions, fmt.Sprintf("has(%q)", key))
+			continue
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s | has(%q))", parent, key))
+	}
+	return strings.Join(conditions, " and ")
+}
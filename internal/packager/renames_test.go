@@ -0,0 +1,113 @@
+package packager
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/krezh/charts/internal/common"
+)
+
+func TestRenameModifications(t *testing.T) {
+	//given
+	manifests := &common.Manifests{
+		Manifests: []map[string]any{
+			{"kind": "ServiceAccount", "metadata": map[string]any{"name": "operator"}},
+			{"kind": "ClusterRole", "metadata": map[string]any{"name": "operator"}},
+			{
+				"kind":     "ClusterRoleBinding",
+				"metadata": map[string]any{"name": "operator"},
+				"roleRef":  map[string]any{"kind": "ClusterRole", "name": "operator"},
+				"subjects": []any{
+					map[string]any{"kind": "ServiceAccount", "name": "operator"},
+					map[string]any{"kind": "User", "name": "operator"},
+				},
+			},
+			{
+				"kind":     "Deployment",
+				"metadata": map[string]any{"name": "operator"},
+				"spec": map[string]any{"template": map[string]any{"spec": map[string]any{
+					"serviceAccountName": "operator",
+				}}},
+			},
+			{
+				"kind": "ConfigMap",
+				"metadata": map[string]any{
+					"name":            "operator",
+					"ownerReferences": []any{map[string]any{"kind": "Deployment", "name": "operator"}},
+				},
+			},
+			{"kind": "Service", "metadata": map[string]any{"name": "operator"}},
+			{"kind": "Job", "metadata": map[string]any{"name": "migrate"}, "spec": map[string]any{"template": map[string]any{}}},
+		},
+	}
+	renames := []common.ResourceRename{
+		{Kind: "ServiceAccount", From: "op(.*)", To: "kv-op${1}"},
+		{Kind: "ClusterRole", From: "operator", To: "kv-operator-role"},
+		{Kind: "Deployment", From: "operator", To: "kv-operator"},
+	}
+
+	//when
+	mods, err := renameModifications(renames)
+	if err != nil {
+		t.Fatalf("renameModifications() error = %v", err)
+	}
+	renamed, err := ChartModifier.ParametrizeManifests(manifests, &mods)
+
+	//then
+	if err != nil {
+		t.Fatalf("ParametrizeManifests() error = %v", err)
+	}
+	want := []map[string]any{
+		{"kind": "ServiceAccount", "metadata": map[string]any{"name": "kv-operator"}},
+		{"kind": "ClusterRole", "metadata": map[string]any{"name": "kv-operator-role"}},
+		{
+			"kind":     "ClusterRoleBinding",
+			"metadata": map[string]any{"name": "operator"},
+			"roleRef":  map[string]any{"kind": "ClusterRole", "name": "kv-operator-role"},
+			"subjects": []any{
+				map[string]any{"kind": "ServiceAccount", "name": "kv-operator"},
+				map[string]any{"kind": "User", "name": "operator"},
+			},
+		},
+		{
+			"kind":     "Deployment",
+			"metadata": map[string]any{"name": "kv-operator"},
+			"spec": map[string]any{"template": map[string]any{"spec": map[string]any{
+				"serviceAccountName": "kv-operator",
+			}}},
+		},
+		{
+			"kind": "ConfigMap",
+			"metadata": map[string]any{
+				"name":            "operator",
+				"ownerReferences": []any{map[string]any{"kind": "Deployment", "name": "kv-operator"}},
+			},
+		},
+		{"kind": "Service", "metadata": map[string]any{"name": "operator"}},
+		{"kind": "Job", "metadata": map[string]any{"name": "migrate"}, "spec": map[string]any{"template": map[string]any{}}},
+	}
+	for i := range want {
+		if !reflect.DeepEqual(renamed.Manifests[i], want[i]) {
+			t.Errorf("ParametrizeManifests() manifest %d = %v, want %v", i, renamed.Manifests[i], want[i])
+		}
+	}
+}
+
+func TestRenameModificationsInvalid(t *testing.T) {
+	testCases := map[string]common.ResourceRename{
+		"missing_kind":  {From: "operator", To: "kv-operator"},
+		"missing_to":    {Kind: "Service", From: "operator"},
+		"invalid_regex": {Kind: "Service", From: "operator(", To: "kv-operator"},
+	}
+	for name, rename := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//when
+			_, err := renameModifications([]common.ResourceRename{rename})
+
+			//then
+			if err == nil {
+				t.Errorf("renameModifications() expected error for %+v", rename)
+			}
+		})
+	}
+}
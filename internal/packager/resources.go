@@ -0,0 +1,127 @@
+package packager
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/krezh/charts/internal/common"
+)
+
+// containerValue is a value extracted from a container of a workload manifest
+type containerValue struct {
+	kind      any
+	workload  string
+	name      string
+	container map[string]any
+	value     any
+}
+
+// ParametrizeResources replaces the resources of every container in workload manifests with resources.<container>
+// values, the upstream requests and limits become the default values, containers without resources get empty
+// defaults so they can still be tuned, same-named containers share their values unless they differ upstream, then
+// each gets resources.<workload>.<container>
+func (m *modifier) ParametrizeResources(manifests *common.Manifests) (*common.Manifests, error) {
+	found := make([]containerValue, 0)
+	for _, manifest := range manifests.Manifests {
+		metadata, _ := manifest["metadata"].(map[string]any)
+		workload, _ := metadata["name"].(string)
+		for _, container := range workloadContainers(manifest) {
+			name, ok := container["name"].(string)
+			if !ok || name == "" {
+				continue
+			}
+			if templated, ok := container["resources"].(string); ok && strings.Contains(templated, "{{") {
+				continue // already templated by a modification
+			}
+			resources, _ := container["resources"].(map[string]any)
+			if resources == nil {
+				resources = map[string]any{}
+			}
+			found = append(found, containerValue{kind: manifest[common.Kind], workload: workload, name: name, container: container, value: resources})
+		}
+	}
+	if len(found) == 0 {
+		m.logger().Infof("No workload containers found to parametrize resources of")
+		return manifests, nil
+	}
+
+	extracted, paths, err := keyContainerValues(found)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parametrize resources, %w", err)
+	}
+	for i, c := range found {
+		c.container["resources"] = resourcesTemplate(paths[i])
+		m.logger().Debugf("Parametrized resources of %v container %s as resources.%s", c.kind, c.name, strings.Join(paths[i], "."))
+	}
+
+	resourceValues := map[string]any{"resources": extracted}
+	return &common.Manifests{
+		Crds:         manifests.Crds,
+		Manifests:    manifests.Manifests,
+		Version:      manifests.Version,
+		AppVersion:   manifests.AppVersion,
+		Values:       *common.DeepMerge(&manifests.Values, &resourceValues),
+		CrdsValues:   manifests.CrdsValues,
+		ValuesSchema: manifests.ValuesSchema,
+		ChartType:    manifests.ChartType,
+	}, nil
+}
+
+// resourcesTemplate renders the resources at path below resources inline as JSON, valid YAML at any indentation
+func resourcesTemplate(path []string) string {
+	return fmt.Sprintf("{{ index .Values.resources %s | toJson }}", indexKeys(path))
+}
+
+// indexKeys quotes the keys of a values path as index arguments, index allows keys that aren't template
+// identifiers, e.g. virt-operator
+func indexKeys(path []string) string {
+	quoted := make([]string, len(path))
+	for i, key := range path {
+		quoted[i] = strconv.Quote(key)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// keyContainerValues nests the values of containers by container name, same-named containers share their key
+// unless their values differ, then each is nested by workload and container name, the values path of every
+// container is returned in the order of values
+func keyContainerValues(values []containerValue) (map[string]any, [][]string, error) {
+	conflicting := make(map[string]bool)
+	byName := make(map[string]any)
+	for _, v := range values {
+		if existing, ok := byName[v.name]; ok && !reflect.DeepEqual(existing, v.value) {
+			conflicting[v.name] = true
+		}
+		byName[v.name] = v.value
+	}
+
+	nested := make(map[string]any)
+	workloads := make(map[string]bool)
+	paths := make([][]string, 0, len(values))
+	for _, v := range values {
+		if !conflicting[v.name] {
+			if workloads[v.name] {
+				return nil, nil, fmt.Errorf("container %s has the name of workload %s whose containers are keyed by workload", v.name, v.name)
+			}
+			nested[v.name] = v.value
+			paths = append(paths, []string{v.name})
+			continue
+		}
+		if _, ok := nested[v.workload]; ok && !workloads[v.workload] {
+			return nil, nil, fmt.Errorf("container %s has the name of workload %s whose containers are keyed by workload", v.workload, v.workload)
+		}
+		if !workloads[v.workload] {
+			nested[v.workload] = make(map[string]any)
+			workloads[v.workload] = true
+		}
+		containers := nested[v.workload].(map[string]any)
+		if existing, ok := containers[v.name]; ok && !reflect.DeepEqual(existing, v.value) {
+			return nil, nil, fmt.Errorf("containers %s of workload %s differ: %v and %v", v.name, v.workload, existing, v.value)
+		}
+		containers[v.name] = v.value
+		paths = append(paths, []string{v.workload, v.name})
+	}
+	return nested, paths, nil
+}
@@ -0,0 +1,116 @@
+package packager
+
+import (
+	"testing"
+
+	"github.com/krezh/charts/internal/common"
+)
+
+func TestParametrizeResources(t *testing.T) {
+	//given
+	assets := readTestData(t)
+	delete(*assets, "cdi-operator.yaml")
+	delete(*assets, "cdi-cr.yaml")
+	testManifests, _ := common.NewManifests(assets, mustSemver("0.0.1"), "0.0.1", new(map[string]any), new(map[string]any))
+
+	//when
+	modified, err := ChartModifier.ParametrizeResources(testManifests)
+
+	//then
+	if err != nil {
+		t.Fatalf("ParametrizeResources() error = %v", err)
+	}
+	expectedValues := map[string]any{
+		"resources": map[string]any{
+			"virt-operator": map[string]any{
+				"requests": map[string]any{"cpu": "10m", "memory": "450Mi"},
+			},
+		},
+	}
+	if !mapContains(&modified.Values, &expectedValues, true) {
+		t.Errorf("ParametrizeResources() values = %v, want %v", modified.Values, expectedValues)
+	}
+	for _, m := range modified.Manifests {
+		for _, container := range workloadContainers(m) {
+			if want := resourcesTemplate([]string{container["name"].(string)}); container["resources"] != want {
+				t.Errorf("ParametrizeResources() resources = %v, want %v", container["resources"], want)
+			}
+		}
+	}
+}
+
+func TestParametrizeResourcesPerContainer(t *testing.T) {
+	deployment := func(name string, containers ...any) map[string]any {
+		return map[string]any{
+			common.Kind: "Deployment",
+			"metadata":  map[string]any{"name": name},
+			"spec":      map[string]any{"template": map[string]any{"spec": map[string]any{"containers": containers}}},
+		}
+	}
+	manager := func() map[string]any {
+		return map[string]any{"name": "manager", "resources": map[string]any{"limits": map[string]any{"memory": "128Mi"}}}
+	}
+	testCases := map[string]struct {
+		manifests  []map[string]any
+		wantValues map[string]any
+		wantErr    bool
+	}{
+		"multi_container": {
+			manifests: []map[string]any{deployment("operator", manager(), map[string]any{"name": "proxy"})},
+			wantValues: map[string]any{
+				"manager": map[string]any{"limits": map[string]any{"memory": "128Mi"}},
+				"proxy":   map[string]any{},
+			},
+		},
+		"shared_name": {
+			manifests:  []map[string]any{deployment("a", manager()), deployment("b", manager())},
+			wantValues: map[string]any{"manager": map[string]any{"limits": map[string]any{"memory": "128Mi"}}},
+		},
+		"conflicting_names": {
+			manifests: []map[string]any{deployment("a", manager()), deployment("b", map[string]any{"name": "manager"})},
+			wantValues: map[string]any{
+				"a": map[string]any{"manager": map[string]any{"limits": map[string]any{"memory": "128Mi"}}},
+				"b": map[string]any{"manager": map[string]any{}},
+			},
+		},
+		"container_named_like_workload": {
+			manifests: []map[string]any{
+				deployment("a", manager()), deployment("b", map[string]any{"name": "manager"}), deployment("c", map[string]any{"name": "a"}),
+			},
+			wantErr: true,
+		},
+		"conflicting_within_workload": {
+			manifests: []map[string]any{deployment("a", manager(), map[string]any{"name": "manager"})},
+			wantErr:   true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//when
+			modified, err := ChartModifier.ParametrizeResources(&common.Manifests{Manifests: tc.manifests})
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ParametrizeResources() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			want := map[string]any{"resources": tc.wantValues}
+			if !mapContains(&modified.Values, &want, true) || !mapContains(&want, &modified.Values, true) {
+				t.Errorf("ParametrizeResources() values = %v, want %v", modified.Values, want)
+			}
+			for _, m := range modified.Manifests {
+				for _, container := range workloadContainers(m) {
+					path := []string{container["name"].(string)}
+					if _, shared := tc.wantValues[path[0]]; !shared {
+						path = append([]string{m["metadata"].(map[string]any)["name"].(string)}, path...)
+					}
+					if want := resourcesTemplate(path); container["resources"] != want {
+						t.Errorf("ParametrizeResources() resources = %v, want %v", container["resources"], want)
+					}
+				}
+			}
+		})
+	}
+}
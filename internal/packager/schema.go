@@ -0,0 +1,91 @@
+package packager
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/krezh/charts/internal/common"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	jsonSchemaDraft = "https://json-schema.org/draft-07/schema#"
+)
+
+// customResourceValues derives a modification per configured custom resource kind, moving the CR's spec
+// into values, and a values schema built from the spec schema of its CRD among crds, those shipped in the same release
+func customResourceValues(log *logrus.Entry, manifests *common.Manifests, crds []map[string]any, crValues []common.CustomResourceValues) ([]common.Modification, map[string]any, error) {
+	mods := make([]common.Modification, 0, len(crValues))
+	properties := make(map[string]any)
+	for _, crv := range crValues {
+		cr := findManifest(manifests.Manifests, crv.Kind)
+		if cr == nil {
+			return nil, nil, fmt.Errorf("no custom resource of kind %s found", crv.Kind)
+		}
+		apiVersion, _ := cr["apiVersion"].(string)
+		specSchema, err := crdSpecSchema(crds, crv.Kind, apiVersion)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		key := crv.ValuesKey
+		if key == "" {
+			key = strings.ToLower(crv.Kind[:1]) + crv.Kind[1:]
+		}
+		mods = append(mods, common.Modification{
+			Expression:     fmt.Sprintf(".spec |= \"{{ .Values.%s | toYaml | nindent 4 }}\"", key),
+			ValuesSelector: []string{".spec"},
+			Kind:           "^" + regexp.QuoteMeta(crv.Kind) + "$",
+		})
+		properties[key] = specSchema
+		log.Infof("Extracting spec of %s into values.%s using its CRD schema", crv.Kind, key)
+	}
+
+	schema := map[string]any{
+		"$schema":    jsonSchemaDraft,
+		"type":       "object",
+		"properties": properties,
+	}
+	return mods, schema, nil
+}
+
+func findManifest(manifests []map[string]any, kind string) map[string]any {
+	for _, manifest := range manifests {
+		if manifest[common.Kind] == kind {
+			return manifest
+		}
+	}
+	return nil
+}
+
+// crdSpecSchema returns the openAPIV3Schema of .spec for the CRD version serving kind in apiVersion
+func crdSpecSchema(crds []map[string]any, kind, apiVersion string) (map[string]any, error) {
+	group, version, found := strings.Cut(apiVersion, "/")
+	if !found {
+		return nil, fmt.Errorf("custom resource %s has no API group in apiVersion %s", kind, apiVersion)
+	}
+	for _, crd := range crds {
+		spec, _ := crd["spec"].(map[string]any)
+		names, _ := spec["names"].(map[string]any)
+		if spec["group"] != group || names["kind"] != kind {
+			continue
+		}
+		versions, _ := spec["versions"].([]any)
+		for _, v := range versions {
+			crdVersion, _ := v.(map[string]any)
+			if crdVersion["name"] != version {
+				continue
+			}
+			schema, _ := crdVersion["schema"].(map[string]any)
+			openAPI, _ := schema["openAPIV3Schema"].(map[string]any)
+			properties, _ := openAPI["properties"].(map[string]any)
+			specSchema, ok := properties["spec"].(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("CRD of %s %s has no spec schema", kind, apiVersion)
+			}
+			return specSchema, nil
+		}
+	}
+	return nil, fmt.Errorf("no CRD with a schema for %s %s found in release", kind, apiVersion)
+}
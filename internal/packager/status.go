@@ -0,0 +1,54 @@
+package packager
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/krezh/charts/internal/common"
+	ghup "github.com/krezh/charts/internal/updater/github"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// ReleaseStatus reports whether a committed chart is behind its upstream release,
+// CurrentVersion is the appVersion of the committed chart, empty when the chart does not exist yet
+type ReleaseStatus struct {
+	ChartName      string
+	CurrentVersion string
+	LatestVersion  string
+	UpToDate       bool
+}
+
+// ReleaseStatuses compares every configured release against its latest upstream release,
+// only release metadata is fetched, nothing is downloaded or generated
+func ReleaseStatuses(ctx context.Context, config *common.Config) ([]ReleaseStatus, error) {
+	statuses := make([]ReleaseStatus, 0, len(config.Releases))
+	for i := range config.Releases {
+		release := &config.Releases[i]
+		chartDir := config.Helm.SrcDir
+		if umbrella := config.UmbrellaFor(release.ChartName); umbrella != nil {
+			chartDir = SubchartSrcDir(config.Helm.SrcDir, umbrella.ChartName)
+		}
+
+		currentVersion := ""
+		if fileExists(filepath.Join(chartDir, release.ChartName, chartutil.ChartfileName)) || fileExists(filepath.Join(chartDir, release.ChartName, VersionFileName)) {
+			_, appVersion, err := ResolveBaselineVersions(chartDir, release.ChartName, "")
+			if err != nil {
+				return nil, err
+			}
+			currentVersion = appVersion
+		}
+
+		latestVersion, err := ghup.LatestReleaseVersion(ctx, release, config.PullRequest.AuthToken)
+		if err != nil {
+			return nil, err
+		}
+
+		statuses = append(statuses, ReleaseStatus{
+			ChartName:      release.ChartName,
+			CurrentVersion: currentVersion,
+			LatestVersion:  latestVersion,
+			UpToDate:       currentVersion == latestVersion || ghup.IgnoredPrerelease(release, latestVersion),
+		})
+	}
+	return statuses, nil
+}
@@ -0,0 +1,194 @@
+package packager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/krezh/charts/internal/common"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+const (
+	defaultUmbrellaVersion = "0.1.0"
+)
+
+// umbrellaLock serializes umbrella chart generation, releases of one umbrella are generated concurrently
+var umbrellaLock sync.Mutex
+
+// SubchartSrcDir returns the directory below srcDir member charts of an umbrella chart live in
+func SubchartSrcDir(srcDir, umbrellaName string) string {
+	return filepath.Join(srcDir, umbrellaName, chartutil.ChartsDir)
+}
+
+// NewUmbrellaMemberCharts generates the charts of a release as subcharts of its umbrella chart
+// and regenerates the umbrella chart
+func NewUmbrellaMemberCharts(log *logrus.Entry, helmSettings *common.HelmSettings, umbrella *common.Umbrella, chartName string, crdMode string, m *common.Manifests) (*HelmizedManifests, error) {
+	memberSettings := *helmSettings
+	memberSettings.SrcDir = SubchartSrcDir(helmSettings.SrcDir, umbrella.ChartName)
+	memberSettings.OutputDir = SubchartSrcDir(helmSettings.BuildDir(), umbrella.ChartName)
+	charts, err := NewHelmCharts(log, &memberSettings, chartName, crdMode, m)
+	if err != nil {
+		return nil, err
+	}
+
+	umbrellaLock.Lock()
+	defer umbrellaLock.Unlock()
+	umbrellaChart, err := NewUmbrellaChart(log, helmSettings, umbrella)
+	if err != nil {
+		return nil, err
+	}
+	charts.Path = helmSettings.BuildDir()
+	charts.Umbrella = umbrellaChart
+	return charts, nil
+}
+
+// NewUmbrellaChart writes the umbrella Chart.yaml listing every member chart as dependency, members generated
+// in this run win over committed ones, the version is the committed umbrella version with the patch bumped
+func NewUmbrellaChart(log *logrus.Entry, helmSettings *common.HelmSettings, umbrella *common.Umbrella) (*chart.Chart, error) {
+	version, err := umbrellaVersion(helmSettings.SrcDir, umbrella)
+	if err != nil {
+		return nil, err
+	}
+
+	// values maintained in the committed umbrella values.yaml are kept, only the aliased subcharts' are regenerated
+	values, err := umbrellaValues(helmSettings.SrcDir, umbrella)
+	if err != nil {
+		return nil, err
+	}
+	aliased := false
+	dependencies := make([]*chart.Dependency, 0)
+	for _, member := range umbrella.Releases {
+		crdsChartName, err := CrdChartName(member, helmSettings)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range []string{member, crdsChartName} {
+			memberVersion, found, err := subchartVersion(name, umbrella.ChartName, helmSettings.BuildDir(), helmSettings.SrcDir)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				continue
+			}
+			dependency := &chart.Dependency{
+				Name:      name,
+				Version:   memberVersion,
+				Condition: name + ".enabled",
+			}
+			// the condition and values of an aliased subchart only resolve under its alias, committed overrides
+			// there are kept, those still under the subchart name are moved below them, and the subchart's own
+			// values.yaml still provides the defaults
+			if alias := umbrella.Aliases[name]; alias != "" {
+				aliasValues, ok := values[alias].(map[string]any)
+				if !ok {
+					aliasValues = map[string]any{}
+				}
+				if nameValues, ok := values[name].(map[string]any); ok {
+					aliasValues = *common.DeepMerge(&nameValues, &aliasValues)
+					delete(values, name)
+					log.Infof("Moved umbrella values of subchart %s under its alias %s", name, alias)
+				}
+				if _, ok := aliasValues["enabled"]; !ok {
+					aliasValues["enabled"] = true
+				}
+				dependency.Alias = alias
+				dependency.Condition = alias + ".enabled"
+				values[alias] = aliasValues
+				aliased = true
+			}
+			dependencies = append(dependencies, dependency)
+		}
+	}
+
+	metadata := &chart.Metadata{
+		APIVersion:   chart.APIVersionV2,
+		Name:         umbrella.ChartName,
+		Description:  umbrella.Description,
+		Type:         "application",
+		Version:      version,
+		Dependencies: dependencies,
+	}
+	if err := metadata.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid umbrella chart %s: %w", umbrella.ChartName, err)
+	}
+
+	chartPath := filepath.Join(helmSettings.BuildDir(), umbrella.ChartName)
+	if err := os.MkdirAll(chartPath, 0755); err != nil {
+		log.Errorf("Failed to create umbrella chart directory %s: %v", chartPath, err)
+		return nil, err
+	}
+	if err := chartutil.SaveChartfile(filepath.Join(chartPath, chartutil.ChartfileName), metadata); err != nil {
+		log.Errorf("Failed to save umbrella chart %s: %v", chartPath, err)
+		return nil, err
+	}
+	umbrellaChart := &chart.Chart{Metadata: metadata}
+	if aliased {
+		valuesPath := filepath.Join(chartPath, chartutil.ValuesfileName)
+		valuesData, err := yaml.Marshal(values)
+		if err != nil {
+			log.Errorf("Failed to marshal values of umbrella chart %s: %v", umbrella.ChartName, err)
+			return nil, err
+		}
+		if err := os.WriteFile(valuesPath, valuesData, 0644); err != nil {
+			log.Errorf("Failed to save umbrella values %s: %v", valuesPath, err)
+			return nil, err
+		}
+		umbrellaChart.Values = values
+	}
+	log.Infof("Updated umbrella chart %s %s with %d subcharts", umbrella.ChartName, version, len(dependencies))
+
+	return umbrellaChart, nil
+}
+
+// umbrellaValues reads the committed values.yaml of the umbrella chart, empty when there is none
+func umbrellaValues(committedSrcDir string, umbrella *common.Umbrella) (map[string]any, error) {
+	values, err := chartutil.ReadValuesFile(filepath.Join(committedSrcDir, umbrella.ChartName, chartutil.ValuesfileName))
+	if os.IsNotExist(err) {
+		return map[string]any{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read values of umbrella chart %s: %w", umbrella.ChartName, err)
+	}
+	return values, nil
+}
+
+// umbrellaVersion bumps the patch of the committed umbrella chart, a new umbrella starts at its initial version
+func umbrellaVersion(committedSrcDir string, umbrella *common.Umbrella) (string, error) {
+	if !fileExists(filepath.Join(committedSrcDir, umbrella.ChartName, chartutil.ChartfileName)) {
+		if umbrella.InitialVersion != "" {
+			return umbrella.InitialVersion, nil
+		}
+		return defaultUmbrellaVersion, nil
+	}
+	current, _, err := ResolveBaselineVersions(committedSrcDir, umbrella.ChartName, umbrella.InitialVersion)
+	if err != nil {
+		return "", err
+	}
+	version, err := semver.NewVersion(current)
+	if err != nil {
+		return "", fmt.Errorf("umbrella chart %s has invalid version %s: %w", umbrella.ChartName, current, err)
+	}
+	return version.IncPatch().String(), nil
+}
+
+// subchartVersion looks up the version of a member chart in the first of srcDirs holding it
+func subchartVersion(name, umbrellaName string, srcDirs ...string) (string, bool, error) {
+	for _, srcDir := range srcDirs {
+		dir := SubchartSrcDir(srcDir, umbrellaName)
+		if !fileExists(filepath.Join(dir, name, chartutil.ChartfileName)) {
+			continue
+		}
+		version, _, err := PeekVersions(dir, name)
+		if err != nil {
+			return "", false, err
+		}
+		return version, true, nil
+	}
+	return "", false, nil
+}
@@ -0,0 +1,88 @@
+package packager
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/krezh/charts/internal/common"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+func TestNewUmbrellaChart(t *testing.T) {
+	//given
+	committedDir := t.TempDir()
+	buildDir := t.TempDir()
+	writeTestFile(t, filepath.Join(committedDir, "platform", "Chart.yaml"), "apiVersion: v2\nname: platform\nversion: 1.0.0\n")
+	writeTestFile(t, filepath.Join(SubchartSrcDir(committedDir, "platform"), "cdi", "Chart.yaml"), "apiVersion: v2\nname: cdi\nversion: 1.1.0\n")
+	writeTestFile(t, filepath.Join(SubchartSrcDir(buildDir, "platform"), "kubevirt", "Chart.yaml"), "apiVersion: v2\nname: kubevirt\nversion: 2.0.0\n")
+	writeTestFile(t, filepath.Join(SubchartSrcDir(buildDir, "platform"), "kubevirt-crds", "Chart.yaml"), "apiVersion: v2\nname: kubevirt-crds\nversion: 2.0.0\n")
+	umbrella := &common.Umbrella{ChartName: "platform", Releases: []string{"kubevirt", "cdi"}}
+
+	//when
+	ch, err := NewUmbrellaChart(testLog(), &common.HelmSettings{SrcDir: committedDir, OutputDir: buildDir}, umbrella)
+
+	//then
+	if err != nil {
+		t.Fatalf("NewUmbrellaChart() error = %v", err)
+	}
+	if ch.Metadata.Version != "1.0.1" {
+		t.Errorf("NewUmbrellaChart() version = %s, want 1.0.1", ch.Metadata.Version)
+	}
+	want := map[string]string{"kubevirt": "2.0.0", "kubevirt-crds": "2.0.0", "cdi": "1.1.0"}
+	if len(ch.Metadata.Dependencies) != len(want) {
+		t.Fatalf("NewUmbrellaChart() dependencies = %d, want %d", len(ch.Metadata.Dependencies), len(want))
+	}
+	for _, dep := range ch.Metadata.Dependencies {
+		if want[dep.Name] != dep.Version {
+			t.Errorf("NewUmbrellaChart() dependency %s = %s, want %s", dep.Name, dep.Version, want[dep.Name])
+		}
+	}
+	version, _, err := PeekVersions(buildDir, "platform")
+	if err != nil || version != "1.0.1" {
+		t.Errorf("PeekVersions() = %s, %v, want 1.0.1", version, err)
+	}
+}
+
+func TestNewUmbrellaChartAliases(t *testing.T) {
+	//given
+	committedDir := t.TempDir()
+	buildDir := t.TempDir()
+	writeTestFile(t, filepath.Join(committedDir, "platform", "values.yaml"), "global:\n  registry: quay.io\nvirt:\n  replicas: 5\nkubevirt:\n  replicas: 3\n  logVerbosity: 2\n")
+	writeTestFile(t, filepath.Join(SubchartSrcDir(buildDir, "platform"), "kubevirt", "Chart.yaml"), "apiVersion: v2\nname: kubevirt\nversion: 2.0.0\n")
+	writeTestFile(t, filepath.Join(SubchartSrcDir(buildDir, "platform"), "kubevirt", "values.yaml"), "replicas: 2\n")
+	writeTestFile(t, filepath.Join(SubchartSrcDir(buildDir, "platform"), "cdi", "Chart.yaml"), "apiVersion: v2\nname: cdi\nversion: 1.1.0\n")
+	umbrella := &common.Umbrella{ChartName: "platform", Releases: []string{"kubevirt", "cdi"}, Aliases: map[string]string{"kubevirt": "virt"}}
+
+	//when
+	ch, err := NewUmbrellaChart(testLog(), &common.HelmSettings{SrcDir: committedDir, OutputDir: buildDir}, umbrella)
+
+	//then
+	if err != nil {
+		t.Fatalf("NewUmbrellaChart() error = %v", err)
+	}
+	for _, dep := range ch.Metadata.Dependencies {
+		wantAlias, wantCondition := "", dep.Name+".enabled"
+		if dep.Name == "kubevirt" {
+			wantAlias, wantCondition = "virt", "virt.enabled"
+		}
+		if dep.Alias != wantAlias || dep.Condition != wantCondition {
+			t.Errorf("NewUmbrellaChart() dependency %s alias = %q, condition = %q, want %q, %q", dep.Name, dep.Alias, dep.Condition, wantAlias, wantCondition)
+		}
+	}
+	values, err := chartutil.ReadValuesFile(filepath.Join(buildDir, "platform", chartutil.ValuesfileName))
+	if err != nil {
+		t.Fatalf("ReadValuesFile() error = %v", err)
+	}
+	want := map[string]any{
+		"global": map[string]any{"registry": "quay.io"},
+		"virt":   map[string]any{"replicas": float64(5), "logVerbosity": float64(2), "enabled": true},
+	}
+	if !reflect.DeepEqual(values.AsMap(), want) {
+		t.Errorf("NewUmbrellaChart() values = %v, want %v", values.AsMap(), want)
+	}
+	files := (&HelmizedManifests{Umbrella: ch}).UmbrellaFiles()
+	if !reflect.DeepEqual(files, []string{"platform/Chart.yaml", "platform/values.yaml"}) {
+		t.Errorf("UmbrellaFiles() = %v", files)
+	}
+}
@@ -0,0 +1,167 @@
+package packager
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/krezh/charts/internal/common"
+	"github.com/sirupsen/logrus"
+	"helm.sh/helm/v3/pkg/chart"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	structuralschema "k8s.io/apiextensions-apiserver/pkg/apiserver/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+var (
+	templateActionRegex = regexp.MustCompile(`(?s)\{\{.*?\}\}`)
+	// valueReferenceRegex matches .Values paths, also continued behind parentheses, e.g. ((.Values.a).b)
+	valueReferenceRegex = regexp.MustCompile(`\.Values((?:\.\w+)+)((?:\)+(?:\.\w+)+)*)`)
+)
+
+// danglingValueReferences returns value paths referenced by templates that are missing in values
+func danglingValueReferences(templates []*chart.File, values map[string]any) []string {
+	missing := make(map[string]bool)
+	for _, tmpl := range templates {
+		for _, action := range templateActionRegex.FindAllString(string(tmpl.Data), -1) {
+			for _, match := range valueReferenceRegex.FindAllStringSubmatch(action, -1) {
+				path := strings.TrimPrefix(match[1], ".") + strings.ReplaceAll(match[2], ")", "")
+				if !valuePathExists(values, path) {
+					missing[path] = true
+				}
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(missing))
+	for path := range missing {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func valuePathExists(values map[string]any, path string) bool {
+	var node any = values
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := node.(map[string]any)
+		if !ok {
+			return false
+		}
+		if node, ok = obj[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// validateValueReferences reports template references to values missing in ch.Values according to policy
+func validateValueReferences(log *logrus.Entry, ch *chart.Chart, policy string) error {
+	switch policy {
+	case common.DanglingValuesIgnore:
+		return nil
+	case "", common.DanglingValuesWarn, common.DanglingValuesFail:
+	default:
+		return fmt.Errorf("unknown danglingValues '%s'", policy)
+	}
+	missing := danglingValueReferences(ch.Templates, ch.Values)
+	if len(missing) == 0 {
+		return nil
+	}
+	if policy == common.DanglingValuesFail {
+		return fmt.Errorf("chart %s references undefined values: %v", ch.Metadata.Name, missing)
+	}
+	log.Warnf("Chart %s references undefined values: %v", ch.Metadata.Name, missing)
+	return nil
+}
+
+// validateCrds checks every apiextensions.k8s.io/v1 CRD against the structural schema rules the API server
+// enforces, all violations are reported at once, CRDs of other API versions are skipped with a warning
+func validateCrds(log *logrus.Entry, crds []map[string]any) error {
+	violations := make([]string, 0)
+	for _, crd := range crds {
+		metadata, _ := crd["metadata"].(map[string]any)
+		name := fmt.Sprint(metadata["name"])
+		if crd["apiVersion"] != apiextensionsv1.SchemeGroupVersion.String() {
+			log.Warnf("Not validating CRD %s of apiVersion %v, only %s is supported", name, crd["apiVersion"], apiextensionsv1.SchemeGroupVersion)
+			continue
+		}
+		errs, err := structuralSchemaErrors(crd)
+		if err != nil {
+			return fmt.Errorf("failed to validate CRD %s: %w", name, err)
+		}
+		for _, e := range errs {
+			log.Errorf("CRD %s violates structural schema rules: %v", name, e)
+			violations = append(violations, fmt.Sprintf("%s: %v", name, e))
+		}
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("%d structural schema violations in CRDs: %s", len(violations), strings.Join(violations, "; "))
+	}
+	return nil
+}
+
+// structuralSchemaErrors returns the structural schema violations of the schema of every version of crd, fields
+// templated by modifications are left out as they don't unmarshal into their API types
+func structuralSchemaErrors(crd map[string]any) (field.ErrorList, error) {
+	data, err := json.Marshal(withoutTemplatedFields(crd))
+	if err != nil {
+		return nil, err
+	}
+	var definition apiextensionsv1.CustomResourceDefinition
+	if err := json.Unmarshal(data, &definition); err != nil {
+		return nil, err
+	}
+
+	var errs field.ErrorList
+	for i, version := range definition.Spec.Versions {
+		fldPath := field.NewPath("spec", "versions").Index(i).Child("schema", "openAPIV3Schema")
+		if version.Schema == nil || version.Schema.OpenAPIV3Schema == nil {
+			errs = append(errs, field.Required(fldPath, fmt.Sprintf("schema of version %s", version.Name)))
+			continue
+		}
+		var props apiextensions.JSONSchemaProps
+		if err := apiextensionsv1.Convert_v1_JSONSchemaProps_To_apiextensions_JSONSchemaProps(version.Schema.OpenAPIV3Schema, &props, nil); err != nil {
+			return nil, err
+		}
+		structural, err := structuralschema.NewStructural(&props)
+		if err != nil {
+			errs = append(errs, field.Invalid(fldPath, version.Name, err.Error()))
+			continue
+		}
+		errs = append(errs, structuralschema.ValidateStructural(fldPath, structural)...)
+	}
+	return errs, nil
+}
+
+// withoutTemplatedFields returns a copy of value without the map entries and list items holding template actions
+func withoutTemplatedFields(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		kept := make(map[string]any, len(v))
+		for key, item := range v {
+			if !isTemplated(key) && !isTemplated(item) {
+				kept[key] = withoutTemplatedFields(item)
+			}
+		}
+		return kept
+	case []any:
+		kept := make([]any, 0, len(v))
+		for _, item := range v {
+			if !isTemplated(item) {
+				kept = append(kept, withoutTemplatedFields(item))
+			}
+		}
+		return kept
+	default:
+		return value
+	}
+}
+
+func isTemplated(value any) bool {
+	s, ok := value.(string)
+	return ok && strings.Contains(s, "{{")
+}
@@ -0,0 +1,124 @@
+package packager
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/krezh/charts/internal/common"
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+func TestDanglingValueReferences(t *testing.T) {
+	//given
+	templates := []*chart.File{
+		{
+			Name: "templates/deployment.yaml",
+			Data: []byte("replicas: {{ .Values.operator.replicas }}\nimage: {{ .Values.operator.image.repository }}:{{ .Values.operator.image.tag }}"),
+		},
+		{
+			Name: "templates/kubevirt.yaml",
+			Data: []byte("configuration: {{ .Values.kubevirt.configuration | toYaml | nindent 8 }}\npolicy: {{ .Values.kubevirt.typo }}"),
+		},
+		{
+			Name: "templates/nested.yaml",
+			Data: []byte("tag: {{ ((.Values.operator).image).tag }}\nrepository: {{ (.Values.operator.image).repository }}\n{{- if\n  .Values.enabled }}{{ end }}"),
+		},
+	}
+	values := map[string]any{
+		"operator": map[string]any{
+			"replicas": 1,
+			"image":    map[string]any{"repository": "quay.io/kubevirt/virt-operator"},
+		},
+		"kubevirt": map[string]any{
+			"configuration": nil,
+		},
+	}
+
+	//when
+	missing := danglingValueReferences(templates, values)
+
+	//then
+	want := []string{"enabled", "kubevirt.typo", "operator.image.tag"}
+	if !reflect.DeepEqual(missing, want) {
+		t.Errorf("danglingValueReferences() = %v, want %v", missing, want)
+	}
+}
+
+func TestValidateValueReferences(t *testing.T) {
+	ch := &chart.Chart{
+		Metadata:  &chart.Metadata{Name: "test"},
+		Templates: []*chart.File{{Name: "templates/a.yaml", Data: []byte("a: {{ .Values.missing }}")}},
+		Values:    map[string]any{},
+	}
+	testCases := map[string]struct {
+		policy  string
+		wantErr bool
+	}{
+		"default":                   {policy: "", wantErr: false},
+		common.DanglingValuesIgnore: {policy: common.DanglingValuesIgnore, wantErr: false},
+		common.DanglingValuesWarn:   {policy: common.DanglingValuesWarn, wantErr: false},
+		common.DanglingValuesFail:   {policy: common.DanglingValuesFail, wantErr: true},
+		"unknown":                   {policy: "error", wantErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//when
+			err := validateValueReferences(testLog(), ch, tc.policy)
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Errorf("validateValueReferences() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateCrds(t *testing.T) {
+	crd := func(apiVersion string, schema map[string]any) map[string]any {
+		version := map[string]any{"name": "v1", "served": true, "storage": true}
+		if schema != nil {
+			version["schema"] = map[string]any{"openAPIV3Schema": schema}
+		}
+		return map[string]any{
+			"apiVersion": apiVersion,
+			"kind":       "CustomResourceDefinition",
+			"metadata":   map[string]any{"name": "things.example.com"},
+			"spec": map[string]any{
+				"group":    "example.com",
+				"names":    map[string]any{"kind": "Thing", "plural": "things"},
+				"scope":    "Namespaced",
+				"versions": []any{version},
+			},
+		}
+	}
+	structural := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"spec": map[string]any{"type": "object", "properties": map[string]any{"replicas": map[string]any{"type": "integer"}}},
+		},
+	}
+	templated := crd("apiextensions.k8s.io/v1", structural)
+	templated["metadata"].(map[string]any)["annotations"] = "{{ .Values.annotations | toYaml | nindent 4 }}"
+	templated["spec"].(map[string]any)["preserveUnknownFields"] = "{{ .Values.preserveUnknownFields }}"
+	testCases := map[string]struct {
+		crd     map[string]any
+		wantErr bool
+	}{
+		"structural":     {crd: crd("apiextensions.k8s.io/v1", structural)},
+		"missing_type":   {crd: crd("apiextensions.k8s.io/v1", map[string]any{"type": "object", "properties": map[string]any{"spec": map[string]any{}}}), wantErr: true},
+		"missing_schema": {crd: crd("apiextensions.k8s.io/v1", nil), wantErr: true},
+		"v1beta1":        {crd: crd("apiextensions.k8s.io/v1beta1", nil)},
+		"templated":      {crd: templated},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//when
+			err := validateCrds(testLog(), []map[string]any{tc.crd})
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Errorf("validateCrds() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
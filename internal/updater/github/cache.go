@@ -0,0 +1,114 @@
+package github
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/krezh/charts/internal/common"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrNotCached is returned by CachedManifests when no assets of the release were cached yet
+var ErrNotCached = errors.New("release not cached")
+
+// cachedRelease holds the assets of the last fetched release of a chart as downloaded, before preprocessing
+type cachedRelease struct {
+	AppVersion string            `yaml:"appVersion"`
+	Assets     map[string]string `yaml:"assets"`
+}
+
+func cachePath(cacheDir, chartName string) string {
+	return filepath.Join(cacheDir, chartName+".yaml")
+}
+
+// readCache returns the cached release of the chart, nil when there is none
+func readCache(cacheDir, chartName string) (*cachedRelease, error) {
+	data, err := os.ReadFile(cachePath(cacheDir, chartName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cached cachedRelease
+	if err := yaml.Unmarshal(data, &cached); err != nil {
+		return nil, fmt.Errorf("invalid cache %s: %w", cachePath(cacheDir, chartName), err)
+	}
+	return &cached, nil
+}
+
+// cached tells whether the cache holds the assets of appVersion, always true without a cache
+func cached(cacheDir, chartName, appVersion string) bool {
+	if cacheDir == "" {
+		return true
+	}
+	release, err := readCache(cacheDir, chartName)
+	return err == nil && release != nil && release.AppVersion == appVersion
+}
+
+// writeCache replaces the cached release of the chart, an interrupted write leaves the previous cache intact
+func writeCache(cacheDir, chartName, appVersion string, assetsData map[string][]byte) error {
+	release := cachedRelease{AppVersion: appVersion, Assets: make(map[string]string, len(assetsData))}
+	for name, data := range assetsData {
+		release.Assets[name] = string(data)
+	}
+	data, err := yaml.Marshal(release)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(cacheDir, "."+chartName+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), cachePath(cacheDir, chartName))
+}
+
+// CachedManifests collects the manifests of the release cached by the last online FetchManifests,
+// versioned as FetchManifests would and with the release's current values, GitHub is never called
+func CachedManifests(log *logrus.Entry, releaseConfig *common.GithubRelease, cacheDir, existingVersion, versionSuffix string) (*common.Manifests, error) {
+	if cacheDir == "" {
+		return nil, fmt.Errorf("%w: helm.cacheDir is not configured", ErrNotCached)
+	}
+	release, err := readCache(cacheDir, releaseConfig.ChartName)
+	if err != nil {
+		return nil, err
+	}
+	if release == nil {
+		return nil, fmt.Errorf("%w: no cached assets of %s in %s, run update mode online first", ErrNotCached, releaseConfig.Repo, cacheDir)
+	}
+	log.Infof("Using cached release %s of %s", release.AppVersion, releaseConfig.Repo)
+
+	version, err := takeNewerVersion(log, existingVersion, release.AppVersion, releaseConfig.PrereleaseVersions)
+	if err != nil {
+		return nil, err
+	}
+	if version == nil {
+		return nil, nil
+	}
+	suffixed, err := common.WithVersionSuffix(*version, versionSuffix)
+	if err != nil {
+		return nil, err
+	}
+	assetsData := make(map[string][]byte, len(release.Assets))
+	for name, data := range release.Assets {
+		assetsData[name] = []byte(data)
+	}
+	if err := preprocessAssets(log, releaseConfig, assetsData); err != nil {
+		return nil, err
+	}
+	return common.NewManifests(&assetsData, &suffixed, release.AppVersion, &releaseConfig.AddValues, &releaseConfig.AddCrdValues)
+}
@@ -0,0 +1,70 @@
+package github
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/krezh/charts/internal/common"
+)
+
+func TestCachedManifests(t *testing.T) {
+	//given
+	cacheDir := t.TempDir()
+	assets := map[string][]byte{
+		"operator.yaml": []byte("kind: Deployment\nmetadata:\n  name: operator\n"),
+		"crds.yaml":     []byte("kind: CustomResourceDefinition\nmetadata:\n  name: things.example.com\n"),
+	}
+	if err := writeCache(cacheDir, "project", "v1.2.0", assets); err != nil {
+		t.Fatalf("writeCache() error = %v", err)
+	}
+	release := &common.GithubRelease{
+		Repo:       "org/project",
+		ChartName:  "project",
+		AddValues:  map[string]any{"replicas": 2, "chartVersion": "${CHART_VERSION}"},
+		Preprocess: []common.AssetReplacement{{Pattern: "name: operator", Replacement: "name: kv-operator"}},
+	}
+
+	//when
+	manifests, err := CachedManifests(testLog(), release, cacheDir, "1.0.0", "-dev.1")
+
+	//then
+	if err != nil {
+		t.Fatalf("CachedManifests() error = %v", err)
+	}
+	if manifests.AppVersion != "v1.2.0" || manifests.Version.String() != "1.2.0-dev.1" {
+		t.Errorf("CachedManifests() versions = %s, %s, want 1.2.0-dev.1, v1.2.0", manifests.Version.String(), manifests.AppVersion)
+	}
+	if len(manifests.Manifests) != 1 || len(manifests.Crds) != 1 {
+		t.Fatalf("CachedManifests() = %d manifests and %d CRDs, want 1 and 1", len(manifests.Manifests), len(manifests.Crds))
+	}
+	if name := manifests.Manifests[0]["metadata"].(map[string]any)["name"]; name != "kv-operator" {
+		t.Errorf("CachedManifests() name = %v, want the preprocessed kv-operator", name)
+	}
+	if cachedRelease, _ := readCache(cacheDir, "project"); cachedRelease.Assets["operator.yaml"] != string(assets["operator.yaml"]) {
+		t.Errorf("CachedManifests() changed the cached raw asset: %s", cachedRelease.Assets["operator.yaml"])
+	}
+	if manifests.Values["replicas"] != 2 || manifests.Values["chartVersion"] != "1.2.0-dev.1" {
+		t.Errorf("CachedManifests() values = %v, want the release's addValues with the suffixed chart version", manifests.Values)
+	}
+	if !cached(cacheDir, "project", "v1.2.0") || cached(cacheDir, "project", "v1.3.0") {
+		t.Errorf("cached() doesn't match the cached release v1.2.0")
+	}
+}
+
+func TestCachedManifestsNotCached(t *testing.T) {
+	testCases := map[string]string{
+		"no_cache_dir": "",
+		"empty_cache":  t.TempDir(),
+	}
+	for name, cacheDir := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//when
+			_, err := CachedManifests(testLog(), &common.GithubRelease{Repo: "org/project", ChartName: "project"}, cacheDir, "1.0.0", "")
+
+			//then
+			if !errors.Is(err, ErrNotCached) {
+				t.Errorf("CachedManifests() error = %v, want %v", err, ErrNotCached)
+			}
+		})
+	}
+}
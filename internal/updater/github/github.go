@@ -5,14 +5,26 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
 
 	"github.com/Masterminds/semver/v3"
 	"github.com/google/go-github/v74/github"
 	"github.com/krezh/charts/internal/common"
+	"github.com/sirupsen/logrus"
 )
 
-// CreatePr creates a Pull Request into default branch
-func CreatePr(ctx context.Context, prSettings *common.PullRequest, srcBranch string) error {
+const (
+	defaultAssetConcurrency = 4
+	defaultMinAssetSize     = 16
+)
+
+// CreatePr creates a Pull Request into default branch, report is appended to the configured body
+func CreatePr(ctx context.Context, prSettings *common.PullRequest, srcBranch, report string) error {
 	defaultBranch := prSettings.DefaultBranch
 
 	if defaultBranch == "" {
@@ -25,13 +37,13 @@ func CreatePr(ctx context.Context, prSettings *common.PullRequest, srcBranch str
 		return fmt.Errorf("source branch equals default branch")
 	}
 
-	client := github.NewClient(nil).WithAuthToken(prSettings.AuthToken)
+	client := newClient().WithAuthToken(prSettings.AuthToken)
 
 	newPR := &github.NewPullRequest{
-		Title: github.Ptr(fmt.Sprintf(prSettings.Title, srcBranch)),
+		Title: github.Ptr(PrTitle(prSettings, srcBranch)),
 		Head:  github.Ptr(srcBranch),
 		Base:  github.Ptr(defaultBranch),
-		Body:  github.Ptr(prSettings.Body),
+		Body:  github.Ptr(PrBody(prSettings, report)),
 	}
 
 	pr, resp, err := client.PullRequests.Create(ctx, prSettings.Owner, prSettings.Repo, newPR)
@@ -47,52 +59,184 @@ func CreatePr(ctx context.Context, prSettings *common.PullRequest, srcBranch str
 	return nil
 }
 
-func FetchManifests(ctx context.Context, releaseConfig *common.GithubRelease, existingVersion, existingAppVersion string) (*common.Manifests, error) {
-	client := github.NewClient(nil)
+// PrTitle returns the title of the pull request for srcBranch
+func PrTitle(prSettings *common.PullRequest, srcBranch string) string {
+	return fmt.Sprintf(prSettings.Title, srcBranch)
+}
+
+// PrBody returns the configured pull request body followed by the report
+func PrBody(prSettings *common.PullRequest, report string) string {
+	if report == "" {
+		return prSettings.Body
+	}
+	if prSettings.Body == "" {
+		return report
+	}
+	return prSettings.Body + "\n\n" + report
+}
+
+// DefaultBranch asks GitHub for the default branch of the pull request repository
+func DefaultBranch(ctx context.Context, prSettings *common.PullRequest) (string, error) {
+	client := newClient().WithAuthToken(prSettings.AuthToken)
+	repo, _, err := client.Repositories.Get(ctx, prSettings.Owner, prSettings.Repo)
+	if err != nil {
+		return "", fmt.Errorf("failed to detect default branch of %s/%s: %w", prSettings.Owner, prSettings.Repo, err)
+	}
+	if repo.GetDefaultBranch() == "" {
+		return "", fmt.Errorf("repository %s/%s reports no default branch", prSettings.Owner, prSettings.Repo)
+	}
+	return repo.GetDefaultBranch(), nil
+}
+
+// CheckToken verifies the pull request token with an authenticated read of the pull request repository,
+// GitHub rejects invalid tokens even for public repositories
+func CheckToken(ctx context.Context, prSettings *common.PullRequest) error {
+	if prSettings.AuthToken == "" {
+		return fmt.Errorf("no token, set pr.authToken, GITHUB_TOKEN or pr.authTokenFile")
+	}
+	client := newClient().WithAuthToken(prSettings.AuthToken)
+	if _, _, err := client.Repositories.Get(ctx, prSettings.Owner, prSettings.Repo); err != nil {
+		return fmt.Errorf("token cannot read %s/%s: %w", prSettings.Owner, prSettings.Repo, common.RedactError(err))
+	}
+	return nil
+}
+
+// newClient returns an unauthenticated client identifying itself with the configured user-agent
+func newClient() *github.Client {
+	client := github.NewClient(common.HTTPClient)
+	client.UserAgent = common.UserAgent()
+	return client
+}
+
+// newReleaseClient returns the client reading upstream releases,
+// authenticated with the resolved pr token when set, which raises rate limits and makes drafts visible
+func newReleaseClient(token string) *github.Client {
+	client := newClient()
+	if token != "" {
+		client = client.WithAuthToken(token)
+	}
+	return client
+}
+
+// FetchManifests downloads the assets of the latest release, nil when the chart already tracks it
+// or the release is a prerelease ignored by prereleaseVersions stable,
+// with a cacheDir the assets are cached for CachedManifests, also when the chart is up to date but the cache is not,
+// token authenticates GitHub requests when set, versionSuffix is applied before the values are interpolated
+func FetchManifests(ctx context.Context, log *logrus.Entry, releaseConfig *common.GithubRelease, token, existingVersion, existingAppVersion, cacheDir, versionSuffix string) (*common.Manifests, error) {
+	client := newReleaseClient(token)
 	releaseData, err := downloadReleaseMeta(ctx, client, releaseConfig)
 	if err != nil {
-		common.Log.Errorf("Failed to download release metadata for %s: %v", releaseConfig.Repo, err)
+		log.Errorf("Failed to download release metadata for %s: %v", releaseConfig.Repo, err)
 		return nil, err
 	}
 	releaseVersion := releaseData.TagName
-	common.Log.Infof("Latest release for %s: %s", releaseConfig.Repo, *releaseVersion)
+	log.Infof("Latest release for %s: %s", releaseConfig.Repo, *releaseVersion)
 
-	if existingAppVersion == *releaseVersion {
-		common.Log.Infof("Helm chart %s is already up to date with version %s", releaseConfig.ChartName, existingAppVersion)
+	upToDate := existingAppVersion == *releaseVersion
+	if upToDate && cached(cacheDir, releaseConfig.ChartName, *releaseVersion) {
+		log.Infof("Helm chart %s is already up to date with version %s", releaseConfig.ChartName, existingAppVersion)
 		return nil, nil
 	}
-	version, err := takeNewerVersion(existingVersion, *releaseVersion) //todo add test for this
+	version, err := takeNewerVersion(log, existingVersion, *releaseVersion, releaseConfig.PrereleaseVersions)
+	if err != nil {
+		return nil, err
+	}
+	if version == nil {
+		return nil, nil
+	}
+	suffixed, err := common.WithVersionSuffix(*version, versionSuffix)
+	if err != nil {
+		return nil, err
+	}
 
-	assetsData, err := downloadAssets(ctx, client, releaseConfig, releaseData)
+	assetsData, err := downloadAssets(ctx, log, client, token, releaseConfig, releaseData)
 	if err != nil {
-		common.Log.Errorf("Failed to download assets for release %s: %v", releaseConfig.Repo, err)
+		log.Errorf("Failed to download assets for release %s: %v", releaseConfig.Repo, err)
 		return nil, err
 	}
-	manifests, err := common.NewManifests(assetsData, version, *releaseVersion, &releaseConfig.AddValues, &releaseConfig.AddCrdValues)
+	if cacheDir != "" {
+		// a failing cache only affects later regenerations, not this run
+		if err := writeCache(cacheDir, releaseConfig.ChartName, *releaseVersion, *assetsData); err != nil {
+			log.Warnf("Failed to cache assets of release %s: %v", releaseConfig.Repo, err)
+		}
+	}
+	if upToDate {
+		log.Infof("Helm chart %s is already up to date with version %s, cached its assets", releaseConfig.ChartName, existingAppVersion)
+		return nil, nil
+	}
+	// the cache holds the raw assets, the current preprocess rules also apply when regenerating
+	if err := preprocessAssets(log, releaseConfig, *assetsData); err != nil {
+		return nil, err
+	}
+	manifests, err := common.NewManifests(assetsData, &suffixed, *releaseVersion, &releaseConfig.AddValues, &releaseConfig.AddCrdValues)
 	if err != nil {
-		common.Log.Errorf("Failed to collect manifests for release %s: %v", releaseConfig.Repo, err)
+		log.Errorf("Failed to collect manifests for release %s: %v", releaseConfig.Repo, err)
 		return nil, err
 	}
 	return manifests, nil
 }
 
-func takeNewerVersion(existingVersion, remoteVersion string) (*semver.Version, error) {
-	semverExisting, _ := semver.NewVersion(existingVersion)
+// LatestReleaseVersion returns the tag of the latest upstream release without downloading any assets
+func LatestReleaseVersion(ctx context.Context, releaseConfig *common.GithubRelease, token string) (string, error) {
+	releaseData, err := downloadReleaseMeta(ctx, newReleaseClient(token), releaseConfig)
+	if err != nil {
+		common.Log.Errorf("Failed to download release metadata for %s: %v", releaseConfig.Repo, err)
+		return "", err
+	}
+	return releaseData.GetTagName(), nil
+}
+
+// IgnoredPrerelease tells whether version is a prerelease the release never updates to with prereleaseVersions stable
+func IgnoredPrerelease(releaseConfig *common.GithubRelease, version string) bool {
+	if releaseConfig.PrereleaseVersions != common.PrereleaseVersionsStable {
+		return false
+	}
+	v, err := semver.NewVersion(version)
+	return err == nil && v.Prerelease() != ""
+}
+
+// takeNewerVersion returns the version of the chart tracking remoteVersion, the remote version when it is newer than
+// the existing one by SemVer precedence, e.g. 1.0.0-rc.1 < 1.0.0-rc.2 < 1.0.0, the existing one otherwise,
+// nil when prereleaseVersions is stable and the remote version is a prerelease, the chart is not updated then
+func takeNewerVersion(log *logrus.Entry, existingVersion, remoteVersion, prereleaseVersions string) (*semver.Version, error) {
+	switch prereleaseVersions {
+	case "", common.PrereleaseVersionsTrack, common.PrereleaseVersionsStable:
+	default:
+		return nil, fmt.Errorf("unknown prereleaseVersions '%s'", prereleaseVersions)
+	}
+	semverExisting, existingErr := semver.NewVersion(existingVersion)
 	semverRemote, err := semver.NewVersion(remoteVersion)
 	if err != nil {
-		common.Log.Warnf("Remote version %s is not valid SemVer: %v, will use existing Chart's version: %s", remoteVersion, err, existingVersion)
+		if existingErr != nil {
+			return nil, fmt.Errorf("neither remote version %s nor existing version %s is valid SemVer: %w", remoteVersion, existingVersion, err)
+		}
+		log.Warnf("Remote version %s is not valid SemVer: %v, will use existing Chart's version: %s", remoteVersion, err, existingVersion)
 		return semverExisting, nil
 	}
+	if semverRemote.Prerelease() != "" && prereleaseVersions == common.PrereleaseVersionsStable {
+		log.Infof("Remote version %s is a prerelease, prereleaseVersions stable keeps the chart at version %s", remoteVersion, existingVersion)
+		return nil, nil
+	}
 
-	if semverRemote.Compare(semverExisting) < 0 {
+	if existingErr == nil && semverRemote.Compare(semverExisting) < 0 {
 		return semverExisting, nil
-	} else {
-		return semverRemote, nil
 	}
+	return semverRemote, nil
 }
 
+// downloadReleaseMeta downloads the pinned release, or the latest one, a pinned releaseId wins over
+// includePrereleases and includeDrafts
 func downloadReleaseMeta(ctx context.Context, client *github.Client, release *common.GithubRelease) (*github.RepositoryRelease, error) {
-	repoRelease, response, err := client.Repositories.GetLatestRelease(ctx, release.Owner, release.Repo)
+	if release.ReleaseID == 0 && (release.IncludePrereleases || release.IncludeDrafts) {
+		return downloadNewestReleaseMeta(ctx, client, release)
+	}
+	getRelease := client.Repositories.GetLatestRelease
+	if release.ReleaseID != 0 {
+		getRelease = func(ctx context.Context, owner, repo string) (*github.RepositoryRelease, *github.Response, error) {
+			return client.Repositories.GetRelease(ctx, owner, repo, release.ReleaseID)
+		}
+	}
+	repoRelease, response, err := getRelease(ctx, release.Owner, release.Repo)
 	if err != nil || response.StatusCode != http.StatusOK {
 		if response != nil {
 			err = fmt.Errorf("failed to download release: %v, status: %d", err, response.StatusCode)
@@ -103,41 +247,254 @@ func downloadReleaseMeta(ctx context.Context, client *github.Client, release *co
 	return repoRelease, nil
 }
 
-func downloadReleaseAsset(ctx context.Context, client *github.Client, release *common.GithubRelease, asset *github.ReleaseAsset) ([]byte, error) {
+// downloadNewestReleaseMeta lists the most recent releases and picks the newest by SemVer,
+// prereleases and drafts are only considered when enabled for the release
+func downloadNewestReleaseMeta(ctx context.Context, client *github.Client, release *common.GithubRelease) (*github.RepositoryRelease, error) {
+	repoReleases, response, err := client.Repositories.ListReleases(ctx, release.Owner, release.Repo, &github.ListOptions{PerPage: 100})
+	if err != nil || response.StatusCode != http.StatusOK {
+		if response != nil {
+			err = fmt.Errorf("failed to list releases: %v, status: %d", err, response.StatusCode)
+		}
+		return nil, err
+	}
+
+	// prereleases would hide older stable releases from charts never updated to prereleases
+	includePrereleases := release.IncludePrereleases && release.PrereleaseVersions != common.PrereleaseVersionsStable
+	newest := newestRelease(repoReleases, includePrereleases, release.IncludeDrafts)
+	if newest == nil {
+		return nil, fmt.Errorf("no release with a SemVer tag found for %s/%s", release.Owner, release.Repo)
+	}
+	return newest, nil
+}
+
+// newestRelease returns the release with the highest SemVer tag, releases with other tags are ignored
+func newestRelease(repoReleases []*github.RepositoryRelease, includePrereleases, includeDrafts bool) *github.RepositoryRelease {
+	var newest *github.RepositoryRelease
+	var newestVersion *semver.Version
+	for _, repoRelease := range repoReleases {
+		if repoRelease.GetDraft() && !includeDrafts {
+			continue
+		}
+		if repoRelease.GetPrerelease() && !includePrereleases {
+			continue
+		}
+		version, err := semver.NewVersion(repoRelease.GetTagName())
+		if err != nil {
+			common.Log.Debugf("Ignoring release %s without SemVer tag", repoRelease.GetTagName())
+			continue
+		}
+		if newestVersion == nil || version.GreaterThan(newestVersion) {
+			newest = repoRelease
+			newestVersion = version
+		}
+	}
+	return newest
+}
+
+func downloadReleaseAsset(ctx context.Context, log *logrus.Entry, client *github.Client, release *common.GithubRelease, asset *github.ReleaseAsset) ([]byte, error) {
 	reader, _, err := client.Repositories.DownloadReleaseAsset(ctx, release.Owner, release.Repo, asset.GetID(), client.Client())
 	if err != nil {
-		common.Log.Errorf("Failed to download release asset: %v", err)
+		log.Errorf("Failed to download release asset: %v", err)
 		return nil, err
 	}
 	defer reader.Close()
 
 	assetData, err := io.ReadAll(reader)
 	if err != nil {
-		common.Log.Errorf("Failed to read release asset data: %v", err)
+		log.Errorf("Failed to read release asset data: %v", err)
 		return nil, err
 	}
 
 	return assetData, nil
 }
 
-func downloadAssets(ctx context.Context, client *github.Client, releaseConfig *common.GithubRelease, releaseData *github.RepositoryRelease) (*map[string][]byte, error) {
+// downloadAssets downloads the assets of a release matching Assets or AssetContentTypes, up to AssetConcurrency at a time,
+// followed by the files matching SourcePaths in the release's source tarball, token authenticates assetURLs on GitHub hosts
+func downloadAssets(ctx context.Context, log *logrus.Entry, client *github.Client, token string, releaseConfig *common.GithubRelease, releaseData *github.RepositoryRelease) (*map[string][]byte, error) {
 	assetsData := make(map[string][]byte)
-	for _, asset := range releaseConfig.Assets {
-		assetsData[asset] = []byte{}
+	downloads := make(map[string]func(context.Context) ([]byte, error))
+	contentTypeMatched := false
+	for _, asset := range releaseData.Assets {
+		byContentType := hasContentType(asset, releaseConfig.AssetContentTypes)
+		if !slices.Contains(releaseConfig.Assets, asset.GetName()) && !byContentType {
+			continue
+		}
+		contentTypeMatched = contentTypeMatched || byContentType
+		downloads[asset.GetName()] = func(ctx context.Context) ([]byte, error) {
+			return downloadReleaseAsset(ctx, log, client, releaseConfig, asset)
+		}
+	}
+	if missing := missingAssets(releaseConfig.Assets, downloads); len(missing) > 0 {
+		return nil, fmt.Errorf("assets %s not found on release %s %s", strings.Join(missing, ", "), releaseConfig.Repo, releaseData.GetTagName())
+	}
+	if len(releaseConfig.AssetContentTypes) > 0 && !contentTypeMatched {
+		return nil, fmt.Errorf("no assets of content types %s found on release %s %s", strings.Join(releaseConfig.AssetContentTypes, ", "), releaseConfig.Repo, releaseData.GetTagName())
+	}
+	for _, assetURL := range releaseConfig.AssetURLs {
+		name, err := assetURLName(assetURL)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := downloads[name]; exists {
+			return nil, fmt.Errorf("asset URL %s collides with asset %s of release %s", assetURL, name, releaseConfig.Repo)
+		}
+		downloads[name] = func(ctx context.Context) ([]byte, error) {
+			return downloadAssetURL(ctx, assetURL, token)
+		}
 	}
 
-	for _, asset := range releaseData.Assets {
-		if _, ok := assetsData[asset.GetName()]; ok {
-			data, err := downloadReleaseAsset(ctx, client, releaseConfig, asset)
+	concurrency := releaseConfig.AssetConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultAssetConcurrency
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	slots := make(chan struct{}, concurrency)
+	for name, download := range downloads {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			data, err := download(ctx)
+			mu.Lock()
+			defer mu.Unlock()
 			if err != nil {
-				common.Log.Errorf("Failed to download asset %s for release %s: %v", asset.GetName(), releaseConfig.Repo, err)
-				return nil, err
+				log.Errorf("Failed to download asset %s for release %s: %v", name, releaseConfig.Repo, err)
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
 			}
-			common.Log.Infof("Downloaded asset %s for release %s, size: %d bytes", asset.GetName(), releaseConfig.Repo, len(data))
-
-			assetsData[asset.GetName()] = data
+			log.Infof("Downloaded asset %s for release %s, size: %d bytes", name, releaseConfig.Repo, len(data))
+			assetsData[name] = data
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if len(releaseConfig.SourcePaths) > 0 {
+		sourceFiles, err := downloadSourceManifests(ctx, log, client, releaseConfig, releaseData)
+		if err != nil {
+			return nil, err
+		}
+		for name, data := range sourceFiles {
+			if _, exists := assetsData[name]; exists {
+				return nil, fmt.Errorf("source file %s collides with an asset of release %s", name, releaseConfig.Repo)
+			}
+			assetsData[name] = data
 		}
 	}
-	common.Log.Infof("Total assets downloaded for release %s: %d", releaseConfig.Repo, len(assetsData))
+	if err := checkAssetSizes(log, releaseConfig, assetsData); err != nil {
+		return nil, err
+	}
+
+	log.Infof("Total assets downloaded for release %s: %d", releaseConfig.Repo, len(assetsData))
 	return &assetsData, nil
 }
+
+// checkAssetSizes fails on assets below MinAssetSize, an empty asset almost always means a broken upstream release
+func checkAssetSizes(log *logrus.Entry, releaseConfig *common.GithubRelease, assetsData map[string][]byte) error {
+	if releaseConfig.SkipAssetSizeCheck {
+		return nil
+	}
+	minSize := releaseConfig.MinAssetSize
+	if minSize <= 0 {
+		minSize = defaultMinAssetSize
+	}
+	suspicious := make([]string, 0)
+	for name, data := range assetsData {
+		if len(data) < minSize {
+			log.Warnf("Asset %s of release %s is suspiciously small: %d bytes", name, releaseConfig.Repo, len(data))
+			suspicious = append(suspicious, name)
+		}
+	}
+	if len(suspicious) > 0 {
+		slices.Sort(suspicious)
+		return fmt.Errorf("assets %s of release %s are smaller than %d bytes, set skipAssetSizeCheck if intended", strings.Join(suspicious, ", "), releaseConfig.Repo, minSize)
+	}
+	return nil
+}
+
+// preprocessAssets applies the release's Preprocess replacements in order to every asset, fixing
+// upstream assets that are not valid YAML as released
+func preprocessAssets(log *logrus.Entry, releaseConfig *common.GithubRelease, assetsData map[string][]byte) error {
+	for i, replacement := range releaseConfig.Preprocess {
+		pattern, err := regexp.Compile(replacement.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid preprocess pattern %d of release %s: %w", i, releaseConfig.Repo, err)
+		}
+		for name, data := range assetsData {
+			matches := len(pattern.FindAllIndex(data, -1))
+			if matches == 0 {
+				continue
+			}
+			assetsData[name] = pattern.ReplaceAll(data, []byte(replacement.Replacement))
+			log.Infof("Preprocessed asset %s of release %s: %d replacements of %s", name, releaseConfig.Repo, matches, replacement.Pattern)
+		}
+	}
+	return nil
+}
+
+// hasContentType tells whether the asset's media type is one of contentTypes, parameters like charset are ignored
+func hasContentType(asset *github.ReleaseAsset, contentTypes []string) bool {
+	mediaType, _, _ := strings.Cut(asset.GetContentType(), ";")
+	return slices.ContainsFunc(contentTypes, func(contentType string) bool {
+		return strings.EqualFold(strings.TrimSpace(mediaType), contentType)
+	})
+}
+
+// missingAssets lists the configured asset names without a matching release asset
+func missingAssets(assets []string, downloads map[string]func(context.Context) ([]byte, error)) []string {
+	missing := make([]string, 0)
+	for _, asset := range assets {
+		if _, found := downloads[asset]; !found {
+			missing = append(missing, asset)
+		}
+	}
+	return missing
+}
+
+// assetURLName names an asset downloaded from a URL by the last segment of its path
+func assetURLName(assetURL string) (string, error) {
+	u, err := url.Parse(assetURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return "", fmt.Errorf("invalid asset URL %s", assetURL)
+	}
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		return "", fmt.Errorf("asset URL %s has no file name", assetURL)
+	}
+	return name, nil
+}
+
+// downloadAssetURL fetches an asset over plain HTTP, the token is only sent to GitHub hosts
+func downloadAssetURL(ctx context.Context, assetURL, token string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, assetURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" && isGithubHost(req.URL.Hostname()) {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := common.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: status: %d", assetURL, resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func isGithubHost(host string) bool {
+	return host == "github.com" || strings.HasSuffix(host, ".github.com") || strings.HasSuffix(host, ".githubusercontent.com")
+}
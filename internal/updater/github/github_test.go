@@ -0,0 +1,384 @@
+package github
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v74/github"
+	"github.com/krezh/charts/internal/common"
+	"github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	common.Setup("debug")
+	exitVal := m.Run()
+	os.Exit(exitVal)
+}
+
+func TestNewestRelease(t *testing.T) {
+	releases := []*github.RepositoryRelease{
+		{TagName: github.Ptr("v1.2.0")},
+		{TagName: github.Ptr("v1.3.0-rc.1"), Prerelease: github.Ptr(true)},
+		{TagName: github.Ptr("v1.4.0"), Draft: github.Ptr(true)},
+		{TagName: github.Ptr("nightly")},
+		{TagName: github.Ptr("v1.1.5")},
+	}
+	testCases := map[string]struct {
+		includePrereleases bool
+		includeDrafts      bool
+		want               string
+	}{
+		"stable_only": {
+			want: "v1.2.0",
+		},
+		"prereleases": {
+			includePrereleases: true,
+			want:               "v1.3.0-rc.1",
+		},
+		"drafts": {
+			includeDrafts: true,
+			want:          "v1.4.0",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//when
+			got := newestRelease(releases, tc.includePrereleases, tc.includeDrafts)
+
+			//then
+			if got.GetTagName() != tc.want {
+				t.Errorf("newestRelease() = %s, want %s", got.GetTagName(), tc.want)
+			}
+		})
+	}
+}
+
+func TestTakeNewerVersion(t *testing.T) {
+	testCases := map[string]struct {
+		existing           string
+		remote             string
+		prereleaseVersions string
+		want               string
+		wantErr            bool
+	}{
+		"newer_release": {
+			existing: "1.0.0",
+			remote:   "v1.1.0",
+			want:     "1.1.0",
+		},
+		"older_release": {
+			existing: "1.2.0",
+			remote:   "v1.1.0",
+			want:     "1.2.0",
+		},
+		"newer_prerelease": {
+			existing: "1.0.0-rc.1",
+			remote:   "v1.0.0-rc.2",
+			want:     "1.0.0-rc.2",
+		},
+		"numeric_prerelease_ordering": {
+			existing: "1.0.0-rc.9",
+			remote:   "v1.0.0-rc.10",
+			want:     "1.0.0-rc.10",
+		},
+		"final_after_prerelease": {
+			existing: "1.0.0-rc.2",
+			remote:   "v1.0.0",
+			want:     "1.0.0",
+		},
+		"prerelease_after_final": {
+			existing: "1.0.0",
+			remote:   "v1.0.0-rc.3",
+			want:     "1.0.0",
+		},
+		"stable_ignores_prerelease": {
+			existing:           "1.0.0",
+			remote:             "v1.1.0-rc.1",
+			prereleaseVersions: common.PrereleaseVersionsStable,
+		},
+		"stable_takes_release": {
+			existing:           "1.0.0-rc.1",
+			remote:             "v1.0.0",
+			prereleaseVersions: common.PrereleaseVersionsStable,
+			want:               "1.0.0",
+		},
+		"track_explicit": {
+			existing:           "1.0.0",
+			remote:             "v1.1.0-rc.1",
+			prereleaseVersions: common.PrereleaseVersionsTrack,
+			want:               "1.1.0-rc.1",
+		},
+		"invalid_remote": {
+			existing: "1.0.0",
+			remote:   "nightly",
+			want:     "1.0.0",
+		},
+		"invalid_existing": {
+			existing: "",
+			remote:   "v1.0.0",
+			want:     "1.0.0",
+		},
+		"both_invalid": {
+			existing: "",
+			remote:   "nightly",
+			wantErr:  true,
+		},
+		"unknown_policy": {
+			existing:           "1.0.0",
+			remote:             "v1.1.0",
+			prereleaseVersions: "latest",
+			wantErr:            true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//when
+			got, err := takeNewerVersion(testLog(), tc.existing, tc.remote, tc.prereleaseVersions)
+
+			//then
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("takeNewerVersion() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if tc.want == "" {
+				if got != nil {
+					t.Errorf("takeNewerVersion() = %s, want nil", got)
+				}
+				return
+			}
+			if got == nil || got.String() != tc.want {
+				t.Errorf("takeNewerVersion() = %v, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDownloadAssetsFromURLs(t *testing.T) {
+	//given
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/manifests/operator.yaml" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "" {
+			http.Error(w, "token sent to a host other than GitHub", http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte("kind: Deployment\n"))
+	}))
+	defer server.Close()
+	release := &common.GithubRelease{Repo: "test", AssetURLs: []string{server.URL + "/manifests/operator.yaml"}}
+
+	//when
+	assets, err := downloadAssets(context.Background(), testLog(), nil, "token", release, &github.RepositoryRelease{})
+
+	//then
+	if err != nil {
+		t.Fatalf("downloadAssets() error = %v", err)
+	}
+	if string((*assets)["operator.yaml"]) != "kind: Deployment\n" {
+		t.Errorf("downloadAssets() = %v, want operator.yaml", *assets)
+	}
+
+	//when
+	release.AssetURLs = []string{server.URL + "/missing.yaml"}
+	_, err = downloadAssets(context.Background(), testLog(), nil, "", release, &github.RepositoryRelease{})
+
+	//then
+	if err == nil {
+		t.Errorf("downloadAssets() expected error for missing asset URL")
+	}
+}
+
+func TestDownloadAssetsMissing(t *testing.T) {
+	//given
+	release := &common.GithubRelease{Repo: "test", Assets: []string{"operator.yaml", "crds.yaml"}}
+	releaseData := &github.RepositoryRelease{
+		TagName: github.Ptr("v1.0.0"),
+		Assets:  []*github.ReleaseAsset{{Name: github.Ptr("other.yaml")}},
+	}
+
+	//when
+	_, err := downloadAssets(context.Background(), testLog(), nil, "", release, releaseData)
+
+	//then
+	if err == nil || !strings.Contains(err.Error(), "operator.yaml, crds.yaml") {
+		t.Errorf("downloadAssets() error = %v, want missing operator.yaml, crds.yaml", err)
+	}
+}
+
+func TestCheckAssetSizes(t *testing.T) {
+	assets := map[string][]byte{"operator.yaml": []byte("kind: Deployment\nmetadata: {}\n"), "crds.yaml": {}}
+	tests := map[string]struct {
+		release *common.GithubRelease
+		wantErr bool
+	}{
+		"empty asset fails":        {release: &common.GithubRelease{Repo: "test"}, wantErr: true},
+		"skipped check":            {release: &common.GithubRelease{Repo: "test", SkipAssetSizeCheck: true}, wantErr: false},
+		"configured minimum fails": {release: &common.GithubRelease{Repo: "test", MinAssetSize: 1024}, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			//when
+			err := checkAssetSizes(testLog(), tt.release, assets)
+
+			//then
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkAssetSizes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	//given
+	nonEmpty := map[string][]byte{"operator.yaml": assets["operator.yaml"]}
+
+	//when
+	err := checkAssetSizes(testLog(), &common.GithubRelease{Repo: "test"}, nonEmpty)
+
+	//then
+	if err != nil {
+		t.Errorf("checkAssetSizes() error = %v", err)
+	}
+}
+
+func TestPreprocessAssets(t *testing.T) {
+	asset := "---\napiVersion: v1\nkind: Namespace\nmetadata:\n  name: operator\n---\nkind: Deployment\nmetadata:\n  namespace: operator\n"
+	tests := map[string]struct {
+		preprocess []common.AssetReplacement
+		want       string
+		wantErr    bool
+	}{
+		"no replacements": {want: asset},
+		"in order": {
+			preprocess: []common.AssetReplacement{
+				{Pattern: `\A---\n`, Replacement: ""},
+				{Pattern: `(?s)apiVersion: v1\nkind: Namespace\n.*?---\n`, Replacement: ""},
+				{Pattern: `namespace: (\w+)`, Replacement: "namespace: ${1}-system"},
+			},
+			want: "kind: Deployment\nmetadata:\n  namespace: operator-system\n",
+		},
+		"invalid pattern": {preprocess: []common.AssetReplacement{{Pattern: "("}}, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			//given
+			assets := map[string][]byte{"operator.yaml": []byte(asset)}
+			release := &common.GithubRelease{Repo: "test", Preprocess: tt.preprocess}
+
+			//when
+			err := preprocessAssets(testLog(), release, assets)
+
+			//then
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("preprocessAssets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(assets["operator.yaml"]) != tt.want {
+				t.Errorf("preprocessAssets() = %q, want %q", assets["operator.yaml"], tt.want)
+			}
+		})
+	}
+}
+
+func TestHasContentType(t *testing.T) {
+	testCases := map[string]struct {
+		contentType string
+		want        bool
+	}{
+		"exact":           {contentType: "application/x-yaml", want: true},
+		"case":            {contentType: "Application/X-YAML", want: true},
+		"with_params":     {contentType: "application/x-yaml; charset=utf-8", want: true},
+		"other":           {contentType: "application/gzip", want: false},
+		"no_content_type": {contentType: "", want: false},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//given
+			asset := &github.ReleaseAsset{ContentType: github.Ptr(tc.contentType)}
+
+			//when
+			got := hasContentType(asset, []string{"application/x-yaml"})
+
+			//then
+			if got != tc.want {
+				t.Errorf("hasContentType() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDownloadAssetsNoContentTypeMatch(t *testing.T) {
+	//given
+	release := &common.GithubRelease{Repo: "test", AssetContentTypes: []string{"application/x-yaml"}}
+	releaseData := &github.RepositoryRelease{
+		TagName: github.Ptr("v1.0.0"),
+		Assets:  []*github.ReleaseAsset{{Name: github.Ptr("source.tar.gz"), ContentType: github.Ptr("application/gzip")}},
+	}
+
+	//when
+	_, err := downloadAssets(context.Background(), testLog(), nil, "", release, releaseData)
+
+	//then
+	if err == nil || !strings.Contains(err.Error(), "application/x-yaml") {
+		t.Errorf("downloadAssets() error = %v, want no assets of content type application/x-yaml", err)
+	}
+}
+
+func TestPrBody(t *testing.T) {
+	testCases := map[string]struct {
+		body   string
+		report string
+		want   string
+	}{
+		"body_only":   {body: "Automated PR", want: "Automated PR"},
+		"report_only": {report: "### Lint findings", want: "### Lint findings"},
+		"both":        {body: "Automated PR", report: "### Lint findings", want: "Automated PR\n\n### Lint findings"},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//when
+			got := PrBody(&common.PullRequest{Body: tc.body}, tc.report)
+
+			//then
+			if got != tc.want {
+				t.Errorf("PrBody() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func testLog() *logrus.Entry {
+	return common.Log.WithField("release", "test")
+}
+
+func TestDownloadReleaseMetaPinned(t *testing.T) {
+	//given
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/kubevirt/kubevirt/releases/42" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"id": 42, "tag_name": "v1.0.0"}`))
+	}))
+	defer server.Close()
+	client := github.NewClient(server.Client())
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	release := &common.GithubRelease{Owner: "kubevirt", Repo: "kubevirt", ReleaseID: 42, IncludePrereleases: true, IncludeDrafts: true}
+
+	//when
+	releaseData, err := downloadReleaseMeta(context.Background(), client, release)
+
+	//then
+	if err != nil {
+		t.Fatalf("downloadReleaseMeta() error = %v", err)
+	}
+	if releaseData.GetTagName() != "v1.0.0" {
+		t.Errorf("downloadReleaseMeta() = %s, want the pinned release v1.0.0", releaseData.GetTagName())
+	}
+}
@@ -0,0 +1,112 @@
+package github
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/google/go-github/v74/github"
+	"github.com/krezh/charts/internal/common"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	archiveMaxRedirects = 3
+)
+
+// downloadSourceManifests downloads the source tarball of a release and returns the files matching
+// SourcePaths, keyed by their path within the repository
+func downloadSourceManifests(ctx context.Context, log *logrus.Entry, client *github.Client, releaseConfig *common.GithubRelease, releaseData *github.RepositoryRelease) (map[string][]byte, error) {
+	archiveURL, response, err := client.Repositories.GetArchiveLink(ctx, releaseConfig.Owner, releaseConfig.Repo, github.Tarball,
+		&github.RepositoryContentGetOptions{Ref: releaseData.GetTagName()}, archiveMaxRedirects)
+	if err != nil {
+		if response != nil {
+			err = fmt.Errorf("failed to get source archive link: %v, status: %d", err, response.StatusCode)
+		}
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, archiveURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := common.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download source archive of release %s %s, status: %d", releaseConfig.Repo, releaseData.GetTagName(), resp.StatusCode)
+	}
+
+	files, err := extractSourceFiles(resp.Body, releaseConfig.SourcePaths)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract source archive of release %s %s: %w", releaseConfig.Repo, releaseData.GetTagName(), err)
+	}
+	log.Infof("Extracted %d source files for release %s", len(files), releaseConfig.Repo)
+	return files, nil
+}
+
+// extractSourceFiles reads the files matching any of patterns from a gzipped source tarball,
+// paths are matched without the archive's top-level directory, every pattern has to match a file
+func extractSourceFiles(archive io.Reader, patterns []string) (map[string][]byte, error) {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid source path %s: %w", pattern, err)
+		}
+	}
+
+	gz, err := gzip.NewReader(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	files := make(map[string][]byte)
+	matched := make(map[string]bool)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		_, filePath, found := strings.Cut(header.Name, "/")
+		if !found {
+			continue
+		}
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, filePath); !ok {
+				continue
+			}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			files[filePath] = data
+			matched[pattern] = true
+			break
+		}
+	}
+
+	missing := make([]string, 0)
+	for _, pattern := range patterns {
+		if !matched[pattern] {
+			missing = append(missing, pattern)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("source paths %s matched no files", strings.Join(missing, ", "))
+	}
+	return files, nil
+}
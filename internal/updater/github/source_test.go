@@ -0,0 +1,68 @@
+package github
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"testing"
+)
+
+func sourceArchive(t *testing.T, files map[string]string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestExtractSourceFiles(t *testing.T) {
+	files := map[string]string{
+		"project-v1.0.0/deploy/operator.yaml":  "kind: Deployment\n",
+		"project-v1.0.0/deploy/crds.yaml":      "kind: CustomResourceDefinition\n",
+		"project-v1.0.0/deploy/test/test.yaml": "kind: Pod\n",
+		"project-v1.0.0/README.md":             "# project\n",
+	}
+	tests := map[string]struct {
+		patterns []string
+		want     []string
+		wantErr  bool
+	}{
+		"glob":                    {patterns: []string{"deploy/*.yaml"}, want: []string{"deploy/operator.yaml", "deploy/crds.yaml"}},
+		"exact file":              {patterns: []string{"deploy/operator.yaml"}, want: []string{"deploy/operator.yaml"}},
+		"pattern matches nothing": {patterns: []string{"deploy/*.yaml", "manifests/*.yaml"}, wantErr: true},
+		"invalid pattern":         {patterns: []string{"deploy/[.yaml"}, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			//when
+			got, err := extractSourceFiles(sourceArchive(t, files), tt.patterns)
+
+			//then
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("extractSourceFiles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("extractSourceFiles() = %d files, want %d", len(got), len(tt.want))
+			}
+			for _, path := range tt.want {
+				if got[path] == nil {
+					t.Errorf("extractSourceFiles() missing %s", path)
+				}
+			}
+		})
+	}
+}